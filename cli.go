@@ -0,0 +1,611 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"godice/config"
+	ha "godice/homeassistiant"
+	pix "godice/pixel"
+	"godice/rules"
+	"io/fs"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"tinygo.org/x/bluetooth"
+)
+
+// command is a godice subcommand
+type command struct {
+	name    string
+	usage   string
+	summary string
+	// needHA commands require a valid Home Assistant config
+	needHA bool
+	run    func(args []string) error
+}
+
+var commands = []command{
+	{name: "scan", usage: "scan [--duration 5s] [--json]", summary: "list nearby dice with their ID, RSSI and battery", run: scanCommand},
+	{name: "watch", usage: "watch [--passive] [--json]", summary: "stream rolls from every die", run: watchCommand},
+	{name: "blink", usage: "blink <id> [--color red] [--count 3] [--json]", summary: "flash a die's LEDs", run: blinkCommand},
+	{name: "info", usage: "info <id> [--json]", summary: "connect to a die and show its details", run: infoCommand},
+	{name: "rename", usage: "rename <id> <name> [--json]", summary: "change a die's name", run: renameCommand},
+	{name: "run", usage: "run", summary: "react to throws with the configured rules (default)", needHA: true, run: runCommand},
+	{name: "ha", usage: "ha test [--json]", summary: "check the Home Assistant URL, token and lights", needHA: true, run: haCommand},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: godice [--config config.yaml] <command> [arguments]\n\nCommands:\n")
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.usage, cmd.summary)
+	}
+	_ = w.Flush()
+	fmt.Fprintf(out, "\nDice are identified by PixelId, in decimal or 0x hex, or by a configured nickname.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// runCLI dispatches args to their subcommand, running the rules daemon when no command is given
+func runCLI(configFile string, args []string) error {
+	if len(args) == 0 {
+		args = []string{"run"}
+	}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		var err error
+		conf, err = loadConfig(configFile, cmd.needHA)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", configFile, err)
+		}
+		return cmd.run(args[1:])
+	}
+	flag.Usage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// loadConfig loads the config file. Commands that only talk to dice work without
+// a config file and without Home Assistant settings.
+func loadConfig(file string, needHA bool) (*config.AppConfig, error) {
+	if needHA {
+		return config.LoadConfig(file)
+	}
+
+	loaded, err := config.Load(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		loaded = config.Default()
+		loaded.Rules = rules.DefaultRules()
+		err = loaded.ApplyEnv(os.LookupEnv)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	check := *loaded
+	check.Outputs.HomeAssistant = false
+	if err := check.Validate(); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// parseArgs parses flags that may appear before, between or after positional arguments
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseDieId accepts a PixelId in decimal or 0x hex, or a configured nickname
+func parseDieId(s string) (uint32, error) {
+	for id, nickname := range conf.Dice.Nicknames {
+		if strings.EqualFold(nickname, s) {
+			return id, nil
+		}
+	}
+	id, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid die id %q", s)
+	}
+	return uint32(id), nil
+}
+
+// signalContext is cancelled on interrupt
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func enableAdapter() (*bluetooth.Adapter, error) {
+	adapter := bluetooth.DefaultAdapter
+	if err := adapter.Enable(); err != nil {
+		return nil, fmt.Errorf("failed to enable BLE stack: %w", err)
+	}
+	return adapter, nil
+}
+
+func managerOptions() pix.ManagerOptions {
+	return pix.ManagerOptions{
+		AllowIds:       conf.Dice.Allow,
+		DenyIds:        conf.Dice.Deny,
+		MaxConnections: conf.Scan.MaxConnections,
+		Backoff: pix.Backoff{
+			Initial:    conf.Reconnect.InitialBackoff,
+			Max:        conf.Reconnect.MaxBackoff,
+			Multiplier: conf.Reconnect.Multiplier,
+		},
+		Passive:        conf.Scan.Passive,
+		PassiveTimeout: conf.Scan.PassiveTimeout,
+	}
+}
+
+// connectDie scans for the die with the given ID and connects to it.
+// The die stays connected until ctx is done.
+func connectDie(ctx context.Context, id uint32, timeout time.Duration) (*pix.Die, error) {
+	adapter, err := enableAdapter()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := managerOptions()
+	opts.AllowIds = []uint32{id}
+	opts.DenyIds = nil
+	opts.MaxConnections = 0
+	opts.Passive = false
+	manager := pix.NewManager(adapter, opts)
+	events, unsubscribe := manager.Events(1)
+	defer unsubscribe()
+	go func() {
+		_ = manager.Run(ctx)
+	}()
+
+	select {
+	case evt := <-events:
+		return evt.Die, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("die %s not found within %s", formatId(id), timeout)
+	}
+}
+
+func formatId(id uint32) string {
+	return fmt.Sprintf("0x%08x", id)
+}
+
+// dieInfo is the JSON form of a die's state
+type dieInfo struct {
+	PixelId            uint32   `json:"pixel_id"`
+	Name               string   `json:"name"`
+	DieType            string   `json:"die_type"`
+	Face               int      `json:"face"`
+	RollState          string   `json:"roll_state"`
+	BatteryLevel       uint8    `json:"battery_level"`
+	BatteryCharging    bool     `json:"battery_charging"`
+	Rssi               int8     `json:"rssi"`
+	LedCount           uint8    `json:"led_count,omitempty"`
+	DesignAndColor     uint8    `json:"design_and_color,omitempty"`
+	BuildTimestamp     uint32   `json:"build_timestamp,omitempty"`
+	McuTemperature     *float64 `json:"mcu_temperature,omitempty"`
+	BatteryTemperature *float64 `json:"battery_temperature,omitempty"`
+}
+
+func newDieInfo(state pix.DieState) dieInfo {
+	return dieInfo{
+		PixelId:         state.PixelId,
+		Name:            dieName(state.PixelId, state.Name),
+		DieType:         state.DieType.String(),
+		Face:            state.CurrentFaceValue,
+		RollState:       pix.RollStateName(state.RollState),
+		BatteryLevel:    state.BatteryLevel,
+		BatteryCharging: state.BatteryCharging,
+		Rssi:            state.Rssi,
+		LedCount:        state.LedCount,
+		DesignAndColor:  state.DesignAndColor,
+		BuildTimestamp:  state.BuildTimestamp,
+	}
+}
+
+func printJSON(v any) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+func scanCommand(args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ExitOnError)
+	duration := flags.Duration("duration", 5*time.Second, "how long to scan")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+
+	adapter, err := enableAdapter()
+	if err != nil {
+		return err
+	}
+	opts := managerOptions()
+	opts.Passive = true
+	opts.PassiveTimeout = *duration * 2
+	manager := pix.NewManager(adapter, opts)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	ctx, cancelScan := context.WithTimeout(ctx, *duration)
+	defer cancelScan()
+	_ = manager.Run(ctx)
+
+	dice := make([]dieInfo, 0, manager.Registry().Len())
+	for _, state := range manager.Registry().Snapshots() {
+		dice = append(dice, newDieInfo(state))
+	}
+	if *asJSON {
+		return printJSON(dice)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTYPE\tRSSI\tBATTERY\tFACE")
+	for _, d := range dice {
+		charging := ""
+		if d.BatteryCharging {
+			charging = " (charging)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d%%%s\t%d\n", formatId(d.PixelId), d.Name, d.DieType, d.Rssi, d.BatteryLevel, charging, d.Face)
+	}
+	return w.Flush()
+}
+
+// rollEvent is the JSON form of a die event printed by watch
+type rollEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	dieInfo
+}
+
+func watchCommand(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	passive := flags.Bool("passive", conf.Scan.Passive, "read rolls from advertisements without connecting")
+	asJSON := flags.Bool("json", false, "print one JSON object per line")
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+
+	adapter, err := enableAdapter()
+	if err != nil {
+		return err
+	}
+	opts := managerOptions()
+	opts.Passive = *passive
+	manager := pix.NewManager(adapter, opts)
+	managerEvents, unsubscribe := manager.Events(8)
+	defer unsubscribe()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	go func() {
+		_ = manager.Run(ctx)
+	}()
+
+	// forwarders stops each die's event forwarder when the manager loses it
+	forwarders := make(map[uint32]context.CancelFunc)
+	dieEvents := make(chan pix.Event, 16)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case evt := <-managerEvents:
+			switch evt.Type {
+			case pix.ManagerEventDiscovered:
+				if stop, ok := forwarders[evt.PixelId]; ok {
+					stop()
+				}
+				dieCtx, stop := context.WithCancel(ctx)
+				forwarders[evt.PixelId] = stop
+				go forwardEvents(dieCtx, evt.Die, dieEvents)
+			case pix.ManagerEventLost:
+				if stop, ok := forwarders[evt.PixelId]; ok {
+					stop()
+					delete(forwarders, evt.PixelId)
+				}
+			}
+		case evt := <-dieEvents:
+			printDieEvent(evt, *asJSON)
+		}
+	}
+}
+
+func forwardEvents(ctx context.Context, die *pix.Die, out chan<- pix.Event) {
+	events, unsubscribe := die.Subscribe(8)
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-events:
+			if !ok {
+				return
+			}
+			switch evt.Type {
+			case pix.EventRolled, pix.EventCrooked, pix.EventConnected, pix.EventDisconnected:
+				select {
+				case out <- evt:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+func printDieEvent(evt pix.Event, asJSON bool) {
+	if asJSON {
+		_ = printJSON(rollEvent{Time: evt.Time, Event: evt.Type.String(), dieInfo: newDieInfo(evt.DieState)})
+		return
+	}
+
+	name := dieName(evt.PixelId, evt.Name)
+	stamp := evt.Time.Format("15:04:05")
+	switch evt.Type {
+	case pix.EventRolled:
+		fmt.Printf("%s %s rolled %d\n", stamp, name, evt.CurrentFaceValue)
+	case pix.EventCrooked:
+		fmt.Printf("%s %s is crooked\n", stamp, name)
+	default:
+		fmt.Printf("%s %s %s\n", stamp, name, evt.Type)
+	}
+}
+
+func blinkCommand(args []string) error {
+	flags := flag.NewFlagSet("blink", flag.ExitOnError)
+	colorName := flags.String("color", "white", "color name or #rrggbb")
+	count := flags.Uint("count", 3, "number of blinks")
+	duration := flags.Duration("duration", time.Second, "total blink duration")
+	timeout := flags.Duration("timeout", 30*time.Second, "how long to look for the die")
+	asJSON := flags.Bool("json", false, "print JSON")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: godice blink <id> [--color red] [--count 3]")
+	}
+	id, err := parseDieId(positional[0])
+	if err != nil {
+		return err
+	}
+	c, err := rules.ParseColor(*colorName)
+	if err != nil {
+		return err
+	}
+	if *count == 0 || *count > 255 {
+		return errors.New("--count must be between 1 and 255")
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	die, err := connectDie(ctx, id, *timeout)
+	if err != nil {
+		return err
+	}
+	waitCtx, cancelWait := context.WithTimeout(ctx, *duration+5*time.Second)
+	defer cancelWait()
+	if err := die.BlinkAndWait(waitCtx, c, uint8(*count), *duration, 0xFFFFFFFF); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(newDieInfo(die.Snapshot()))
+	}
+	fmt.Printf("Blinked %s %d times\n", dieName(id, die.Name()), *count)
+	return nil
+}
+
+func infoCommand(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	timeout := flags.Duration("timeout", 30*time.Second, "how long to look for the die")
+	asJSON := flags.Bool("json", false, "print JSON")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: godice info <id>")
+	}
+	id, err := parseDieId(positional[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	die, err := connectDie(ctx, id, *timeout)
+	if err != nil {
+		return err
+	}
+
+	queryCtx, cancelQuery := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelQuery()
+	if _, err := die.QueryBatteryLevel(queryCtx); err != nil {
+		return err
+	}
+	if _, err := die.QueryRollState(queryCtx); err != nil {
+		return err
+	}
+	if _, err := die.QueryRssi(queryCtx); err != nil {
+		return err
+	}
+	if _, err := die.QueryTemperature(queryCtx); err != nil {
+		return err
+	}
+
+	state := die.Snapshot()
+	info := newDieInfo(state)
+	mcu := float64(state.McuTemperatureTimes100) / 100
+	battery := float64(state.BatteryTemperatureTimes100) / 100
+	info.McuTemperature = &mcu
+	info.BatteryTemperature = &battery
+	if *asJSON {
+		return printJSON(info)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\t%s\n", formatId(info.PixelId))
+	fmt.Fprintf(w, "Name\t%s\n", info.Name)
+	fmt.Fprintf(w, "Type\t%s (%d LEDs)\n", info.DieType, info.LedCount)
+	fmt.Fprintf(w, "Face\t%d (%s)\n", info.Face, info.RollState)
+	fmt.Fprintf(w, "Battery\t%d%%, charging: %v\n", info.BatteryLevel, info.BatteryCharging)
+	fmt.Fprintf(w, "RSSI\t%d\n", info.Rssi)
+	fmt.Fprintf(w, "Temperature\tMCU %.1f°C, battery %.1f°C\n", mcu, battery)
+	fmt.Fprintf(w, "Design and color\t%d\n", info.DesignAndColor)
+	fmt.Fprintf(w, "Firmware\t%s\n", time.Unix(int64(info.BuildTimestamp), 0).UTC().Format(time.RFC3339))
+	return w.Flush()
+}
+
+func renameCommand(args []string) error {
+	flags := flag.NewFlagSet("rename", flag.ExitOnError)
+	timeout := flags.Duration("timeout", 30*time.Second, "how long to look for the die")
+	asJSON := flags.Bool("json", false, "print JSON")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return errors.New("usage: godice rename <id> <name>")
+	}
+	id, err := parseDieId(positional[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	die, err := connectDie(ctx, id, *timeout)
+	if err != nil {
+		return err
+	}
+	old := die.Name()
+	waitCtx, cancelWait := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelWait()
+	if err := die.SetNameAndWait(waitCtx, positional[1]); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(newDieInfo(die.Snapshot()))
+	}
+	fmt.Printf("Renamed %s from %q to %q\n", formatId(id), old, die.Name())
+	return nil
+}
+
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+	MultiDieRunner(ctx)
+	return nil
+}
+
+// lightCheck is the JSON form of one light checked by ha test
+type lightCheck struct {
+	EntityId string `json:"entity_id"`
+	State    string `json:"state,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func haCommand(args []string) error {
+	flags := flag.NewFlagSet("ha", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print JSON")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || positional[0] != "test" {
+		return errors.New("usage: godice ha test")
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if conf.HAConfig.URL == "" {
+		return errors.New("ha.url is not set, set it in the config file or GODICE_HA_URL")
+	}
+	haClient := ha.NewClient(conf.HAConfig.URL, conf.HAConfig.Token)
+
+	// check the URL and token first, so a bad token is reported as such even without lights
+	message, apiErr := haClient.CheckAPI(ctx)
+	failed := 0
+	checks := make([]lightCheck, 0, len(conf.HAConfig.LightEntities))
+	if apiErr == nil {
+		for _, entity := range conf.HAConfig.LightEntities {
+			check := lightCheck{EntityId: entity}
+			state, err := haClient.GetState(ctx, entity)
+			if err != nil {
+				check.Error = err.Error()
+				failed++
+			} else {
+				check.State = state.State
+			}
+			checks = append(checks, check)
+		}
+	}
+
+	if *asJSON {
+		var apiError string
+		if apiErr != nil {
+			apiError = apiErr.Error()
+		}
+		if err := printJSON(struct {
+			URL      string       `json:"url"`
+			Ok       bool         `json:"ok"`
+			API      string       `json:"api,omitempty"`
+			APIError string       `json:"api_error,omitempty"`
+			Lights   []lightCheck `json:"lights"`
+		}{conf.HAConfig.URL, apiErr == nil && failed == 0, message, apiError, checks}); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Home Assistant at %s\n", conf.HAConfig.URL)
+		if apiErr == nil {
+			fmt.Printf("  API: ok (%s)\n", message)
+		} else {
+			fmt.Printf("  API: FAILED %s\n", apiErr)
+		}
+		if apiErr == nil && len(checks) == 0 {
+			fmt.Println("  no lights configured in ha.light_entities")
+		}
+		for _, check := range checks {
+			if check.Error != "" {
+				fmt.Printf("  %s: FAILED %s\n", check.EntityId, check.Error)
+			} else {
+				fmt.Printf("  %s: ok (%s)\n", check.EntityId, check.State)
+			}
+		}
+	}
+	if errors.Is(apiErr, ha.ErrUnauthorized) {
+		return fmt.Errorf("home assistant rejected the token, check ha.token or GODICE_HA_TOKEN: %w", apiErr)
+	}
+	if apiErr != nil {
+		return fmt.Errorf("cannot reach the Home Assistant API at %s: %w", conf.HAConfig.URL, apiErr)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d lights failed", failed, len(checks))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"godice/config"
+	ha "godice/homeassistiant"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHACommandChecksToken(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/":
+			_, _ = w.Write([]byte(`{"message": "API running."}`))
+		case "/api/states/light.lamp":
+			_, _ = w.Write([]byte(`{"entity_id": "light.lamp", "state": "on"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	saved := conf
+	defer func() { conf = saved }()
+	conf = config.Default()
+	conf.HAConfig.URL = srv.URL
+
+	// without lights a bad token must still fail
+	conf.HAConfig.Token = "bad"
+	if err := haCommand([]string{"test"}); !errors.Is(err, ha.ErrUnauthorized) {
+		t.Errorf("bad token without lights: %v, want ErrUnauthorized", err)
+	}
+	if len(paths) != 1 || paths[0] != "/api/" {
+		t.Errorf("requested %v, want only the API check", paths)
+	}
+
+	paths = nil
+	conf.HAConfig.Token = "good"
+	conf.HAConfig.LightEntities = []string{"light.lamp"}
+	if err := haCommand([]string{"test"}); err != nil {
+		t.Errorf("good token: %v", err)
+	}
+	if strings.Join(paths, " ") != "/api/ /api/states/light.lamp" {
+		t.Errorf("requested %v", paths)
+	}
+
+	conf.HAConfig.URL = "http://127.0.0.1:1"
+	if err := haCommand([]string{"test"}); err == nil || !strings.Contains(err.Error(), "cannot reach") {
+		t.Errorf("unreachable Home Assistant: %v", err)
+	}
+}
@@ -1,31 +1,413 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"godice/rules"
 	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type HAConfig struct {
 	Token         string   `yaml:"token"`
 	URL           string   `yaml:"url"`
 	LightEntities []string `yaml:"light_entities"`
+	// Restore is how lights get their state back after a dice reaction: state, scene or none
+	Restore      string        `yaml:"restore"`
+	RestoreAfter time.Duration `yaml:"restore_after"`
+}
+
+// DiceConfig selects and names dice by PixelId
+type DiceConfig struct {
+	Allow     []uint32          `yaml:"allow"`
+	Deny      []uint32          `yaml:"deny"`
+	Nicknames map[uint32]string `yaml:"nicknames"`
+}
+
+// ScanConfig controls dice discovery and how rolls are grouped into throws
+type ScanConfig struct {
+	Passive        bool          `yaml:"passive"`
+	MaxConnections int           `yaml:"max_connections"`
+	PassiveTimeout time.Duration `yaml:"passive_timeout"`
+	ThrowWindow    time.Duration `yaml:"throw_window"`
+	ThrowTimeout   time.Duration `yaml:"throw_timeout"`
+}
+
+// ReconnectConfig is the backoff policy for reconnecting dropped dice
+type ReconnectConfig struct {
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Multiplier     float64       `yaml:"multiplier"`
+}
+
+// OutputsConfig enables the places throws are reported to
+type OutputsConfig struct {
+	HomeAssistant bool `yaml:"home_assistant"`
+	// RollEvent is the Home Assistant event fired for every settled roll, empty disables it
+	RollEvent string     `yaml:"roll_event"`
+	MQTT      MQTTConfig `yaml:"mqtt"`
+}
+
+// MQTTConfig publishes dice to Home Assistant through an MQTT broker using MQTT discovery
+type MQTTConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Broker is the broker URL, e.g. tcp://localhost:1883 or ssl://broker:8883
+	Broker          string `yaml:"broker"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	ClientId        string `yaml:"client_id"`
+	DiscoveryPrefix string `yaml:"discovery_prefix"`
+	TopicPrefix     string `yaml:"topic_prefix"`
+}
+
+type LoggingConfig struct {
+	Level string `yaml:"level"`
 }
 
 type AppConfig struct {
-	HAConfig HAConfig `yaml:"ha"`
+	HAConfig  HAConfig        `yaml:"ha"`
+	Dice      DiceConfig      `yaml:"dice"`
+	Scan      ScanConfig      `yaml:"scan"`
+	Reconnect ReconnectConfig `yaml:"reconnect"`
+	Rules     []rules.Rule    `yaml:"rules"`
+	Outputs   OutputsConfig   `yaml:"outputs"`
+	Logging   LoggingConfig   `yaml:"logging"`
 }
 
+// Default returns the configuration used for every setting the config file leaves out
+func Default() *AppConfig {
+	return &AppConfig{
+		HAConfig: HAConfig{
+			Restore:      "state",
+			RestoreAfter: 5 * time.Second,
+		},
+		Scan: ScanConfig{
+			PassiveTimeout: 30 * time.Second,
+			ThrowWindow:    time.Second,
+			ThrowTimeout:   10 * time.Second,
+		},
+		Reconnect: ReconnectConfig{
+			InitialBackoff: 500 * time.Millisecond,
+			MaxBackoff:     30 * time.Second,
+			Multiplier:     2,
+		},
+		Outputs: OutputsConfig{
+			HomeAssistant: true,
+			RollEvent:     "godice_roll",
+			MQTT: MQTTConfig{
+				ClientId:        "godice",
+				DiscoveryPrefix: "homeassistant",
+				TopicPrefix:     "godice",
+			},
+		},
+		Logging: LoggingConfig{Level: "info"},
+	}
+}
+
+// LoadConfig reads the config file over the defaults, applies GODICE_* environment overrides and validates the result
 func LoadConfig(file string) (*AppConfig, error) {
-	data, err := os.ReadFile(file)
+	config, err := Load(file)
 	if err != nil {
 		return nil, err
 	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
 
-	config := &AppConfig{}
-	err = yaml.Unmarshal(data, config)
+// Load reads the config file over the defaults and applies GODICE_* environment overrides without validating.
+// Keys that are not settings are rejected so a typo does not silently fall back to a default.
+func Load(file string) (*AppConfig, error) {
+	data, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
 
+	config := Default()
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(config)
+	if err != nil && err != io.EOF {
+		return nil, unknownFields(data, err)
+	}
+
+	if len(config.Rules) == 0 {
+		config.Rules = rules.DefaultRules()
+	}
+	if err := config.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
+
+// unknownFieldPattern matches the yaml.v3 error for a key no setting is named after
+var unknownFieldPattern = regexp.MustCompile(`^line (\d+): field (.+) not found in type `)
+
+// unknownFields turns the errors for misspelled or unsupported keys into ValidationErrors naming
+// the full key path, e.g. ha.restor; any other decoding error is returned as it is
+func unknownFields(data []byte, err error) error {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	var root yaml.Node
+	if yaml.Unmarshal(data, &root) != nil {
+		return err
+	}
+
+	var errs ValidationErrors
+	for _, msg := range typeErr.Errors {
+		match := unknownFieldPattern.FindStringSubmatch(msg)
+		if match == nil {
+			return err
+		}
+		line, _ := strconv.Atoi(match[1])
+		path := keyPath(&root, "", line, match[2])
+		if path == "" {
+			path = match[2]
+		}
+		errs = append(errs, FieldError{Field: path, Message: fmt.Sprintf("is not a known setting (line %d)", line)})
+	}
+	return errs
+}
+
+// keyPath finds the mapping key named key on line and returns its dotted path below prefix
+func keyPath(node *yaml.Node, prefix string, line int, key string) string {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if path := keyPath(child, prefix, line, key); path != "" {
+				return path
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			if node.Content[i].Line == line && node.Content[i].Value == key {
+				return name
+			}
+			if path := keyPath(node.Content[i+1], name, line, key); path != "" {
+				return path
+			}
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			if path := keyPath(child, fmt.Sprintf("%s[%d]", prefix, i), line, key); path != "" {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// ApplyEnv overrides settings from GODICE_* environment variables, looked up with lookup
+func (config *AppConfig) ApplyEnv(lookup func(key string) (string, bool)) error {
+	var errs ValidationErrors
+	str := func(key string, dst *string) {
+		if value, ok := lookup(key); ok {
+			*dst = value
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if value, ok := lookup(key); ok {
+			*dst = splitList(value)
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if value, ok := lookup(key); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, FieldError{Field: key, Message: "must be true or false"})
+				return
+			}
+			*dst = b
+		}
+	}
+	integer := func(key string, dst *int) {
+		if value, ok := lookup(key); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, FieldError{Field: key, Message: "must be a whole number"})
+				return
+			}
+			*dst = n
+		}
+	}
+
+	str("GODICE_HA_TOKEN", &config.HAConfig.Token)
+	str("GODICE_HA_URL", &config.HAConfig.URL)
+	list("GODICE_HA_LIGHT_ENTITIES", &config.HAConfig.LightEntities)
+	boolean("GODICE_SCAN_PASSIVE", &config.Scan.Passive)
+	integer("GODICE_SCAN_MAX_CONNECTIONS", &config.Scan.MaxConnections)
+	boolean("GODICE_OUTPUTS_HOME_ASSISTANT", &config.Outputs.HomeAssistant)
+	str("GODICE_ROLL_EVENT", &config.Outputs.RollEvent)
+	boolean("GODICE_MQTT_ENABLED", &config.Outputs.MQTT.Enabled)
+	str("GODICE_MQTT_BROKER", &config.Outputs.MQTT.Broker)
+	str("GODICE_MQTT_USERNAME", &config.Outputs.MQTT.Username)
+	str("GODICE_MQTT_PASSWORD", &config.Outputs.MQTT.Password)
+	str("GODICE_LOG_LEVEL", &config.Logging.Level)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// FieldError describes a single invalid setting
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors lists every invalid setting found in a config
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks every setting, returning ValidationErrors listing all problems
+func (config *AppConfig) Validate() error {
+	var errs ValidationErrors
+	add := func(field string, format string, args ...any) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if config.Outputs.HomeAssistant {
+		if config.HAConfig.URL == "" {
+			add("ha.url", "is required")
+		} else if u, err := url.Parse(config.HAConfig.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("ha.url", "must be an http or https URL, got %q", config.HAConfig.URL)
+		}
+		if config.HAConfig.Token == "" {
+			add("ha.token", "is required, set it in the file or GODICE_HA_TOKEN")
+		}
+		if len(config.HAConfig.LightEntities) == 0 {
+			add("ha.light_entities", "needs at least one light")
+		}
+		for i, entity := range config.HAConfig.LightEntities {
+			if !strings.HasPrefix(entity, "light.") {
+				add(fmt.Sprintf("ha.light_entities[%d]", i), "must be a light entity, got %q", entity)
+			}
+		}
+		switch config.HAConfig.Restore {
+		case "state", "scene", "none":
+		default:
+			add("ha.restore", "must be state, scene or none, got %q", config.HAConfig.Restore)
+		}
+		if config.HAConfig.RestoreAfter < 0 {
+			add("ha.restore_after", "must not be negative")
+		}
+	}
+
+	if strings.ContainsAny(config.Outputs.RollEvent, " /?#") {
+		add("outputs.roll_event", "must be a plain event type such as godice_roll, got %q", config.Outputs.RollEvent)
+	}
+
+	if mqtt := config.Outputs.MQTT; mqtt.Enabled {
+		if mqtt.Broker == "" {
+			add("outputs.mqtt.broker", "is required")
+		} else if u, err := url.Parse(mqtt.Broker); err != nil || u.Host == "" {
+			add("outputs.mqtt.broker", "must be a URL such as tcp://localhost:1883, got %q", mqtt.Broker)
+		} else {
+			switch u.Scheme {
+			case "tcp", "mqtt", "ssl", "tls", "mqtts":
+			default:
+				add("outputs.mqtt.broker", "scheme must be tcp, mqtt, ssl, tls or mqtts, got %q", u.Scheme)
+			}
+		}
+		if mqtt.ClientId == "" {
+			add("outputs.mqtt.client_id", "is required")
+		}
+		if mqtt.DiscoveryPrefix == "" {
+			add("outputs.mqtt.discovery_prefix", "is required")
+		}
+		if mqtt.TopicPrefix == "" || strings.ContainsAny(mqtt.TopicPrefix, "+#") {
+			add("outputs.mqtt.topic_prefix", "must be a topic without wildcards, got %q", mqtt.TopicPrefix)
+		}
+	}
+
+	deny := make(map[uint32]bool, len(config.Dice.Deny))
+	for _, id := range config.Dice.Deny {
+		deny[id] = true
+	}
+	for i, id := range config.Dice.Allow {
+		if deny[id] {
+			add(fmt.Sprintf("dice.allow[%d]", i), "die %d is also denied", id)
+		}
+	}
+
+	if config.Scan.MaxConnections < 0 {
+		add("scan.max_connections", "must not be negative")
+	}
+	if config.Scan.PassiveTimeout <= 0 {
+		add("scan.passive_timeout", "must be positive")
+	}
+	if config.Scan.ThrowWindow <= 0 {
+		add("scan.throw_window", "must be positive")
+	}
+	if config.Scan.ThrowTimeout < config.Scan.ThrowWindow {
+		add("scan.throw_timeout", "must be at least scan.throw_window")
+	}
+
+	if config.Reconnect.InitialBackoff <= 0 {
+		add("reconnect.initial_backoff", "must be positive")
+	}
+	if config.Reconnect.MaxBackoff < config.Reconnect.InitialBackoff {
+		add("reconnect.max_backoff", "must be at least reconnect.initial_backoff")
+	}
+	if config.Reconnect.Multiplier < 1 {
+		add("reconnect.multiplier", "must be at least 1")
+	}
+
+	for i, rule := range config.Rules {
+		if len(rule.Actions) == 0 {
+			add(fmt.Sprintf("rules[%d].actions", i), "needs at least one action")
+		}
+		for j, action := range rule.Actions {
+			if err := action.Validate(); err != nil {
+				add(fmt.Sprintf("rules[%d].actions[%d]", i, j), "%v", err)
+			}
+		}
+	}
+
+	switch config.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		add("logging.level", "must be debug, info, warn or error, got %q", config.Logging.Level)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
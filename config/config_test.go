@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	file := writeConfig(t, `ha:
+  url: http://localhost:8123
+  restor: scene
+scan:
+  throw_window: 2s
+rules:
+  - name: crit
+    when:
+      total_min: 20
+      totl_max: 20
+    actions:
+      - type: notify
+`)
+	_, err := Load(file)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("err = %v, want ValidationErrors", err)
+	}
+	var fields []string
+	for _, fieldErr := range errs {
+		fields = append(fields, fieldErr.Field)
+	}
+	if want := []string{"ha.restor", "rules[0].when.totl_max"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("unknown keys = %v, want %v (%v)", fields, want, err)
+	}
+}
+
+func TestLoadSyntaxError(t *testing.T) {
+	_, err := Load(writeConfig(t, "ha: [unclosed\n"))
+	var errs ValidationErrors
+	if err == nil || errors.As(err, &errs) {
+		t.Errorf("err = %v, want the YAML syntax error", err)
+	}
+}
+
+func TestLoadEmpty(t *testing.T) {
+	config, err := Load(writeConfig(t, ""))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if config.HAConfig.Restore != "state" || len(config.Rules) == 0 {
+		t.Errorf("empty file did not give the defaults: %+v", config)
+	}
+}
+
+func TestLoadTemplate(t *testing.T) {
+	if _, err := Load("../config.yaml.template"); err != nil {
+		t.Fatalf("the template has keys Load rejects: %v", err)
+	}
+}
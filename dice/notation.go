@@ -0,0 +1,283 @@
+// Package dice parses and rolls dice notation such as "2d20kh1+5" or "4d6dl1",
+// using virtual dice or physical Pixel dice from a registry.
+package dice
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrSyntax is returned for expressions that are not valid dice notation
+var ErrSyntax = errors.New("invalid dice notation")
+
+const (
+	maxDiceCount = 1000
+	maxSides     = 1000
+	maxExplosion = 100
+)
+
+type KeepMode uint8
+
+// Keep Modes
+const (
+	KeepAll KeepMode = iota
+	KeepHighest
+	KeepLowest
+)
+
+// DieSpec describes a single die to roll
+type DieSpec struct {
+	Sides int
+	Fudge bool
+}
+
+func (spec DieSpec) String() string {
+	if spec.Fudge {
+		return "dF"
+	}
+	return fmt.Sprintf("d%d", spec.Sides)
+}
+
+// Min returns the lowest value the die can roll
+func (spec DieSpec) Min() int {
+	if spec.Fudge {
+		return -1
+	}
+	return 1
+}
+
+// Max returns the highest value the die can roll
+func (spec DieSpec) Max() int {
+	if spec.Fudge {
+		return 1
+	}
+	return spec.Sides
+}
+
+// Term is one added or subtracted part of an expression, either a constant or a group of dice
+type Term struct {
+	Sign     int
+	Constant int
+	Count    int
+	Die      DieSpec
+	Keep     KeepMode
+	KeepN    int
+	Explode  bool
+	// RerollAtMost rerolls, once, any die showing this value or lower
+	RerollAtMost int
+}
+
+// IsConstant reports whether the term is a plain number
+func (t Term) IsConstant() bool {
+	return t.Count == 0
+}
+
+func (t Term) String() string {
+	if t.IsConstant() {
+		return strconv.Itoa(t.Constant)
+	}
+
+	var b strings.Builder
+	if t.Count != 1 {
+		b.WriteString(strconv.Itoa(t.Count))
+	}
+	b.WriteString(t.Die.String())
+	if t.Explode {
+		b.WriteString("!")
+	}
+	if t.RerollAtMost > 0 {
+		fmt.Fprintf(&b, "r%d", t.RerollAtMost)
+	}
+	switch t.Keep {
+	case KeepHighest:
+		fmt.Fprintf(&b, "kh%d", t.KeepN)
+	case KeepLowest:
+		fmt.Fprintf(&b, "kl%d", t.KeepN)
+	}
+	return b.String()
+}
+
+// Expr is a parsed dice expression
+type Expr struct {
+	Terms []Term
+}
+
+// Need is a number of dice of one kind an expression rolls before any rerolls or explosions
+type Need struct {
+	Die   DieSpec
+	Count int
+}
+
+// Needs returns the dice the expression rolls, grouped by kind in order of first use
+func (e *Expr) Needs() []Need {
+	var needs []Need
+	index := make(map[DieSpec]int)
+	for _, term := range e.Terms {
+		if term.IsConstant() {
+			continue
+		}
+		if i, ok := index[term.Die]; ok {
+			needs[i].Count += term.Count
+			continue
+		}
+		index[term.Die] = len(needs)
+		needs = append(needs, Need{Die: term.Die, Count: term.Count})
+	}
+	return needs
+}
+
+func (e *Expr) String() string {
+	var b strings.Builder
+	for i, term := range e.Terms {
+		if term.Sign < 0 {
+			b.WriteString("-")
+		} else if i > 0 {
+			b.WriteString("+")
+		}
+		b.WriteString(term.String())
+	}
+	return b.String()
+}
+
+var (
+	diceTermPattern = regexp.MustCompile(`^(\d*)d(\d+|%|f)((?:kh\d*|kl\d*|k\d*|dh\d*|dl\d*|!|r\d+|adv|dis)*)$`)
+	modifierPattern = regexp.MustCompile(`(kh|kl|k|dh|dl)(\d*)|!|r(\d+)|adv|dis`)
+	numberPattern   = regexp.MustCompile(`^\d+$`)
+)
+
+// Parse parses dice notation: NdS terms and constants joined by + and -, where
+// S is a number of sides, % for d100 or F for fudge dice. Dice terms accept the
+// modifiers khN/klN (keep highest/lowest), dhN/dlN (drop highest/lowest),
+// ! (explode on the highest face), rN (reroll N or lower once) and adv/dis.
+func Parse(notation string) (*Expr, error) {
+	s := strings.ToLower(strings.Join(strings.Fields(notation), ""))
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty expression", ErrSyntax)
+	}
+
+	expr := &Expr{}
+	sign := 1
+	start := 0
+	if s[0] == '+' || s[0] == '-' {
+		if s[0] == '-' {
+			sign = -1
+		}
+		start = 1
+	}
+	for i := start; i <= len(s); i++ {
+		if i < len(s) && s[i] != '+' && s[i] != '-' {
+			continue
+		}
+
+		term, err := parseTerm(s[start:i])
+		if err != nil {
+			return nil, err
+		}
+		term.Sign = sign
+		expr.Terms = append(expr.Terms, term)
+
+		if i < len(s) && s[i] == '-' {
+			sign = -1
+		} else {
+			sign = 1
+		}
+		start = i + 1
+	}
+	return expr, nil
+}
+
+func parseTerm(s string) (Term, error) {
+	if s == "" {
+		return Term{}, fmt.Errorf("%w: missing term", ErrSyntax)
+	}
+	if numberPattern.MatchString(s) {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return Term{}, fmt.Errorf("%w: %q", ErrSyntax, s)
+		}
+		return Term{Constant: n}, nil
+	}
+
+	m := diceTermPattern.FindStringSubmatch(s)
+	if m == nil {
+		return Term{}, fmt.Errorf("%w: %q", ErrSyntax, s)
+	}
+
+	term := Term{Count: 1}
+	if m[1] != "" {
+		term.Count, _ = strconv.Atoi(m[1])
+	}
+	switch m[2] {
+	case "%":
+		term.Die = DieSpec{Sides: 100}
+	case "f":
+		term.Die = DieSpec{Sides: 3, Fudge: true}
+	default:
+		term.Die.Sides, _ = strconv.Atoi(m[2])
+	}
+	if term.Count < 1 || term.Count > maxDiceCount {
+		return Term{}, fmt.Errorf("%w: %q rolls %d dice, want 1 to %d", ErrSyntax, s, term.Count, maxDiceCount)
+	}
+	if term.Die.Sides < 1 || term.Die.Sides > maxSides {
+		return Term{}, fmt.Errorf("%w: %q has %d sides, want 1 to %d", ErrSyntax, s, term.Die.Sides, maxSides)
+	}
+
+	for _, mod := range modifierPattern.FindAllStringSubmatch(m[3], -1) {
+		if err := applyModifier(&term, mod); err != nil {
+			return Term{}, fmt.Errorf("%w: %q %v", ErrSyntax, s, err)
+		}
+	}
+	return term, nil
+}
+
+func applyModifier(term *Term, mod []string) error {
+	switch {
+	case mod[0] == "!":
+		if term.Die.Min() == term.Die.Max() {
+			return errors.New("cannot explode a die with one face")
+		}
+		term.Explode = true
+	case mod[0] == "adv" || mod[0] == "dis":
+		if term.Count != 1 {
+			return errors.New("advantage applies to a single die")
+		}
+		term.Count = 2
+		term.KeepN = 1
+		term.Keep = KeepHighest
+		if mod[0] == "dis" {
+			term.Keep = KeepLowest
+		}
+	case mod[3] != "":
+		term.RerollAtMost, _ = strconv.Atoi(mod[3])
+		if term.RerollAtMost >= term.Die.Max() {
+			return errors.New("reroll would reroll every face")
+		}
+	default:
+		n := 1
+		if mod[2] != "" {
+			n, _ = strconv.Atoi(mod[2])
+		}
+		switch mod[1] {
+		case "k", "kh", "kl":
+			if n < 1 || n > term.Count {
+				return fmt.Errorf("cannot keep %d of %d dice, want 1 to %d", n, term.Count, term.Count)
+			}
+			term.Keep, term.KeepN = KeepHighest, n
+			if mod[1] == "kl" {
+				term.Keep = KeepLowest
+			}
+		case "dh", "dl":
+			if n >= term.Count {
+				return fmt.Errorf("cannot drop %d of %d dice, at least one must be kept", n, term.Count)
+			}
+			term.Keep, term.KeepN = KeepHighest, term.Count-n
+			if mod[1] == "dh" {
+				term.Keep = KeepLowest
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package dice_test
+
+import (
+	"errors"
+	"godice/dice"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		notation string
+		want     string
+	}{
+		{"d20", "d20"},
+		{"2d20kh1+5", "2d20kh1+5"},
+		{" 2 D6 + 1 ", "2d6+1"},
+		{"-d4+3", "-d4+3"},
+		{"4d6k3", "4d6kh3"},
+		{"4d6kh", "4d6kh1"},
+		{"4d6kl2", "4d6kl2"},
+		{"4d6dl1", "4d6kh3"},
+		{"4d6dh1", "4d6kl3"},
+		{"d20adv", "2d20kh1"},
+		{"d20dis", "2d20kl1"},
+		{"3d6!", "3d6!"},
+		{"2d6r1", "2d6r1"},
+		{"d%", "d100"},
+		{"4dF", "4dF"},
+		{"1d8!r2kh1-2", "d8!r2kh1-2"},
+		{"10", "10"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.notation, func(t *testing.T) {
+			expr, err := dice.Parse(test.notation)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := expr.String(); got != test.want {
+				t.Errorf("Parse(%q) = %s, want %s", test.notation, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTerms(t *testing.T) {
+	expr, err := dice.Parse("4d6dl1-dF+2")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := []dice.Term{
+		{Sign: 1, Count: 4, Die: dice.DieSpec{Sides: 6}, Keep: dice.KeepHighest, KeepN: 3},
+		{Sign: -1, Count: 1, Die: dice.DieSpec{Sides: 3, Fudge: true}},
+		{Sign: 1, Constant: 2},
+	}
+	if len(expr.Terms) != len(want) {
+		t.Fatalf("terms = %+v", expr.Terms)
+	}
+	for i, term := range expr.Terms {
+		if term != want[i] {
+			t.Errorf("term %d = %+v, want %+v", i, term, want[i])
+		}
+	}
+
+	needs := expr.Needs()
+	if len(needs) != 2 || needs[0] != (dice.Need{Die: dice.DieSpec{Sides: 6}, Count: 4}) || needs[1].Count != 1 {
+		t.Errorf("needs = %+v", needs)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, notation := range []string{
+		"",
+		"   ",
+		"d",
+		"2d",
+		"d6x",
+		"abc",
+		"2d6+",
+		"2d6++1",
+		"0d6",
+		"1001d6",
+		"d0",
+		"d1001",
+		"2d6k0",
+		"2d6kh0",
+		"2d6kl0",
+		"2d6k3",
+		"3d6dl3",
+		"3d6dh4",
+		"2d20adv",
+		"d1!",
+		"d6r6",
+		"dFr1",
+	} {
+		t.Run(notation, func(t *testing.T) {
+			if expr, err := dice.Parse(notation); !errors.Is(err, dice.ErrSyntax) {
+				t.Errorf("Parse(%q) = %v, %v, want ErrSyntax", notation, expr, err)
+			}
+		})
+	}
+}
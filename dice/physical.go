@@ -0,0 +1,264 @@
+package dice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"godice/pixel"
+	"sync"
+	"time"
+)
+
+// ErrNoPhysicalRoll is returned when no matching physical die was rolled in time and virtual dice are not allowed
+var ErrNoPhysicalRoll = errors.New("no physical roll")
+
+// Queue Defaults
+const (
+	DefaultMaxAge    = 30 * time.Second
+	DefaultMaxQueued = 256
+)
+
+// PhysicalOptions controls how a PhysicalSource waits for physical dice
+type PhysicalOptions struct {
+	// Timeout is how long Evaluate waits for all the physical dice of an expression,
+	// or Roll for a single die; 0 waits until the context is done
+	Timeout time.Duration
+	// AllowVirtual fills in dice that were not rolled in time from Virtual
+	AllowVirtual bool
+	Virtual      Source
+	// MaxAge is how long a roll stays queued for an expression to take it, so an old
+	// roll does not fill in an unrelated expression; 0 uses DefaultMaxAge
+	MaxAge time.Duration
+	// MaxQueued caps the queued rolls, dropping the oldest first; 0 uses DefaultMaxQueued
+	MaxQueued int
+}
+
+// PhysicalSource takes rolls from the Pixel dice of a manager, including dice it
+// discovers later. Every die that settles on a face while the source is open is
+// queued for up to MaxAge, and Roll hands out the oldest queued roll from a die of
+// the requested kind.
+type PhysicalSource struct {
+	opts PhysicalOptions
+	stop func()
+
+	mu     sync.Mutex
+	queued []pixel.Event
+	// arrived is closed and replaced whenever a roll is queued
+	arrived chan struct{}
+	watched map[*pixel.Die]func()
+	closed  bool
+}
+
+// NewPhysicalSource starts listening for rolls from every die the manager holds now or discovers later.
+// Close must be called to release the subscriptions.
+func NewPhysicalSource(manager *pixel.Manager, opts PhysicalOptions) *PhysicalSource {
+	if opts.AllowVirtual && opts.Virtual == nil {
+		opts.Virtual = NewRandomSource(time.Now().UnixNano())
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = DefaultMaxAge
+	}
+	if opts.MaxQueued <= 0 {
+		opts.MaxQueued = DefaultMaxQueued
+	}
+
+	src := &PhysicalSource{
+		opts:    opts,
+		arrived: make(chan struct{}),
+		watched: make(map[*pixel.Die]func()),
+	}
+	// subscribe before listing so a die discovered in between is not missed
+	events, stop := manager.Events(16)
+	src.stop = stop
+	for _, die := range manager.List() {
+		src.watch(die)
+	}
+	go func() {
+		for evt := range events {
+			switch evt.Type {
+			case pixel.ManagerEventDiscovered:
+				src.watch(evt.Die)
+			case pixel.ManagerEventLost:
+				src.unwatch(evt.Die)
+			}
+		}
+	}()
+	return src
+}
+
+// Close stops listening for rolls
+func (src *PhysicalSource) Close() {
+	src.stop()
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.closed = true
+	for die, stop := range src.watched {
+		stop()
+		delete(src.watched, die)
+	}
+}
+
+func (src *PhysicalSource) watch(die *pixel.Die) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if _, ok := src.watched[die]; ok || src.closed {
+		return
+	}
+
+	events, stop := die.Subscribe(64)
+	src.watched[die] = stop
+	go func() {
+		for evt := range events {
+			if evt.Type == pixel.EventRolled {
+				src.queue(evt)
+			}
+		}
+	}()
+}
+
+func (src *PhysicalSource) unwatch(die *pixel.Die) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if stop, ok := src.watched[die]; ok {
+		stop()
+		delete(src.watched, die)
+	}
+}
+
+// Evaluate rolls the expression, waiting at most Timeout for all of its physical dice together.
+// With AllowVirtual, the dice still missing once the time is up are rolled virtually.
+func (src *PhysicalSource) Evaluate(ctx context.Context, expr *Expr) (*Result, error) {
+	waitCtx, cancel := src.waitContext(ctx)
+	defer cancel()
+	return expr.Roll(ctx, sourceFunc(func(ctx context.Context, die DieSpec) (Roll, error) {
+		return src.roll(ctx, waitCtx, die)
+	}))
+}
+
+// Roll waits at most Timeout for a physical roll of the die
+func (src *PhysicalSource) Roll(ctx context.Context, die DieSpec) (Roll, error) {
+	waitCtx, cancel := src.waitContext(ctx)
+	defer cancel()
+	return src.roll(ctx, waitCtx, die)
+}
+
+func (src *PhysicalSource) waitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if src.opts.Timeout > 0 {
+		return context.WithTimeout(ctx, src.opts.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// roll waits for a physical roll until waitCtx is done, falling back to a virtual roll unless ctx is done too
+func (src *PhysicalSource) roll(ctx, waitCtx context.Context, die DieSpec) (Roll, error) {
+	roll, err := src.rollPhysical(waitCtx, die)
+	if err != nil && ctx.Err() == nil && src.opts.AllowVirtual {
+		return src.opts.Virtual.Roll(ctx, die)
+	}
+	if err != nil {
+		return Roll{}, fmt.Errorf("%w for %s: %v", ErrNoPhysicalRoll, die, err)
+	}
+	return roll, nil
+}
+
+// sourceFunc adapts a function to the Source interface
+type sourceFunc func(ctx context.Context, die DieSpec) (Roll, error)
+
+func (f sourceFunc) Roll(ctx context.Context, die DieSpec) (Roll, error) {
+	return f(ctx, die)
+}
+
+// rollPhysical waits for a die matching spec. A d100 is rolled as a d00 for the tens and a d10 for the ones.
+func (src *PhysicalSource) rollPhysical(ctx context.Context, die DieSpec) (Roll, error) {
+	if die.Sides != 100 || die.Fudge {
+		evt, err := src.wait(ctx, func(evt pixel.Event) bool { return matches(evt, die) })
+		if err != nil {
+			return Roll{}, err
+		}
+		return Roll{Value: evt.CurrentFaceValue, Physical: true, PixelId: evt.PixelId}, nil
+	}
+
+	tens, err := src.wait(ctx, func(evt pixel.Event) bool { return evt.DieType == pixel.DieTypeD00 })
+	if err != nil {
+		return Roll{}, err
+	}
+	ones, err := src.wait(ctx, func(evt pixel.Event) bool { return evt.DieType == pixel.DieTypeD10 })
+	if err != nil {
+		src.requeue(tens)
+		return Roll{}, err
+	}
+
+	return Roll{Value: pixel.PercentileValue(tens.CurrentFaceValue, ones.CurrentFaceValue), Physical: true, PixelId: tens.PixelId}, nil
+}
+
+// wait returns the oldest queued roll accepted by match, waiting for new rolls until ctx is done
+func (src *PhysicalSource) wait(ctx context.Context, match func(pixel.Event) bool) (pixel.Event, error) {
+	for {
+		src.mu.Lock()
+		src.expire()
+		for i, evt := range src.queued {
+			if match(evt) {
+				src.queued = append(src.queued[:i], src.queued[i+1:]...)
+				src.mu.Unlock()
+				return evt, nil
+			}
+		}
+		arrived := src.arrived
+		src.mu.Unlock()
+
+		select {
+		case <-arrived:
+		case <-ctx.Done():
+			return pixel.Event{}, ctx.Err()
+		}
+	}
+}
+
+// queue keeps a roll until a matching Roll takes it, it expires or MaxQueued newer rolls push it out
+func (src *PhysicalSource) queue(evt pixel.Event) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.expire()
+	src.queued = append(src.queued, evt)
+	if extra := len(src.queued) - src.opts.MaxQueued; extra > 0 {
+		src.queued = append(src.queued[:0], src.queued[extra:]...)
+	}
+	close(src.arrived)
+	src.arrived = make(chan struct{})
+}
+
+// requeue puts back a roll that was taken but not used, ahead of the rolls that came after it
+func (src *PhysicalSource) requeue(evt pixel.Event) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.queued = append([]pixel.Event{evt}, src.queued...)
+	close(src.arrived)
+	src.arrived = make(chan struct{})
+}
+
+// expire drops the queued rolls older than MaxAge; src.mu must be held
+func (src *PhysicalSource) expire() {
+	cutoff := time.Now().Add(-src.opts.MaxAge)
+	fresh := src.queued[:0]
+	for _, evt := range src.queued {
+		if evt.Time.After(cutoff) {
+			fresh = append(fresh, evt)
+		}
+	}
+	clear(src.queued[len(fresh):])
+	src.queued = fresh
+}
+
+// matches reports whether the die that rolled is of the kind described by spec
+func matches(evt pixel.Event, die DieSpec) bool {
+	switch {
+	case die.Fudge:
+		return evt.DieType == pixel.DieTypeD6Fudge
+	case die.Sides == 6:
+		return evt.DieType == pixel.DieTypeD6 || evt.DieType == pixel.DieTypeD6Pipped
+	case die.Sides == 10:
+		return evt.DieType == pixel.DieTypeD10
+	}
+	return evt.DieType != pixel.DieTypeD00 && evt.DieType.FaceCount() == die.Sides
+}
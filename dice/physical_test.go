@@ -0,0 +1,224 @@
+package dice_test
+
+import (
+	"context"
+	"errors"
+	"godice/dice"
+	"godice/pixel"
+	"godice/pixel/sim"
+	"testing"
+	"time"
+)
+
+// addSim connects a simulated die and adds it to the manager
+func addSim(t *testing.T, manager *pixel.Manager, id uint32, dieType pixel.DieType) *sim.Die {
+	t.Helper()
+	simDie := sim.New(sim.Options{PixelId: id, DieType: dieType})
+	die, err := simDie.Connect()
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	manager.Add(die)
+	t.Cleanup(func() { manager.Remove(id) })
+	return simDie
+}
+
+func newSource(t *testing.T, manager *pixel.Manager, opts dice.PhysicalOptions) *dice.PhysicalSource {
+	t.Helper()
+	src := dice.NewPhysicalSource(manager, opts)
+	t.Cleanup(src.Close)
+	return src
+}
+
+func mustParse(t *testing.T, notation string) *dice.Expr {
+	t.Helper()
+	expr, err := dice.Parse(notation)
+	if err != nil {
+		t.Fatalf("parse %s: %v", notation, err)
+	}
+	return expr
+}
+
+func TestPhysicalSourceDiceAddedLater(t *testing.T) {
+	manager := pixel.NewManager(nil, pixel.ManagerOptions{})
+	src := newSource(t, manager, dice.PhysicalOptions{Timeout: 2 * time.Second})
+
+	d20 := addSim(t, manager, 1, pixel.DieTypeD20)
+	done := make(chan struct{})
+	var result *dice.Result
+	var err error
+	go func() {
+		defer close(done)
+		result, err = src.Evaluate(context.Background(), mustParse(t, "d20+2"))
+	}()
+	// the source starts watching the new die in the background, so keep rolling until it sees one
+	for rolling := true; rolling; {
+		d20.RollValue(17)
+		select {
+		case <-done:
+			rolling = false
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	if err != nil {
+		t.Fatalf("evaluate: %v", err)
+	}
+	rolled := result.Dice()
+	if result.Total != 19 || len(rolled) != 1 || !rolled[0].Physical || rolled[0].PixelId != 1 {
+		t.Errorf("result = %s %+v", result, rolled)
+	}
+}
+
+func TestPhysicalSourceKeepsEveryRoll(t *testing.T) {
+	manager := pixel.NewManager(nil, pixel.ManagerOptions{})
+	d6 := addSim(t, manager, 1, pixel.DieTypeD6)
+	src := newSource(t, manager, dice.PhysicalOptions{Timeout: 2 * time.Second})
+
+	// more rolls than any channel buffer on the way, at a pace the forwarder keeps up with
+	want := 0
+	for i := 0; i < 100; i++ {
+		value := i%6 + 1
+		d6.RollValue(value)
+		want += value
+		time.Sleep(time.Millisecond)
+	}
+
+	result, err := src.Evaluate(context.Background(), mustParse(t, "100d6"))
+	if err != nil {
+		t.Fatalf("evaluate: %v", err)
+	}
+	if result.Total != want {
+		t.Errorf("total = %d, want %d from the queued rolls in order", result.Total, want)
+	}
+	for i, die := range result.Dice() {
+		if !die.Physical || die.Value != i%6+1 {
+			t.Fatalf("die %d = %+v, want physical %d", i, die, i%6+1)
+		}
+	}
+}
+
+func TestPhysicalSourceOneDeadlinePerEvaluation(t *testing.T) {
+	manager := pixel.NewManager(nil, pixel.ManagerOptions{})
+	d6 := addSim(t, manager, 1, pixel.DieTypeD6)
+	timeout := 100 * time.Millisecond
+	src := newSource(t, manager, dice.PhysicalOptions{Timeout: timeout, AllowVirtual: true, Virtual: dice.NewRandomSource(1)})
+
+	d6.RollValue(6)
+	start := time.Now()
+	result, err := src.Evaluate(context.Background(), mustParse(t, "10d6"))
+	if err != nil {
+		t.Fatalf("evaluate: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*timeout {
+		t.Errorf("evaluating took %s, want about one timeout of %s", elapsed, timeout)
+	}
+
+	physical := 0
+	for _, die := range result.Dice() {
+		if die.Physical {
+			physical++
+		}
+	}
+	if len(result.Dice()) != 10 || physical != 1 {
+		t.Errorf("rolled %d dice with %d physical, want 10 with 1 physical", len(result.Dice()), physical)
+	}
+}
+
+func TestPhysicalSourceTimeout(t *testing.T) {
+	manager := pixel.NewManager(nil, pixel.ManagerOptions{})
+	addSim(t, manager, 1, pixel.DieTypeD6)
+	src := newSource(t, manager, dice.PhysicalOptions{Timeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	_, err := src.Evaluate(context.Background(), mustParse(t, "3d6"))
+	if !errors.Is(err, dice.ErrNoPhysicalRoll) {
+		t.Fatalf("err = %v, want ErrNoPhysicalRoll", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %s", elapsed)
+	}
+}
+
+func TestPhysicalSourcePercentile(t *testing.T) {
+	manager := pixel.NewManager(nil, pixel.ManagerOptions{})
+	tens := addSim(t, manager, 1, pixel.DieTypeD00)
+	ones := addSim(t, manager, 2, pixel.DieTypeD10)
+	src := newSource(t, manager, dice.PhysicalOptions{Timeout: 2 * time.Second})
+
+	ones.RollValue(7)
+	tens.RollValue(40)
+	roll, err := src.Roll(context.Background(), dice.DieSpec{Sides: 100})
+	if err != nil {
+		t.Fatalf("roll: %v", err)
+	}
+	if roll.Value != 47 || !roll.Physical {
+		t.Errorf("roll = %+v, want physical 47", roll)
+	}
+}
+
+func TestPhysicalSourceD10Zero(t *testing.T) {
+	manager := pixel.NewManager(nil, pixel.ManagerOptions{})
+	tens := addSim(t, manager, 1, pixel.DieTypeD00)
+	ones := addSim(t, manager, 2, pixel.DieTypeD10)
+	src := newSource(t, manager, dice.PhysicalOptions{Timeout: 2 * time.Second})
+
+	// the 0 face counts as 10 on its own, the same value rules and throws see
+	ones.RollFace(0)
+	roll, err := src.Roll(context.Background(), dice.DieSpec{Sides: 10})
+	if err != nil {
+		t.Fatalf("roll: %v", err)
+	}
+	if want := pixel.DieTypeD10.FaceValue(0); roll.Value != 10 || want != 10 {
+		t.Errorf("d10 on 0 = %d, FaceValue = %d, want 10", roll.Value, want)
+	}
+
+	// and as 0 in a d100, where 00 and 0 make 100
+	tens.RollFace(0)
+	ones.RollFace(0)
+	if roll, err = src.Roll(context.Background(), dice.DieSpec{Sides: 100}); err != nil || roll.Value != 100 {
+		t.Errorf("d100 on 00 and 0 = %+v, %v, want 100", roll, err)
+	}
+	tens.RollValue(40)
+	ones.RollFace(0)
+	if roll, err = src.Roll(context.Background(), dice.DieSpec{Sides: 100}); err != nil || roll.Value != 40 {
+		t.Errorf("d100 on 40 and 0 = %+v, %v, want 40", roll, err)
+	}
+}
+
+func TestPhysicalSourceDropsOldRolls(t *testing.T) {
+	manager := pixel.NewManager(nil, pixel.ManagerOptions{})
+	d6 := addSim(t, manager, 1, pixel.DieTypeD6)
+	src := newSource(t, manager, dice.PhysicalOptions{Timeout: 50 * time.Millisecond, MaxAge: 50 * time.Millisecond})
+
+	d6.RollValue(3)
+	time.Sleep(150 * time.Millisecond)
+	if roll, err := src.Roll(context.Background(), dice.DieSpec{Sides: 6}); !errors.Is(err, dice.ErrNoPhysicalRoll) {
+		t.Errorf("took a roll older than MaxAge: %+v, %v", roll, err)
+	}
+}
+
+func TestPhysicalSourceCapsQueue(t *testing.T) {
+	manager := pixel.NewManager(nil, pixel.ManagerOptions{})
+	d6 := addSim(t, manager, 1, pixel.DieTypeD6)
+	src := newSource(t, manager, dice.PhysicalOptions{Timeout: 100 * time.Millisecond, MaxQueued: 5})
+
+	for i := 0; i < 10; i++ {
+		d6.RollValue(i%6 + 1)
+		time.Sleep(time.Millisecond)
+	}
+
+	// only the newest five rolls are left: 6, 1, 2, 3 and 4
+	result, err := src.Evaluate(context.Background(), mustParse(t, "5d6"))
+	if err != nil {
+		t.Fatalf("evaluate: %v", err)
+	}
+	for i, die := range result.Dice() {
+		if want := (i+5)%6 + 1; die.Value != want {
+			t.Errorf("die %d = %d, want %d", i, die.Value, want)
+		}
+	}
+	if roll, err := src.Roll(context.Background(), dice.DieSpec{Sides: 6}); !errors.Is(err, dice.ErrNoPhysicalRoll) {
+		t.Errorf("queue kept more than MaxQueued rolls: %+v, %v", roll, err)
+	}
+}
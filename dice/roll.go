@@ -0,0 +1,192 @@
+package dice
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Roll is the outcome of rolling one die
+type Roll struct {
+	Value    int
+	Physical bool
+	// PixelId identifies the physical die that produced the roll
+	PixelId uint32
+}
+
+// Source produces die rolls for an expression
+type Source interface {
+	Roll(ctx context.Context, die DieSpec) (Roll, error)
+}
+
+// RandomSource rolls virtual dice from a seeded random generator
+type RandomSource struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewRandomSource creates a virtual dice source seeded with seed
+func NewRandomSource(seed int64) *RandomSource {
+	return &RandomSource{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (src *RandomSource) Roll(ctx context.Context, die DieSpec) (Roll, error) {
+	if err := ctx.Err(); err != nil {
+		return Roll{}, err
+	}
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	return Roll{Value: die.Min() + src.rng.Intn(die.Max()-die.Min()+1)}, nil
+}
+
+// DieResult is one die rolled while evaluating a term
+type DieResult struct {
+	Roll
+	Die DieSpec
+	// Kept is false for dice dropped by a keep modifier or replaced by a reroll
+	Kept bool
+	// Rerolled marks a die that was replaced by the following roll
+	Rerolled bool
+	// Exploded marks a bonus die rolled because the previous one showed its highest face
+	Exploded bool
+}
+
+// TermResult is the evaluated value of one term
+type TermResult struct {
+	Term     string
+	Sign     int
+	Dice     []DieResult
+	Subtotal int
+}
+
+// Result is the structured outcome of rolling an expression
+type Result struct {
+	Expr  string
+	Total int
+	Terms []TermResult
+}
+
+// Dice returns every die rolled for the result, in order
+func (r *Result) Dice() []DieResult {
+	var dice []DieResult
+	for _, term := range r.Terms {
+		dice = append(dice, term.Dice...)
+	}
+	return dice
+}
+
+func (r *Result) String() string {
+	var b strings.Builder
+	b.WriteString(r.Expr)
+	b.WriteString(" = ")
+	for i, term := range r.Terms {
+		if term.Sign < 0 {
+			b.WriteString(" - ")
+		} else if i > 0 {
+			b.WriteString(" + ")
+		}
+		if len(term.Dice) == 0 {
+			b.WriteString(term.Term)
+			continue
+		}
+		b.WriteString("[")
+		for j, die := range term.Dice {
+			if j > 0 {
+				b.WriteString(" ")
+			}
+			if !die.Kept {
+				b.WriteString("~")
+			}
+			b.WriteString(strconv.Itoa(die.Value))
+		}
+		b.WriteString("]")
+	}
+	b.WriteString(" = ")
+	b.WriteString(strconv.Itoa(r.Total))
+	return b.String()
+}
+
+// Roll evaluates the expression, taking every die from src
+func (e *Expr) Roll(ctx context.Context, src Source) (*Result, error) {
+	result := &Result{Expr: e.String()}
+	for _, term := range e.Terms {
+		termResult, err := rollTerm(ctx, term, src)
+		if err != nil {
+			return nil, err
+		}
+		result.Terms = append(result.Terms, termResult)
+		result.Total += term.Sign * termResult.Subtotal
+	}
+	return result, nil
+}
+
+func rollTerm(ctx context.Context, term Term, src Source) (TermResult, error) {
+	result := TermResult{Term: term.String(), Sign: term.Sign}
+	if term.IsConstant() {
+		result.Subtotal = term.Constant
+		return result, nil
+	}
+
+	rollOne := func(exploded bool) (DieResult, error) {
+		roll, err := src.Roll(ctx, term.Die)
+		return DieResult{Roll: roll, Die: term.Die, Kept: true, Exploded: exploded}, err
+	}
+
+	for i := 0; i < term.Count; i++ {
+		die, err := rollOne(false)
+		if err != nil {
+			return result, err
+		}
+		if term.RerollAtMost > 0 && die.Value <= term.RerollAtMost {
+			die.Kept = false
+			die.Rerolled = true
+			result.Dice = append(result.Dice, die)
+			if die, err = rollOne(false); err != nil {
+				return result, err
+			}
+		}
+		result.Dice = append(result.Dice, die)
+
+		for explosions := 0; term.Explode && die.Value == term.Die.Max() && explosions < maxExplosion; explosions++ {
+			if die, err = rollOne(true); err != nil {
+				return result, err
+			}
+			result.Dice = append(result.Dice, die)
+		}
+	}
+
+	applyKeep(term, result.Dice)
+	for _, die := range result.Dice {
+		if die.Kept {
+			result.Subtotal += die.Value
+		}
+	}
+	return result, nil
+}
+
+// applyKeep drops every kept die outside the term's highest or lowest KeepN
+func applyKeep(term Term, dice []DieResult) {
+	if term.Keep == KeepAll {
+		return
+	}
+
+	var kept []int
+	for i, die := range dice {
+		if die.Kept {
+			kept = append(kept, i)
+		}
+	}
+	sort.SliceStable(kept, func(a, b int) bool {
+		if term.Keep == KeepHighest {
+			return dice[kept[a]].Value > dice[kept[b]].Value
+		}
+		return dice[kept[a]].Value < dice[kept[b]].Value
+	})
+	for _, i := range kept[min(term.KeepN, len(kept)):] {
+		dice[i].Kept = false
+	}
+}
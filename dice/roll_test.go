@@ -0,0 +1,134 @@
+package dice_test
+
+import (
+	"context"
+	"errors"
+	"godice/dice"
+	"testing"
+)
+
+// scripted hands out its values in order, one per rolled die
+type scripted struct {
+	values []int
+}
+
+var errScriptDone = errors.New("script has no more rolls")
+
+func (s *scripted) Roll(ctx context.Context, die dice.DieSpec) (dice.Roll, error) {
+	if len(s.values) == 0 {
+		return dice.Roll{}, errScriptDone
+	}
+	value := s.values[0]
+	s.values = s.values[1:]
+	return dice.Roll{Value: value}, nil
+}
+
+func roll(t *testing.T, notation string, src dice.Source) *dice.Result {
+	t.Helper()
+	result, err := mustParse(t, notation).Roll(context.Background(), src)
+	if err != nil {
+		t.Fatalf("roll %s: %v", notation, err)
+	}
+	return result
+}
+
+func TestRoll(t *testing.T) {
+	tests := []struct {
+		notation string
+		values   []int
+		total    int
+		// kept lists, for each rolled die, whether it counts towards the total
+		kept []bool
+	}{
+		{"2d6+3", []int{4, 5}, 12, []bool{true, true}},
+		{"d6-2", []int{1}, -1, []bool{true}},
+		{"-d4+3", []int{4}, -1, []bool{true}},
+		{"4d6dl1", []int{3, 1, 6, 4}, 13, []bool{true, false, true, true}},
+		{"4d6dh1", []int{3, 1, 6, 4}, 8, []bool{true, true, false, true}},
+		{"3d6k2", []int{2, 5, 5}, 10, []bool{false, true, true}},
+		{"2d20kh1", []int{7, 15}, 15, []bool{false, true}},
+		{"2d20kl1", []int{7, 15}, 7, []bool{true, false}},
+		{"d20adv", []int{7, 15}, 15, []bool{false, true}},
+		{"d20dis", []int{7, 15}, 7, []bool{true, false}},
+		{"d6!", []int{6, 6, 2}, 14, []bool{true, true, true}},
+		{"2d6r1", []int{1, 4, 5}, 9, []bool{false, true, true}},
+		{"2d6r2", []int{1, 2, 3}, 5, []bool{false, true, true}},
+		{"d%", []int{57}, 57, []bool{true}},
+		{"4dF", []int{-1, 0, 1, 1}, 1, []bool{true, true, true, true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.notation, func(t *testing.T) {
+			result := roll(t, test.notation, &scripted{values: test.values})
+			if result.Total != test.total {
+				t.Errorf("total = %d, want %d (%s)", result.Total, test.total, result)
+			}
+			rolled := result.Dice()
+			if len(rolled) != len(test.kept) {
+				t.Fatalf("rolled %d dice, want %d (%s)", len(rolled), len(test.kept), result)
+			}
+			for i, die := range rolled {
+				if die.Kept != test.kept[i] {
+					t.Errorf("die %d kept = %v, want %v (%s)", i, die.Kept, test.kept[i], result)
+				}
+			}
+		})
+	}
+}
+
+func TestRollBreakdown(t *testing.T) {
+	result := roll(t, "4d6dl1+d6!+2", &scripted{values: []int{3, 1, 6, 4, 6, 2}})
+	if want := "4d6kh3+d6!+2 = [3 ~1 6 4] + [6 2] + 2 = 23"; result.String() != want {
+		t.Errorf("result = %s, want %s", result, want)
+	}
+	if rolled := result.Dice(); !rolled[5].Exploded || rolled[4].Exploded {
+		t.Errorf("exploded flags = %+v", rolled)
+	}
+
+	result = roll(t, "d6r1", &scripted{values: []int{1, 3}})
+	if rolled := result.Dice(); !rolled[0].Rerolled || rolled[1].Rerolled {
+		t.Errorf("rerolled flags = %+v", rolled)
+	}
+}
+
+// maxed always rolls the highest face
+type maxed struct{}
+
+func (maxed) Roll(ctx context.Context, die dice.DieSpec) (dice.Roll, error) {
+	return dice.Roll{Value: die.Max()}, nil
+}
+
+func TestRollExplosionLimit(t *testing.T) {
+	result := roll(t, "d2!", maxed{})
+	if rolled := len(result.Dice()); rolled != 101 {
+		t.Errorf("rolled %d dice, want the first and 100 explosions", rolled)
+	}
+}
+
+func TestRollSourceError(t *testing.T) {
+	_, err := mustParse(t, "3d6").Roll(context.Background(), &scripted{values: []int{1, 2}})
+	if !errors.Is(err, errScriptDone) {
+		t.Errorf("err = %v, want the source's error", err)
+	}
+}
+
+func TestRandomSource(t *testing.T) {
+	expr := mustParse(t, "20d6+20dF+20d%+20d20!")
+	first := roll(t, expr.String(), dice.NewRandomSource(42))
+	second := roll(t, expr.String(), dice.NewRandomSource(42))
+	if first.String() != second.String() {
+		t.Errorf("the same seed rolled\n%s\n%s", first, second)
+	}
+
+	for _, die := range first.Dice() {
+		if die.Value < die.Die.Min() || die.Value > die.Die.Max() || die.Physical {
+			t.Errorf("%s rolled %+v", die.Die, die.Roll)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := expr.Roll(ctx, dice.NewRandomSource(42)); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled roll: %v", err)
+	}
+}
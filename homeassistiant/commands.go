@@ -1,58 +1,140 @@
 package homeassistiant
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"image/color"
+	"math"
 	"time"
 )
 
-func (haClient *HAClient) LightColor(entityId string, color color.RGBA) {
-	data := map[string]interface{}{
-		"rgb_color": []int{int(color.R), int(color.G), int(color.B)},
-		"entity_id": entityId,
-	}
-	// TODO fix the returns
-	_, err := haClient.CallService("light", "turn_on", data, false)
-	if err != nil {
-		fmt.Println(err)
-	}
+// Flash values for LightTurnOn
+const (
+	FlashShort = "short"
+	FlashLong  = "long"
+)
+
+// Target selects the entities, areas and devices a service call acts on
+type Target struct {
+	EntityIds []string `json:"entity_id,omitempty"`
+	AreaIds   []string `json:"area_id,omitempty"`
+	DeviceIds []string `json:"device_id,omitempty"`
 }
 
-func (haClient *HAClient) LightOff(entityId string) {
-	data := map[string]interface{}{
-		"entity_id": entityId,
+// Entities targets the given entity ids
+func Entities(entityIds ...string) Target {
+	return Target{EntityIds: entityIds}
+}
+
+// LightTurnOn holds the light.turn_on options. Unset fields are left out of the call,
+// so only one color option should be set.
+type LightTurnOn struct {
+	Target
+	// Brightness is 0-255, BrightnessPct is 0-100
+	Brightness    *int `json:"brightness,omitempty"`
+	BrightnessPct *int `json:"brightness_pct,omitempty"`
+	// Transition is how long the light takes to reach the new state
+	Transition time.Duration `json:"-"`
+	// Kelvin is the color temperature
+	Kelvin int `json:"color_temp_kelvin,omitempty"`
+	// RGB is the color, its alpha is ignored
+	RGB   *color.RGBA `json:"-"`
+	HS    *[2]float64 `json:"hs_color,omitempty"`
+	XY    *[2]float64 `json:"xy_color,omitempty"`
+	RGBW  *[4]uint8   `json:"rgbw_color,omitempty"`
+	RGBWW *[5]uint8   `json:"rgbww_color,omitempty"`
+	// ColorName is a CSS color name
+	ColorName string `json:"color_name,omitempty"`
+	Effect    string `json:"effect,omitempty"`
+	// Flash is FlashShort or FlashLong
+	Flash string `json:"flash,omitempty"`
+}
+
+// MarshalJSON encodes the options as light.turn_on service data
+func (opts LightTurnOn) MarshalJSON() ([]byte, error) {
+	type plain LightTurnOn
+	data := struct {
+		plain
+		RGB        []int    `json:"rgb_color,omitempty"`
+		Transition *float64 `json:"transition,omitempty"`
+	}{plain: plain(opts)}
+
+	if opts.RGB != nil {
+		data.RGB = []int{int(opts.RGB.R), int(opts.RGB.G), int(opts.RGB.B)}
 	}
-	// TODO fix the returns
-	_, err := haClient.CallService("light", "turn_off", data, false)
-	if err != nil {
-		fmt.Println(err)
+	if opts.Transition > 0 {
+		seconds := opts.Transition.Seconds()
+		data.Transition = &seconds
 	}
+	return json.Marshal(data)
 }
 
-func (haClient *HAClient) LightTemperature(entityId string, temperature int) {
-	data := map[string]interface{}{
-		"entity_id":  entityId,
-		"color_temp": temperature,
-	}
-	// TODO fix the returns
-	_, err := haClient.CallService("light", "turn_on", data, false)
-	if err != nil {
-		fmt.Println(err)
+// LightTurnOff holds the light.turn_off options
+type LightTurnOff struct {
+	Target
+	Transition time.Duration `json:"-"`
+	Flash      string        `json:"flash,omitempty"`
+}
+
+// MarshalJSON encodes the options as light.turn_off service data
+func (opts LightTurnOff) MarshalJSON() ([]byte, error) {
+	type plain LightTurnOff
+	data := struct {
+		plain
+		Transition *float64 `json:"transition,omitempty"`
+	}{plain: plain(opts)}
+
+	if opts.Transition > 0 {
+		seconds := opts.Transition.Seconds()
+		data.Transition = &seconds
 	}
+	return json.Marshal(data)
 }
 
-func (haClient *HAClient) LightCycleColors(entityId string, colors []color.RGBA, interval time.Duration, blink bool) {
-	for _, c := range colors[:len(colors)-1] {
-		haClient.LightColor(entityId, c)
-		if blink {
-			time.Sleep(interval)
-			haClient.LightOff(entityId)
-		}
-		time.Sleep(interval)
+// LightTurnOn calls light.turn_on with the given options
+func (haClient *HAClient) LightTurnOn(ctx context.Context, opts LightTurnOn) (*ServiceResult, error) {
+	return haClient.CallService(ctx, "light", "turn_on", opts, false)
+}
+
+// LightTurnOff calls light.turn_off with the given options
+func (haClient *HAClient) LightTurnOff(ctx context.Context, opts LightTurnOff) (*ServiceResult, error) {
+	return haClient.CallService(ctx, "light", "turn_off", opts, false)
+}
+
+// LightColor sets the color of a light
+func (haClient *HAClient) LightColor(ctx context.Context, entityId string, color color.RGBA) (*ServiceResult, error) {
+	return haClient.LightTurnOn(ctx, LightTurnOn{Target: Entities(entityId), RGB: &color})
+}
+
+// LightOff turns a light off
+func (haClient *HAClient) LightOff(ctx context.Context, entityId string) (*ServiceResult, error) {
+	return haClient.LightTurnOff(ctx, LightTurnOff{Target: Entities(entityId)})
+}
+
+// LightTemperature sets the color temperature of a light in mireds, as it always has.
+// Home Assistant no longer takes mireds, so they are converted to Kelvin;
+// new code should use LightTemperatureKelvin.
+func (haClient *HAClient) LightTemperature(ctx context.Context, entityId string, mireds int) (*ServiceResult, error) {
+	if mireds <= 0 {
+		return nil, fmt.Errorf("invalid color temperature of %d mireds", mireds)
 	}
-	haClient.LightColor(entityId, colors[len(colors)-1])
+	return haClient.LightTemperatureKelvin(ctx, entityId, int(math.Round(1e6/float64(mireds))))
+}
+
+// LightTemperatureKelvin sets the color temperature of a light in Kelvin
+func (haClient *HAClient) LightTemperatureKelvin(ctx context.Context, entityId string, kelvin int) (*ServiceResult, error) {
+	return haClient.LightTurnOn(ctx, LightTurnOn{Target: Entities(entityId), Kelvin: kelvin})
+}
+
+// LightCycleColors shows each color in turn on the target lights, optionally turning them off between colors.
+// It blocks until the cycle is done or ctx is cancelled and stops at the first failed call;
+// use an EffectRunner to cycle in the background.
+func (haClient *HAClient) LightCycleColors(ctx context.Context, target Target, colors []color.RGBA, interval time.Duration, blink bool) error {
+	return Cycle(colors, interval, blink)(ctx, haClient.Lights(target))
 }
 
-func (haClient *HAClient) LightCycleColorsEz(entityId string, colors []color.RGBA) {
-	haClient.LightCycleColors(entityId, colors, 500*time.Millisecond, true)
+// LightCycleColorsEz cycles a light through colors every half second, blinking between them
+func (haClient *HAClient) LightCycleColorsEz(ctx context.Context, entityId string, colors []color.RGBA) error {
+	return haClient.LightCycleColors(ctx, Entities(entityId), colors, 500*time.Millisecond, true)
 }
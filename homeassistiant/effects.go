@@ -0,0 +1,433 @@
+package homeassistiant
+
+import (
+	"context"
+	"image/color"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lights is the target an Effect drives. Every call acts on all of its lights at once, keeping them in sync.
+type Lights struct {
+	client *HAClient
+	target Target
+}
+
+// Lights returns the given target as Lights for running effects directly
+func (haClient *HAClient) Lights(target Target) *Lights {
+	return &Lights{client: haClient, target: target}
+}
+
+// Target returns the lights' target
+func (l *Lights) Target() Target {
+	return l.target
+}
+
+// Only returns the given entities as Lights on the same client
+func (l *Lights) Only(entityIds ...string) *Lights {
+	return &Lights{client: l.client, target: Entities(entityIds...)}
+}
+
+// TurnOn calls light.turn_on on the lights, ignoring the target in opts
+func (l *Lights) TurnOn(ctx context.Context, opts LightTurnOn) error {
+	opts.Target = l.target
+	_, err := l.client.LightTurnOn(ctx, opts)
+	return err
+}
+
+// TurnOff calls light.turn_off on the lights, fading over transition when it is not zero
+func (l *Lights) TurnOff(ctx context.Context, transition time.Duration) error {
+	_, err := l.client.LightTurnOff(ctx, LightTurnOff{Target: l.target, Transition: transition})
+	return err
+}
+
+// Effect is a light sequence. It must return promptly with ctx's error once ctx is done.
+type Effect func(ctx context.Context, lights *Lights) error
+
+// Sleep waits for d or until ctx is done
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Sequence plays effects one after another
+func Sequence(effects ...Effect) Effect {
+	return func(ctx context.Context, lights *Lights) error {
+		for _, effect := range effects {
+			if err := effect(ctx, lights); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Set applies opts immediately
+func Set(opts LightTurnOn) Effect {
+	return func(ctx context.Context, lights *Lights) error {
+		return lights.TurnOn(ctx, opts)
+	}
+}
+
+// Off turns the lights off
+func Off() Effect {
+	return func(ctx context.Context, lights *Lights) error {
+		return lights.TurnOff(ctx, 0)
+	}
+}
+
+// Hold keeps the lights as they are for d
+func Hold(d time.Duration) Effect {
+	return func(ctx context.Context, lights *Lights) error {
+		return Sleep(ctx, d)
+	}
+}
+
+// Fade moves the lights to opts over d using the light's own transition
+func Fade(opts LightTurnOn, d time.Duration) Effect {
+	return func(ctx context.Context, lights *Lights) error {
+		opts.Transition = d
+		if err := lights.TurnOn(ctx, opts); err != nil {
+			return err
+		}
+		return Sleep(ctx, d)
+	}
+}
+
+// Cycle shows each color for interval, turning the lights off for interval between colors when blink is set.
+// The lights are left on the last color.
+func Cycle(colors []color.RGBA, interval time.Duration, blink bool) Effect {
+	return func(ctx context.Context, lights *Lights) error {
+		for i, c := range colors {
+			if err := lights.TurnOn(ctx, LightTurnOn{RGB: &c}); err != nil {
+				return err
+			}
+			if i == len(colors)-1 {
+				break
+			}
+			if blink {
+				if err := Sleep(ctx, interval); err != nil {
+					return err
+				}
+				if err := lights.TurnOff(ctx, 0); err != nil {
+					return err
+				}
+			}
+			if err := Sleep(ctx, interval); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Blink flashes the lights in color count times, on and off for interval each, leaving them off
+func Blink(c color.RGBA, count int, interval time.Duration) Effect {
+	return func(ctx context.Context, lights *Lights) error {
+		for i := 0; i < count; i++ {
+			if err := lights.TurnOn(ctx, LightTurnOn{RGB: &c}); err != nil {
+				return err
+			}
+			if err := Sleep(ctx, interval); err != nil {
+				return err
+			}
+			if err := lights.TurnOff(ctx, 0); err != nil {
+				return err
+			}
+			if err := Sleep(ctx, interval); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Pulse fades the lights between full and low brightness in color count times, each pulse lasting period.
+// The lights are left at full brightness.
+func Pulse(c color.RGBA, count int, period time.Duration) Effect {
+	half := period / 2
+	full, low := 255, 25
+	return func(ctx context.Context, lights *Lights) error {
+		if err := lights.TurnOn(ctx, LightTurnOn{RGB: &c, Brightness: &full}); err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			if err := Fade(LightTurnOn{RGB: &c, Brightness: &low}, half)(ctx, lights); err != nil {
+				return err
+			}
+			if err := Fade(LightTurnOn{RGB: &c, Brightness: &full}, half)(ctx, lights); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// EffectRunner plays effects in the background, one at a time per light.
+// Starting an effect on a light cancels the effect already running on it.
+type EffectRunner struct {
+	client *HAClient
+	opts   EffectOptions
+
+	mu      sync.Mutex
+	running map[string]*Playback
+}
+
+// Playback is an effect started by an EffectRunner
+type Playback struct {
+	entities []string
+	restore  bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+	err      error
+	// saved is the state of the lights before the effect, only touched by the effect's goroutine
+	saved map[string]lightSnapshot
+	// handedOver are the lights taken over by a newer effect, guarded by the runner's mu
+	handedOver map[string]bool
+}
+
+// lightSnapshot is what restoring a light needs to know about its state
+type lightSnapshot struct {
+	on          bool
+	unavailable bool
+	opts        LightTurnOn
+	// scene is the scene.create snapshot to turn on instead of opts
+	scene string
+}
+
+// Restore modes for EffectOptions
+const (
+	// RestoreState reads the light's state before an effect and sets it again afterwards
+	RestoreState = "state"
+	// RestoreScene snapshots the light with scene.create and turns that scene on afterwards
+	RestoreScene = "scene"
+)
+
+// EffectOptions configure an EffectRunner
+type EffectOptions struct {
+	// Restore is RestoreState, the default, or RestoreScene
+	Restore string
+}
+
+// NewEffectRunner creates an effect runner calling the given client
+func NewEffectRunner(client *HAClient, opts EffectOptions) *EffectRunner {
+	if opts.Restore == "" {
+		opts.Restore = RestoreState
+	}
+	return &EffectRunner{client: client, opts: opts, running: make(map[string]*Playback)}
+}
+
+// Play starts effect on the entities and returns without waiting for it. Effects already running
+// on any of the entities are cancelled. With restore set, the lights are put back into the state
+// they were in before the first of the replaced effects once this one ends or is cancelled.
+func (r *EffectRunner) Play(ctx context.Context, entities []string, effect Effect, restore bool) *Playback {
+	ctx, cancel := context.WithCancel(ctx)
+	pb := &Playback{
+		entities:   entities,
+		restore:    restore,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		saved:      make(map[string]lightSnapshot),
+		handedOver: make(map[string]bool),
+	}
+
+	r.mu.Lock()
+	var previous []*Playback
+	replaced := make(map[*Playback]bool)
+	for _, entity := range entities {
+		if p, ok := r.running[entity]; ok {
+			p.handedOver[entity] = true
+			if !replaced[p] {
+				replaced[p] = true
+				previous = append(previous, p)
+				p.cancel()
+			}
+		}
+		r.running[entity] = pb
+	}
+	r.mu.Unlock()
+
+	go r.run(ctx, pb, effect, previous)
+	return pb
+}
+
+// Stop cancels the effects running on the given entities, restoring them if their effect asked for it
+func (r *EffectRunner) Stop(entities ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entity := range entities {
+		if p, ok := r.running[entity]; ok {
+			p.cancel()
+		}
+	}
+}
+
+func (r *EffectRunner) run(ctx context.Context, pb *Playback, effect Effect, previous []*Playback) {
+	defer close(pb.done)
+
+	// the replaced effects must stop before this one starts so their last calls cannot land after ours
+	for _, p := range previous {
+		<-p.done
+		for _, entity := range pb.entities {
+			if snap, ok := p.saved[entity]; ok {
+				pb.saved[entity] = snap
+			}
+		}
+	}
+
+	if pb.restore {
+		for _, entity := range pb.entities {
+			if _, ok := pb.saved[entity]; ok {
+				continue
+			}
+			snap, err := r.snapshot(ctx, entity)
+			if err != nil {
+				log.Printf("failed to save the state of %s: %v", entity, err)
+				continue
+			}
+			pb.saved[entity] = snap
+		}
+	}
+
+	pb.err = effect(ctx, r.client.Lights(Entities(pb.entities...)))
+
+	r.mu.Lock()
+	handedOver := make(map[string]bool, len(pb.handedOver))
+	for _, entity := range pb.entities {
+		if r.running[entity] == pb {
+			delete(r.running, entity)
+		}
+		handedOver[entity] = pb.handedOver[entity]
+	}
+	r.mu.Unlock()
+
+	if !pb.restore {
+		return
+	}
+	for entity, snap := range pb.saved {
+		if handedOver[entity] {
+			continue
+		}
+		if err := r.restoreSnapshot(entity, snap); err != nil {
+			log.Printf("failed to restore %s: %v", entity, err)
+		}
+	}
+}
+
+// snapshot records the light's current state, as a scene when the runner restores with scenes
+func (r *EffectRunner) snapshot(ctx context.Context, entity string) (lightSnapshot, error) {
+	if r.opts.Restore == RestoreScene {
+		scene := "godice_" + strings.NewReplacer(".", "_", "-", "_").Replace(entity)
+		_, err := r.client.CallService(ctx, "scene", "create", map[string]interface{}{
+			"scene_id":          scene,
+			"snapshot_entities": []string{entity},
+		}, false)
+		return lightSnapshot{scene: "scene." + scene}, err
+	}
+
+	state, err := r.client.GetState(ctx, entity)
+	if err != nil {
+		return lightSnapshot{}, err
+	}
+	return snapshotFromState(state), nil
+}
+
+// snapshotFromState picks the attributes that recreate the light's state in its current color mode
+func snapshotFromState(state *State) lightSnapshot {
+	snap := lightSnapshot{on: state.State == "on", unavailable: state.State == "unavailable" || state.State == "unknown"}
+	attrs := state.Attributes
+
+	if brightness, ok := attrs["brightness"].(float64); ok {
+		b := int(brightness)
+		snap.opts.Brightness = &b
+	}
+	if effect, ok := attrs["effect"].(string); ok && effect != "" && effect != "none" && effect != "off" {
+		snap.opts.Effect = effect
+	}
+
+	switch attrs["color_mode"] {
+	case "color_temp":
+		if kelvin, ok := attrs["color_temp_kelvin"].(float64); ok {
+			snap.opts.Kelvin = int(kelvin)
+		}
+	case "hs":
+		if hs, ok := floats(attrs["hs_color"], 2); ok {
+			snap.opts.HS = &[2]float64{hs[0], hs[1]}
+		}
+	case "xy":
+		if xy, ok := floats(attrs["xy_color"], 2); ok {
+			snap.opts.XY = &[2]float64{xy[0], xy[1]}
+		}
+	case "rgb":
+		if rgb, ok := floats(attrs["rgb_color"], 3); ok {
+			snap.opts.RGB = &color.RGBA{R: uint8(rgb[0]), G: uint8(rgb[1]), B: uint8(rgb[2]), A: 0xFF}
+		}
+	case "rgbw":
+		if rgbw, ok := floats(attrs["rgbw_color"], 4); ok {
+			snap.opts.RGBW = &[4]uint8{uint8(rgbw[0]), uint8(rgbw[1]), uint8(rgbw[2]), uint8(rgbw[3])}
+		}
+	case "rgbww":
+		if rgbww, ok := floats(attrs["rgbww_color"], 5); ok {
+			snap.opts.RGBWW = &[5]uint8{uint8(rgbww[0]), uint8(rgbww[1]), uint8(rgbww[2]), uint8(rgbww[3]), uint8(rgbww[4])}
+		}
+	}
+	return snap
+}
+
+// floats converts a JSON array attribute of n numbers
+func floats(value interface{}, n int) ([]float64, bool) {
+	list, ok := value.([]interface{})
+	if !ok || len(list) != n {
+		return nil, false
+	}
+	out := make([]float64, n)
+	for i, v := range list {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, false
+		}
+		out[i] = f
+	}
+	return out, true
+}
+
+func (r *EffectRunner) restoreSnapshot(entity string, snap lightSnapshot) error {
+	ctx := context.Background()
+	if snap.scene != "" {
+		_, err := r.client.CallService(ctx, "scene", "turn_on", map[string]interface{}{"entity_id": snap.scene}, false)
+		return err
+	}
+	if snap.unavailable {
+		return nil
+	}
+
+	lights := r.client.Lights(Entities(entity))
+	if !snap.on {
+		return lights.TurnOff(ctx, 0)
+	}
+	return lights.TurnOn(ctx, snap.opts)
+}
+
+// Done is closed once the effect has ended and the lights have been restored
+func (pb *Playback) Done() <-chan struct{} {
+	return pb.done
+}
+
+// Wait blocks until the effect has ended, returning its error
+func (pb *Playback) Wait() error {
+	<-pb.done
+	return pb.err
+}
+
+// Cancel stops the effect, restoring the lights if it was asked to
+func (pb *Playback) Cancel() {
+	pb.cancel()
+}
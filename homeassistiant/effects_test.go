@@ -0,0 +1,242 @@
+package homeassistiant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLights serves the light states and services the effect runner uses, recording every call
+type fakeLights struct {
+	t      *testing.T
+	mu     sync.Mutex
+	states map[string]*State
+	calls  []string
+	// changed receives every recorded call
+	changed chan string
+}
+
+func newFakeLights(t *testing.T, states ...State) (*fakeLights, *HAClient) {
+	t.Helper()
+	f := &fakeLights{t: t, states: make(map[string]*State), changed: make(chan string, 64)}
+	for i := range states {
+		f.states[states[i].EntityID] = &states[i]
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+	return f, newTestClient(srv)
+}
+
+func (f *fakeLights) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if entity, ok := strings.CutPrefix(r.URL.Path, "/api/states/"); ok && r.Method == http.MethodGet {
+		f.record("get " + entity)
+		state, ok := f.states[entity]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(state)
+		return
+	}
+
+	service, ok := strings.CutPrefix(r.URL.Path, "/api/services/")
+	if !ok || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var data map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&data)
+	encoded, _ := json.Marshal(data)
+	f.record(service + " " + string(encoded))
+
+	var ids []any
+	switch v := data["entity_id"].(type) {
+	case []any:
+		ids = v
+	case string:
+		ids = []any{v}
+	}
+	for _, id := range ids {
+		state, ok := f.states[id.(string)]
+		if !ok {
+			continue
+		}
+		switch service {
+		case "light/turn_on":
+			state.State = "on"
+			for _, mode := range []string{"rgb", "hs", "xy", "rgbw", "rgbww"} {
+				if value, ok := data[mode+"_color"]; ok {
+					state.Attributes = map[string]any{"color_mode": mode, mode + "_color": value}
+				}
+			}
+			if kelvin, ok := data["color_temp_kelvin"]; ok {
+				state.Attributes = map[string]any{"color_mode": "color_temp", "color_temp_kelvin": kelvin}
+			}
+			if brightness, ok := data["brightness"]; ok {
+				state.Attributes["brightness"] = brightness
+			}
+		case "light/turn_off":
+			state.State = "off"
+		}
+	}
+	_, _ = w.Write([]byte(`[]`))
+}
+
+// record must be called with f.mu held
+func (f *fakeLights) record(call string) {
+	f.calls = append(f.calls, call)
+	select {
+	case f.changed <- call:
+	default:
+	}
+}
+
+// waitCall waits until a call starting with prefix has been made
+func (f *fakeLights) waitCall(prefix string) {
+	f.t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		f.mu.Lock()
+		for _, call := range f.calls {
+			if strings.HasPrefix(call, prefix) {
+				f.mu.Unlock()
+				return
+			}
+		}
+		f.mu.Unlock()
+		select {
+		case <-f.changed:
+		case <-timeout:
+			f.t.Fatalf("no call starting with %q in %v", prefix, f.calls)
+		}
+	}
+}
+
+func (f *fakeLights) state(entity string) State {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state := *f.states[entity]
+	encoded, _ := json.Marshal(state.Attributes)
+	state.Attributes = nil
+	_ = json.Unmarshal(encoded, &state.Attributes)
+	return state
+}
+
+func (f *fakeLights) callList() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func warmLamp() State {
+	return State{EntityID: "light.lamp", State: "on", Attributes: map[string]any{
+		"color_mode": "hs",
+		"hs_color":   []any{30.0, 50.0},
+		"brightness": 200.0,
+	}}
+}
+
+func assertWarmLamp(t *testing.T, state State) {
+	t.Helper()
+	hs, _ := state.Attributes["hs_color"].([]any)
+	if state.State != "on" || state.Attributes["color_mode"] != "hs" || len(hs) != 2 || hs[0] != 30.0 || hs[1] != 50.0 ||
+		state.Attributes["brightness"] != 200.0 {
+		t.Errorf("lamp was not restored: %s %v", state.State, state.Attributes)
+	}
+}
+
+func TestEffectRestoresState(t *testing.T) {
+	f, client := newFakeLights(t, warmLamp())
+	runner := NewEffectRunner(client, EffectOptions{})
+
+	red := color.RGBA{R: 255, A: 255}
+	pb := runner.Play(context.Background(), []string{"light.lamp"}, Sequence(Set(LightTurnOn{RGB: &red}), Off()), true)
+	if err := pb.Wait(); err != nil {
+		t.Fatalf("effect: %v", err)
+	}
+
+	calls := f.callList()
+	if len(calls) < 4 || calls[0] != "get light.lamp" {
+		t.Fatalf("the state must be read before the effect, calls: %v", calls)
+	}
+	if !strings.HasPrefix(calls[1], "light/turn_on") || !strings.Contains(calls[1], `"rgb_color":[255,0,0]`) {
+		t.Errorf("effect call = %s", calls[1])
+	}
+	if last := calls[len(calls)-1]; !strings.Contains(last, `"hs_color":[30,50]`) || !strings.Contains(last, `"brightness":200`) {
+		t.Errorf("restore call = %s", last)
+	}
+	assertWarmLamp(t, f.state("light.lamp"))
+}
+
+func TestEffectRestoresOffLight(t *testing.T) {
+	f, client := newFakeLights(t, State{EntityID: "light.lamp", State: "off", Attributes: map[string]any{}})
+	runner := NewEffectRunner(client, EffectOptions{})
+
+	blue := color.RGBA{B: 255, A: 255}
+	if err := runner.Play(context.Background(), []string{"light.lamp"}, Set(LightTurnOn{RGB: &blue}), true).Wait(); err != nil {
+		t.Fatalf("effect: %v", err)
+	}
+	if state := f.state("light.lamp"); state.State != "off" {
+		t.Errorf("lamp is %s, want off", state.State)
+	}
+}
+
+func TestTakeOverKeepsOriginalSnapshot(t *testing.T) {
+	f, client := newFakeLights(t, warmLamp())
+	runner := NewEffectRunner(client, EffectOptions{})
+
+	red := color.RGBA{R: 255, A: 255}
+	first := runner.Play(context.Background(), []string{"light.lamp"}, Sequence(Set(LightTurnOn{RGB: &red}), Hold(time.Minute)), true)
+	f.waitCall("light/turn_on")
+
+	blue := color.RGBA{B: 255, A: 255}
+	second := runner.Play(context.Background(), []string{"light.lamp"}, Set(LightTurnOn{RGB: &blue}), true)
+	if err := second.Wait(); err != nil {
+		t.Fatalf("second effect: %v", err)
+	}
+	if err := first.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("first effect = %v, want context.Canceled", err)
+	}
+
+	gets := 0
+	for _, call := range f.callList() {
+		if strings.HasPrefix(call, "get ") {
+			gets++
+		}
+	}
+	if gets != 1 {
+		t.Errorf("the light was read %d times, the red of the first effect must not be snapshotted: %v", gets, f.callList())
+	}
+	assertWarmLamp(t, f.state("light.lamp"))
+}
+
+func TestEffectRestoresScene(t *testing.T) {
+	f, client := newFakeLights(t, warmLamp())
+	runner := NewEffectRunner(client, EffectOptions{Restore: RestoreScene})
+
+	red := color.RGBA{R: 255, A: 255}
+	if err := runner.Play(context.Background(), []string{"light.lamp"}, Set(LightTurnOn{RGB: &red}), true).Wait(); err != nil {
+		t.Fatalf("effect: %v", err)
+	}
+
+	calls := f.callList()
+	want := []string{
+		`scene/create {"scene_id":"godice_light_lamp","snapshot_entities":["light.lamp"]}`,
+		`light/turn_on {"entity_id":["light.lamp"],"rgb_color":[255,0,0]}`,
+		`scene/turn_on {"entity_id":"scene.godice_light_lamp"}`,
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("calls:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+}
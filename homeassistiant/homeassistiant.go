@@ -2,18 +2,60 @@ package homeassistiant
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"time"
 )
 
+// ErrUnauthorized is wrapped by the APIError of a request Home Assistant rejected the token for
+var ErrUnauthorized = errors.New("home assistant: unauthorized")
+
+// ErrNotFound is wrapped by the APIError of a request for an entity or endpoint that does not exist
+var ErrNotFound = errors.New("home assistant: not found")
+
+// APIError is a non-success response from the Home Assistant REST API.
+// Use errors.Is with ErrUnauthorized or ErrNotFound to check for the common cases.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("home assistant %s %s failed with %s", e.Method, e.Path, e.Status)
+	}
+	return fmt.Sprintf("home assistant %s %s failed with %s: %s", e.Method, e.Path, e.Status, e.Body)
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNotFound
+	}
+	return nil
+}
+
 // HAClient represents a Home Assistant API client
 type HAClient struct {
 	baseURL    string
 	token      string
 	httpClient *http.Client
+	// timeout bounds each attempt of a request, separately from the caller's context
+	timeout time.Duration
+	// retries is how many times idempotent requests are retried, waiting retryDelay and doubling it each time
+	retries    int
+	retryDelay time.Duration
 }
 
 // State represents a Home Assistant entity state
@@ -66,268 +108,201 @@ type ServiceResult struct {
 // NewClient creates a new Home Assistant client
 func NewClient(baseURL string, token string) *HAClient {
 	return &HAClient{
-		baseURL: baseURL,
-		token:   token,
-		httpClient: &http.Client{
-			Timeout: time.Second * 10,
-		},
+		baseURL:    baseURL,
+		token:      token,
+		httpClient: &http.Client{},
+		timeout:    time.Second * 10,
+		retries:    3,
+		retryDelay: 250 * time.Millisecond,
 	}
 }
 
 // GetStates retrieves all entity states
-func (haClient *HAClient) GetStates() ([]State, error) {
-	path := "/api/states"
-	req, err := haClient.makeRequest(http.MethodGet, path, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := haClient.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
-
+func (haClient *HAClient) GetStates(ctx context.Context) ([]State, error) {
 	var states []State
-	if err := json.NewDecoder(resp.Body).Decode(&states); err != nil {
+	if err := haClient.do(ctx, http.MethodGet, "/api/states", nil, &states); err != nil {
 		return nil, err
 	}
-
-	return states, err
+	return states, nil
 }
 
 // GetState retrieves state for a specific entity
-func (haClient *HAClient) GetState(entityID string) (*State, error) {
-	path := fmt.Sprintf("/api/states/%s", entityID)
-	req, err := haClient.makeRequest(http.MethodGet, path, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := haClient.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
-
+func (haClient *HAClient) GetState(ctx context.Context, entityID string) (*State, error) {
 	var state State
-	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+	if err := haClient.do(ctx, http.MethodGet, fmt.Sprintf("/api/states/%s", entityID), nil, &state); err != nil {
 		return nil, err
 	}
-
-	return &state, err
+	return &state, nil
 }
 
 // GetErrorLog retrieves the error log
-func (haClient *HAClient) GetErrorLog() (string, error) {
-	path := "/api/error_log"
-
-	req, err := haClient.makeRequest(http.MethodGet, path, nil)
-	if err != nil {
+func (haClient *HAClient) GetErrorLog(ctx context.Context) (string, error) {
+	var logText []byte
+	if err := haClient.do(ctx, http.MethodGet, "/api/error_log", nil, &logText); err != nil {
 		return "", err
 	}
-
-	resp, err := haClient.httpClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	defer resp.Body.Close()
-
-	logText, err := io.ReadAll(resp.Body)
-	return string(logText), err
+	return string(logText), nil
 }
 
 // GetCameraProxy retrieves camera image
-func (haClient *HAClient) GetCameraProxy(entityID string) ([]byte, error) {
-	return haClient.getRaw(fmt.Sprintf("/api/camera_proxy/%s", entityID))
-}
-
-// GetCalendars retrieves all calendars
-func (haClient *HAClient) GetCalendars() ([]Calendar, error) {
-	path := "/api/calendars"
-	req, err := haClient.makeRequest(http.MethodGet, path, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := haClient.httpClient.Do(req)
-	if err != nil {
+func (haClient *HAClient) GetCameraProxy(ctx context.Context, entityID string) ([]byte, error) {
+	var image []byte
+	if err := haClient.do(ctx, http.MethodGet, fmt.Sprintf("/api/camera_proxy/%s", entityID), nil, &image); err != nil {
 		return nil, err
 	}
+	return image, nil
+}
 
-	defer resp.Body.Close()
-
+// GetCalendars retrieves all calendars
+func (haClient *HAClient) GetCalendars(ctx context.Context) ([]Calendar, error) {
 	var calendars []Calendar
-	if err := json.NewDecoder(resp.Body).Decode(&calendars); err != nil {
+	if err := haClient.do(ctx, http.MethodGet, "/api/calendars", nil, &calendars); err != nil {
 		return nil, err
 	}
-
-	return calendars, err
+	return calendars, nil
 }
 
 // GetCalendarEvents retrieves calendar events
-func (haClient *HAClient) GetCalendarEvents(entityID string, start, end time.Time) ([]CalendarEvent, error) {
+func (haClient *HAClient) GetCalendarEvents(ctx context.Context, entityID string, start, end time.Time) ([]CalendarEvent, error) {
 	path := fmt.Sprintf("/api/calendars/%s?start=%s&end=%s",
 		entityID,
-		start.Format(time.RFC3339),
-		end.Format(time.RFC3339))
-
-	req, err := haClient.makeRequest(http.MethodGet, path, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := haClient.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
+		url.QueryEscape(start.Format(time.RFC3339)),
+		url.QueryEscape(end.Format(time.RFC3339)))
 
 	var events []CalendarEvent
-	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+	if err := haClient.do(ctx, http.MethodGet, path, nil, &events); err != nil {
 		return nil, err
 	}
-
-	return events, err
+	return events, nil
 }
 
 // CallService calls a service within a specific domain
-func (haClient *HAClient) CallService(domain, service string, data interface{}, returnResponse bool) (*ServiceResult, error) {
+func (haClient *HAClient) CallService(ctx context.Context, domain, service string, data interface{}, returnResponse bool) (*ServiceResult, error) {
 	path := fmt.Sprintf("/api/services/%s/%s", domain, service)
 	if returnResponse {
 		path += "?return_response"
-	}
-
-	req, err := haClient.makeRequest(http.MethodPost, path, data)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := haClient.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		bodyTxt, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with %s: %s", resp.Status, bodyTxt)
-	}
-
-	defer resp.Body.Close()
-
-	var result ServiceResult
-	if returnResponse {
-		var tempResult ServiceResult
-		if err := json.NewDecoder(resp.Body).Decode(&tempResult); err != nil {
+		var result ServiceResult
+		if err := haClient.do(ctx, http.MethodPost, path, data, &result); err != nil {
 			return nil, err
 		}
-
-		result = tempResult
-	} else {
-		var tempResult []ChangedState
-		if err := json.NewDecoder(resp.Body).Decode(&tempResult); err != nil {
-			return nil, err
-		}
-		result.ChangedStates = tempResult
+		return &result, nil
 	}
 
-	return &result, nil
+	var changed []ChangedState
+	if err := haClient.do(ctx, http.MethodPost, path, data, &changed); err != nil {
+		return nil, err
+	}
+	return &ServiceResult{ChangedStates: changed}, nil
 }
 
 // UpdateState updates an entity state
-func (haClient *HAClient) UpdateState(entityID string, state *State) (*State, error) {
-	path := fmt.Sprintf("/api/states/%s", entityID)
-
-	req, err := haClient.makeRequest(http.MethodPost, path, state)
-	if err != nil {
+func (haClient *HAClient) UpdateState(ctx context.Context, entityID string, state *State) (*State, error) {
+	var updatedState State
+	if err := haClient.do(ctx, http.MethodPost, fmt.Sprintf("/api/states/%s", entityID), state, &updatedState); err != nil {
 		return nil, err
 	}
+	return &updatedState, nil
+}
 
-	resp, err := haClient.httpClient.Do(req)
-	if err != nil {
-		return nil, err
+// FireEvent fires an event of the given type with optional event data, returning Home Assistant's confirmation message
+func (haClient *HAClient) FireEvent(ctx context.Context, eventType string, data interface{}) (string, error) {
+	var result struct {
+		Message string `json:"message"`
 	}
-
-	defer resp.Body.Close()
-
-	var updatedState State
-	if err := json.NewDecoder(resp.Body).Decode(&updatedState); err != nil {
-		return nil, err
+	if err := haClient.do(ctx, http.MethodPost, fmt.Sprintf("/api/events/%s", eventType), data, &result); err != nil {
+		return "", err
 	}
-
-	return &updatedState, err
+	return result.Message, nil
 }
 
-func (haClient *HAClient) getRaw(path string) ([]byte, error) {
-	req, err := http.NewRequest(http.MethodGet, haClient.baseURL+path, nil)
-	if err != nil {
-		return nil, err
+// do sends a request and decodes the JSON response into out, or stores the raw body when out is a *[]byte.
+// GET and DELETE requests are retried with backoff after network errors, timeouts, 429 and 5xx responses,
+// until ctx is done.
+func (haClient *HAClient) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
 	}
 
-	req.Header.Set("Authorization", "Bearer "+haClient.token)
-	resp, err := haClient.httpClient.Do(req)
-	if err != nil {
-		return nil, err
+	attempts := 1
+	if method == http.MethodGet || method == http.MethodDelete {
+		attempts += haClient.retries
 	}
-	defer resp.Body.Close()
+	delay := haClient.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := haClient.send(ctx, method, path, payload, out)
+		if err == nil || attempt >= attempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed: %s", resp.Status)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
 	}
-
-	var buf bytes.Buffer
-	_, err = buf.ReadFrom(resp.Body)
-	return buf.Bytes(), err
 }
 
-func (haClient *HAClient) _doRequest(method, path string, body interface{}) (*http.Response, error) {
-	var buf bytes.Buffer
-	if body != nil {
-		if err := json.NewEncoder(&buf).Encode(body); err != nil {
-			return nil, err
-		}
-	}
+func (haClient *HAClient) send(ctx context.Context, method, path string, payload []byte, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, haClient.timeout)
+	defer cancel()
 
-	req, err := http.NewRequest(method, haClient.baseURL+path, &buf)
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, haClient.baseURL+path, body)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+haClient.token)
-	req.Header.Set("Content-Type", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := haClient.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with %s: %s", resp.Status, b)
-	}
-	return resp, nil
-}
-
-func (haClient *HAClient) makeRequest(method, path string, body interface{}) (*http.Request, error) {
-	var buf bytes.Buffer
-	if body != nil {
-		if err := json.NewEncoder(&buf).Encode(body); err != nil {
-			return nil, err
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       string(bytes.TrimSpace(text)),
 		}
 	}
 
-	req, err := http.NewRequest(method, haClient.baseURL+path, &buf)
-	if err != nil {
-		return nil, err
+	switch out := out.(type) {
+	case nil:
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	case *[]byte:
+		*out, err = io.ReadAll(resp.Body)
+		return err
+	default:
+		return json.NewDecoder(resp.Body).Decode(out)
 	}
+}
 
-	req.Header.Set("Authorization", "Bearer "+haClient.token)
-	req.Header.Set("Content-Type", "application/json")
-
-	return req, nil
+// retryable reports whether a failed request may succeed when sent again.
+// The caller's own cancellation is checked separately, a deadline here is an attempt timing out.
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
 }
@@ -0,0 +1,221 @@
+package homeassistiant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient creates a client for srv that retries without waiting
+func newTestClient(srv *httptest.Server) *HAClient {
+	client := NewClient(srv.URL, testToken)
+	client.retryDelay = time.Millisecond
+	return client
+}
+
+func TestStatusErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		is     error
+	}{
+		{http.StatusUnauthorized, "401: Unauthorized", ErrUnauthorized},
+		{http.StatusNotFound, `{"message": "Entity not found."}`, ErrNotFound},
+		{http.StatusBadRequest, `{"message": "Invalid JSON specified."}`, nil},
+		{http.StatusInternalServerError, "500 Internal Server Error", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer "+testToken {
+					t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+				}
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+
+			_, err := newTestClient(srv).GetState(context.Background(), "light.lamp")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want *APIError", err)
+			}
+			if apiErr.StatusCode != test.status || apiErr.Body != test.body || apiErr.Path != "/api/states/light.lamp" {
+				t.Errorf("APIError = %+v", apiErr)
+			}
+			if test.is != nil && !errors.Is(err, test.is) {
+				t.Errorf("errors.Is(%v, %v) = false", err, test.is)
+			}
+			for _, other := range []error{ErrUnauthorized, ErrNotFound} {
+				if other != test.is && errors.Is(err, other) {
+					t.Errorf("errors.Is(%v, %v) = true", err, other)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			_, _ = w.Write([]byte(`{"entity_id": "light.lamp", "state": "on"}`))
+		}
+	}))
+	defer srv.Close()
+
+	state, err := newTestClient(srv).GetState(context.Background(), "light.lamp")
+	if err != nil {
+		t.Fatalf("GetState: %v", err)
+	}
+	if state.State != "on" || calls.Load() != 3 {
+		t.Errorf("state %q after %d calls, want on after 3", state.State, calls.Load())
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	client := newTestClient(srv)
+	_, err := client.GetStates(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("err = %v, want 502 APIError", err)
+	}
+	if int(calls.Load()) != client.retries+1 {
+		t.Errorf("calls = %d, want %d", calls.Load(), client.retries+1)
+	}
+}
+
+func TestNoRetryForPost(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	_, err := newTestClient(srv).CallService(context.Background(), "light", "turn_on", nil, false)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestRetryAttemptTimeout(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	client := newTestClient(srv)
+	client.timeout = 50 * time.Millisecond
+	if _, err := client.GetStates(context.Background()); err != nil {
+		t.Fatalf("GetStates: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("calls = %d, want the timed out attempt to be retried once", calls.Load())
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := newTestClient(srv).GetStates(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancel took %s", elapsed)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, a cancelled request must not be retried", calls.Load())
+	}
+}
+
+func TestCallServiceDecodesChangedStates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/services/light/turn_on" {
+			t.Errorf("%s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		_, _ = w.Write([]byte(`[{"entity_id": "light.lamp", "state": "on"}]`))
+	}))
+	defer srv.Close()
+
+	result, err := newTestClient(srv).LightTurnOn(context.Background(), LightTurnOn{Target: Entities("light.lamp")})
+	if err != nil {
+		t.Fatalf("LightTurnOn: %v", err)
+	}
+	if len(result.ChangedStates) != 1 || result.ChangedStates[0].State != "on" {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestLightTemperature(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+	client := newTestClient(srv)
+
+	if _, err := client.LightTemperatureKelvin(context.Background(), "light.lamp", 2700); err != nil {
+		t.Fatalf("LightTemperatureKelvin: %v", err)
+	}
+	if body["color_temp_kelvin"] != float64(2700) {
+		t.Errorf("Kelvin body = %v", body)
+	}
+
+	// mireds are still accepted and sent as Kelvin
+	if _, err := client.LightTemperature(context.Background(), "light.lamp", 370); err != nil {
+		t.Fatalf("LightTemperature: %v", err)
+	}
+	if body["color_temp_kelvin"] != float64(2703) {
+		t.Errorf("mired body = %v", body)
+	}
+	if _, err := client.LightTemperature(context.Background(), "light.lamp", 0); err == nil {
+		t.Error("0 mireds was accepted")
+	}
+}
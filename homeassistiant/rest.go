@@ -0,0 +1,243 @@
+package homeassistiant
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config is the core configuration of a Home Assistant instance
+type Config struct {
+	Components            []string          `json:"components"`
+	ConfigDir             string            `json:"config_dir"`
+	Elevation             float64           `json:"elevation"`
+	Latitude              float64           `json:"latitude"`
+	Longitude             float64           `json:"longitude"`
+	LocationName          string            `json:"location_name"`
+	TimeZone              string            `json:"time_zone"`
+	UnitSystem            map[string]string `json:"unit_system"`
+	Version               string            `json:"version"`
+	WhitelistExternalDirs []string          `json:"whitelist_external_dirs"`
+	AllowlistExternalDirs []string          `json:"allowlist_external_dirs"`
+	State                 string            `json:"state"`
+	ExternalURL           string            `json:"external_url"`
+	InternalURL           string            `json:"internal_url"`
+	Currency              string            `json:"currency"`
+	Country               string            `json:"country"`
+	Language              string            `json:"language"`
+	SafeMode              bool              `json:"safe_mode"`
+	RecoveryMode          bool              `json:"recovery_mode"`
+}
+
+// EventListener is an event type with the number of listeners Home Assistant has for it
+type EventListener struct {
+	Event         string `json:"event"`
+	ListenerCount int    `json:"listener_count"`
+}
+
+// ServiceDomain lists the services of a domain
+type ServiceDomain struct {
+	Domain   string                        `json:"domain"`
+	Services map[string]ServiceDescription `json:"services"`
+}
+
+// ServiceDescription describes a service and the fields it takes
+type ServiceDescription struct {
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	Fields      map[string]ServiceField `json:"fields"`
+	Target      map[string]interface{}  `json:"target,omitempty"`
+	Response    map[string]interface{}  `json:"response,omitempty"`
+}
+
+// ServiceField describes a field of a service call
+type ServiceField struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Required    bool                   `json:"required"`
+	Example     interface{}            `json:"example,omitempty"`
+	Selector    map[string]interface{} `json:"selector,omitempty"`
+}
+
+// HistoryOptions narrow a history query, zero values use Home Assistant's defaults
+type HistoryOptions struct {
+	// End defaults to one day after the start
+	End       time.Time
+	EntityIds []string
+	// MinimalResponse only returns the state and last_changed of all but the first and last state of each entity
+	MinimalResponse        bool
+	NoAttributes           bool
+	SignificantChangesOnly bool
+}
+
+// LogbookEntry is an entry of the logbook
+type LogbookEntry struct {
+	When          time.Time `json:"when"`
+	Name          string    `json:"name"`
+	Message       string    `json:"message"`
+	EntityID      string    `json:"entity_id"`
+	State         string    `json:"state"`
+	Domain        string    `json:"domain"`
+	Icon          string    `json:"icon"`
+	ContextUserID string    `json:"context_user_id"`
+}
+
+// ConfigCheck is the result of checking the configuration files, Result is "valid" or "invalid"
+type ConfigCheck struct {
+	Result   string `json:"result"`
+	Errors   string `json:"errors"`
+	Warnings string `json:"warnings"`
+}
+
+// IntentResponse is Home Assistant's answer to a handled intent
+type IntentResponse struct {
+	Speech       map[string]IntentSpeech `json:"speech"`
+	Card         map[string]interface{}  `json:"card"`
+	Language     string                  `json:"language"`
+	ResponseType string                  `json:"response_type"`
+	Data         map[string]interface{}  `json:"data"`
+}
+
+// IntentSpeech is the text to say for an intent, keyed by type ("plain" or "ssml") in IntentResponse
+type IntentSpeech struct {
+	Speech    string      `json:"speech"`
+	ExtraData interface{} `json:"extra_data"`
+}
+
+// CheckAPI checks the API is running, returning Home Assistant's message
+func (haClient *HAClient) CheckAPI(ctx context.Context) (string, error) {
+	var result struct {
+		Message string `json:"message"`
+	}
+	if err := haClient.do(ctx, http.MethodGet, "/api/", nil, &result); err != nil {
+		return "", err
+	}
+	return result.Message, nil
+}
+
+// GetConfig retrieves the core configuration
+func (haClient *HAClient) GetConfig(ctx context.Context) (*Config, error) {
+	var config Config
+	if err := haClient.do(ctx, http.MethodGet, "/api/config", nil, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// GetEvents retrieves the event types that have listeners
+func (haClient *HAClient) GetEvents(ctx context.Context) ([]EventListener, error) {
+	var events []EventListener
+	if err := haClient.do(ctx, http.MethodGet, "/api/events", nil, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetServices retrieves the available services by domain
+func (haClient *HAClient) GetServices(ctx context.Context) ([]ServiceDomain, error) {
+	var domains []ServiceDomain
+	if err := haClient.do(ctx, http.MethodGet, "/api/services", nil, &domains); err != nil {
+		return nil, err
+	}
+	return domains, nil
+}
+
+// GetHistory retrieves the state changes since start, one list per entity
+func (haClient *HAClient) GetHistory(ctx context.Context, start time.Time, opts HistoryOptions) ([][]State, error) {
+	query := url.Values{}
+	if len(opts.EntityIds) > 0 {
+		query.Set("filter_entity_id", strings.Join(opts.EntityIds, ","))
+	}
+	if !opts.End.IsZero() {
+		query.Set("end_time", opts.End.UTC().Format(time.RFC3339))
+	}
+	if opts.MinimalResponse {
+		query.Set("minimal_response", "")
+	}
+	if opts.NoAttributes {
+		query.Set("no_attributes", "")
+	}
+	if opts.SignificantChangesOnly {
+		query.Set("significant_changes_only", "")
+	}
+
+	var history [][]State
+	if err := haClient.do(ctx, http.MethodGet, timePath("/api/history/period", start, query), nil, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// GetLogbook retrieves the logbook entries between start and end, a zero end meaning one day after start.
+// An empty entityID returns the entries of every entity.
+func (haClient *HAClient) GetLogbook(ctx context.Context, start, end time.Time, entityID string) ([]LogbookEntry, error) {
+	query := url.Values{}
+	if entityID != "" {
+		query.Set("entity", entityID)
+	}
+	if !end.IsZero() {
+		query.Set("end_time", end.UTC().Format(time.RFC3339))
+	}
+
+	var entries []LogbookEntry
+	if err := haClient.do(ctx, http.MethodGet, timePath("/api/logbook", start, query), nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RenderTemplate renders a Home Assistant template with optional variables
+func (haClient *HAClient) RenderTemplate(ctx context.Context, template string, variables map[string]interface{}) (string, error) {
+	body := map[string]interface{}{"template": template}
+	if len(variables) > 0 {
+		body["variables"] = variables
+	}
+
+	var text []byte
+	if err := haClient.do(ctx, http.MethodPost, "/api/template", body, &text); err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// CheckConfig validates the configuration files
+func (haClient *HAClient) CheckConfig(ctx context.Context) (*ConfigCheck, error) {
+	var check ConfigCheck
+	if err := haClient.do(ctx, http.MethodPost, "/api/config/core/check_config", nil, &check); err != nil {
+		return nil, err
+	}
+	return &check, nil
+}
+
+// HandleIntent handles the named intent with its slot data
+func (haClient *HAClient) HandleIntent(ctx context.Context, name string, data map[string]interface{}) (*IntentResponse, error) {
+	body := map[string]interface{}{"name": name}
+	if len(data) > 0 {
+		body["data"] = data
+	}
+
+	var response IntentResponse
+	if err := haClient.do(ctx, http.MethodPost, "/api/intent/handle", body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// DeleteState removes an entity's state, returning ErrNotFound when it has none
+func (haClient *HAClient) DeleteState(ctx context.Context, entityID string) error {
+	return haClient.do(ctx, http.MethodDelete, fmt.Sprintf("/api/states/%s", entityID), nil, nil)
+}
+
+// timePath appends the start timestamp and query to an API path
+func timePath(path string, start time.Time, query url.Values) string {
+	if !start.IsZero() {
+		path += "/" + url.PathEscape(start.UTC().Format(time.RFC3339))
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
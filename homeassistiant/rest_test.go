@@ -0,0 +1,247 @@
+package homeassistiant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRESTEndpoints(t *testing.T) {
+	start := time.Date(2026, 10, 18, 9, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	end := start.Add(2 * time.Hour)
+
+	tests := []struct {
+		name     string
+		call     func(ctx context.Context, c *HAClient) (any, error)
+		method   string
+		uri      string
+		body     string
+		response string
+		want     any
+	}{
+		{
+			name:     "check api",
+			call:     func(ctx context.Context, c *HAClient) (any, error) { return c.CheckAPI(ctx) },
+			method:   http.MethodGet,
+			uri:      "/api/",
+			response: `{"message": "API running."}`,
+			want:     "API running.",
+		},
+		{
+			name:   "config",
+			call:   func(ctx context.Context, c *HAClient) (any, error) { return c.GetConfig(ctx) },
+			method: http.MethodGet,
+			uri:    "/api/config",
+			response: `{"components": ["light", "mqtt"], "config_dir": "/config", "elevation": 510,
+				"latitude": 45.5, "longitude": -73.5, "location_name": "Home", "time_zone": "America/Montreal",
+				"unit_system": {"length": "km", "temperature": "°C"}, "version": "2026.10.0",
+				"whitelist_external_dirs": ["/config/www"], "state": "RUNNING", "safe_mode": false}`,
+			want: &Config{
+				Components:            []string{"light", "mqtt"},
+				ConfigDir:             "/config",
+				Elevation:             510,
+				Latitude:              45.5,
+				Longitude:             -73.5,
+				LocationName:          "Home",
+				TimeZone:              "America/Montreal",
+				UnitSystem:            map[string]string{"length": "km", "temperature": "°C"},
+				Version:               "2026.10.0",
+				WhitelistExternalDirs: []string{"/config/www"},
+				State:                 "RUNNING",
+			},
+		},
+		{
+			name:     "events",
+			call:     func(ctx context.Context, c *HAClient) (any, error) { return c.GetEvents(ctx) },
+			method:   http.MethodGet,
+			uri:      "/api/events",
+			response: `[{"event": "state_changed", "listener_count": 5}, {"event": "godice_roll", "listener_count": 1}]`,
+			want:     []EventListener{{Event: "state_changed", ListenerCount: 5}, {Event: "godice_roll", ListenerCount: 1}},
+		},
+		{
+			name:   "services",
+			call:   func(ctx context.Context, c *HAClient) (any, error) { return c.GetServices(ctx) },
+			method: http.MethodGet,
+			uri:    "/api/services",
+			response: `[{"domain": "light", "services": {"turn_on": {"name": "Turn on", "description": "Turns on lights.",
+				"fields": {"brightness": {"name": "Brightness value", "required": false, "example": 120,
+				"selector": {"number": {"min": 0, "max": 255}}}}, "target": {"entity": [{"domain": ["light"]}]}}}}]`,
+			want: []ServiceDomain{{
+				Domain: "light",
+				Services: map[string]ServiceDescription{"turn_on": {
+					Name:        "Turn on",
+					Description: "Turns on lights.",
+					Fields: map[string]ServiceField{"brightness": {
+						Name:     "Brightness value",
+						Example:  float64(120),
+						Selector: map[string]any{"number": map[string]any{"min": float64(0), "max": float64(255)}},
+					}},
+					Target: map[string]any{"entity": []any{map[string]any{"domain": []any{"light"}}}},
+				}},
+			}},
+		},
+		{
+			name: "history",
+			call: func(ctx context.Context, c *HAClient) (any, error) {
+				return c.GetHistory(ctx, start, HistoryOptions{
+					End:                    end,
+					EntityIds:              []string{"light.lamp", "light.desk"},
+					MinimalResponse:        true,
+					NoAttributes:           true,
+					SignificantChangesOnly: true,
+				})
+			},
+			method: http.MethodGet,
+			uri: "/api/history/period/2026-10-18T07:30:00Z?end_time=2026-10-18T09%3A30%3A00Z" +
+				"&filter_entity_id=light.lamp%2Clight.desk&minimal_response=&no_attributes=&significant_changes_only=",
+			response: `[[{"entity_id": "light.lamp", "state": "on", "last_changed": "2026-10-18T07:31:00Z"},
+				{"state": "off", "last_changed": "2026-10-18T08:00:00Z"}], []]`,
+			want: [][]State{
+				{
+					{EntityID: "light.lamp", State: "on", LastChanged: time.Date(2026, 10, 18, 7, 31, 0, 0, time.UTC)},
+					{State: "off", LastChanged: time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC)},
+				},
+				{},
+			},
+		},
+		{
+			name: "history without start",
+			call: func(ctx context.Context, c *HAClient) (any, error) {
+				return c.GetHistory(ctx, time.Time{}, HistoryOptions{})
+			},
+			method:   http.MethodGet,
+			uri:      "/api/history/period",
+			response: `[]`,
+			want:     [][]State{},
+		},
+		{
+			name: "logbook",
+			call: func(ctx context.Context, c *HAClient) (any, error) {
+				return c.GetLogbook(ctx, start, end, "light.lamp")
+			},
+			method: http.MethodGet,
+			uri:    "/api/logbook/2026-10-18T07:30:00Z?end_time=2026-10-18T09%3A30%3A00Z&entity=light.lamp",
+			response: `[{"when": "2026-10-18T07:45:00Z", "name": "Lamp", "state": "on",
+				"entity_id": "light.lamp", "domain": "light", "context_user_id": "abc"}]`,
+			want: []LogbookEntry{{
+				When:          time.Date(2026, 10, 18, 7, 45, 0, 0, time.UTC),
+				Name:          "Lamp",
+				State:         "on",
+				EntityID:      "light.lamp",
+				Domain:        "light",
+				ContextUserID: "abc",
+			}},
+		},
+		{
+			name: "template",
+			call: func(ctx context.Context, c *HAClient) (any, error) {
+				return c.RenderTemplate(ctx, "Rolled {{ face }}", map[string]any{"face": 20})
+			},
+			method:   http.MethodPost,
+			uri:      "/api/template",
+			body:     `{"template": "Rolled {{ face }}", "variables": {"face": 20}}`,
+			response: "Rolled 20",
+			want:     "Rolled 20",
+		},
+		{
+			name:     "check config",
+			call:     func(ctx context.Context, c *HAClient) (any, error) { return c.CheckConfig(ctx) },
+			method:   http.MethodPost,
+			uri:      "/api/config/core/check_config",
+			response: `{"result": "invalid", "errors": "Integration error: godice", "warnings": null}`,
+			want:     &ConfigCheck{Result: "invalid", Errors: "Integration error: godice"},
+		},
+		{
+			name: "intent",
+			call: func(ctx context.Context, c *HAClient) (any, error) {
+				return c.HandleIntent(ctx, "HassTurnOn", map[string]any{"name": "lamp"})
+			},
+			method: http.MethodPost,
+			uri:    "/api/intent/handle",
+			body:   `{"name": "HassTurnOn", "data": {"name": "lamp"}}`,
+			response: `{"speech": {"plain": {"speech": "Turned on the lamp", "extra_data": null}}, "card": {},
+				"language": "en", "response_type": "action_done", "data": {"targets": []}}`,
+			want: &IntentResponse{
+				Speech:       map[string]IntentSpeech{"plain": {Speech: "Turned on the lamp"}},
+				Card:         map[string]any{},
+				Language:     "en",
+				ResponseType: "action_done",
+				Data:         map[string]any{"targets": []any{}},
+			},
+		},
+		{
+			name: "delete state",
+			call: func(ctx context.Context, c *HAClient) (any, error) {
+				return nil, c.DeleteState(ctx, "sensor.godice_last_roll")
+			},
+			method:   http.MethodDelete,
+			uri:      "/api/states/sensor.godice_last_roll",
+			response: `{"message": "Entity removed."}`,
+		},
+		{
+			name: "fire event",
+			call: func(ctx context.Context, c *HAClient) (any, error) {
+				return c.FireEvent(ctx, "godice_roll", map[string]any{"face": 20})
+			},
+			method:   http.MethodPost,
+			uri:      "/api/events/godice_roll",
+			body:     `{"face": 20}`,
+			response: `{"message": "Event godice_roll fired."}`,
+			want:     "Event godice_roll fired.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != test.method || r.URL.RequestURI() != test.uri {
+					t.Errorf("request %s %s, want %s %s", r.Method, r.URL.RequestURI(), test.method, test.uri)
+				}
+				body, _ := io.ReadAll(r.Body)
+				if !sameJSON(string(body), test.body) {
+					t.Errorf("body %s, want %s", body, test.body)
+				}
+				_, _ = w.Write([]byte(test.response))
+			}))
+			defer srv.Close()
+
+			got, err := test.call(context.Background(), newTestClient(srv))
+			if err != nil {
+				t.Fatalf("call: %v", err)
+			}
+			if test.want != nil && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v\nwant %#v", got, test.want)
+			}
+		})
+	}
+}
+
+// sameJSON compares two JSON documents, treating two empty strings as equal
+func sameJSON(a, b string) bool {
+	if a == "" || b == "" {
+		return a == b
+	}
+	var va, vb any
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestDeleteStateNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	err := newTestClient(srv).DeleteState(context.Background(), "sensor.missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
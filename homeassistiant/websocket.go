@@ -0,0 +1,571 @@
+package homeassistiant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAuthInvalid is returned by WSClient.Run when Home Assistant rejects the access token
+var ErrAuthInvalid = errors.New("home assistant rejected the access token")
+
+// ErrConnectionLost fails requests that were in flight when the WebSocket dropped
+var ErrConnectionLost = errors.New("home assistant websocket connection lost")
+
+// WSError is an error result returned by Home Assistant for a WebSocket command
+type WSError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *WSError) Error() string {
+	return fmt.Sprintf("home assistant error %s: %s", e.Code, e.Message)
+}
+
+// Event is an event delivered to a WebSocket subscription.
+// Trigger subscriptions fill Variables instead of EventType and Data.
+type Event struct {
+	EventType string          `json:"event_type"`
+	Data      json.RawMessage `json:"data"`
+	Origin    string          `json:"origin"`
+	TimeFired time.Time       `json:"time_fired"`
+	Variables json.RawMessage `json:"variables,omitempty"`
+}
+
+// WSOptions tune the WebSocket keepalive and reconnect behavior, zero values use the defaults
+type WSOptions struct {
+	// PingInterval is how often a ping is sent, default 30s
+	PingInterval time.Duration
+	// PongTimeout is how long to wait for the pong before reconnecting, default 10s
+	PongTimeout time.Duration
+	// ReconnectDelay is the first reconnect delay, doubling up to MaxReconnectDelay, default 1s and 1m
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+}
+
+// WSClient is a Home Assistant WebSocket API client. Run keeps it connected and
+// authenticated; requests made while it is reconnecting wait for the connection.
+type WSClient struct {
+	url   string
+	token string
+	opts  WSOptions
+
+	mu      sync.Mutex
+	conn    *wsConn
+	ready   chan struct{}
+	nextId  int
+	pending map[int]chan wsResult
+	subs    map[int]*Subscription
+}
+
+// Subscription delivers the events of a subscribe_events or subscribe_trigger command.
+// It is renewed automatically after a reconnect.
+type Subscription struct {
+	client *WSClient
+	cmd    map[string]any
+	events chan Event
+	id     int
+	closed bool
+	err    error
+}
+
+type wsMessage struct {
+	Id      int             `json:"id"`
+	Type    string          `json:"type"`
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Error   *WSError        `json:"error"`
+	Event   json.RawMessage `json:"event"`
+	Message string          `json:"message"`
+}
+
+type wsResult struct {
+	result json.RawMessage
+	err    error
+}
+
+// subscriptionBuffer is the number of undelivered events a subscription holds before dropping new ones
+const subscriptionBuffer = 64
+
+// NewWSClient creates a WebSocket client for the Home Assistant instance at baseURL, e.g. http://homeassistant.local:8123
+func NewWSClient(baseURL string, token string, opts WSOptions) *WSClient {
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = 30 * time.Second
+	}
+	if opts.PongTimeout <= 0 {
+		opts.PongTimeout = 10 * time.Second
+	}
+	if opts.ReconnectDelay <= 0 {
+		opts.ReconnectDelay = time.Second
+	}
+	if opts.MaxReconnectDelay < opts.ReconnectDelay {
+		opts.MaxReconnectDelay = max(time.Minute, opts.ReconnectDelay)
+	}
+
+	return &WSClient{
+		url:     strings.TrimRight(baseURL, "/") + "/api/websocket",
+		token:   token,
+		opts:    opts,
+		ready:   make(chan struct{}),
+		pending: make(map[int]chan wsResult),
+		subs:    make(map[int]*Subscription),
+	}
+}
+
+// WebSocket creates a WebSocket client for the same instance and token as the REST client
+func (haClient *HAClient) WebSocket(opts WSOptions) *WSClient {
+	return NewWSClient(haClient.baseURL, haClient.token, opts)
+}
+
+// Run connects and authenticates, reconnecting whenever the connection drops, until ctx is done.
+// It returns ErrAuthInvalid without retrying if the token is rejected.
+func (c *WSClient) Run(ctx context.Context) error {
+	delay := c.opts.ReconnectDelay
+	for {
+		conn, err := c.connect(ctx)
+		if err == nil {
+			delay = c.opts.ReconnectDelay
+			err = c.serve(ctx, conn)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrAuthInvalid) {
+			return err
+		}
+
+		log.Printf("home assistant websocket: %v, reconnecting in %s", err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, c.opts.MaxReconnectDelay)
+	}
+}
+
+// connect dials and completes the auth handshake
+func (c *WSClient) connect(ctx context.Context) (*wsConn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := dialWebSocket(dialCtx, c.url)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.authenticate(dialCtx, conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *WSClient) authenticate(ctx context.Context, conn *wsConn) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	msg, err := readWSMessage(conn)
+	if err != nil {
+		return err
+	}
+	if msg.Type != "auth_required" {
+		return fmt.Errorf("expected auth_required, got %q", msg.Type)
+	}
+
+	auth, err := json.Marshal(map[string]string{"type": "auth", "access_token": c.token})
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteMessage(auth); err != nil {
+		return err
+	}
+
+	msg, err = readWSMessage(conn)
+	if err != nil {
+		return err
+	}
+	switch msg.Type {
+	case "auth_ok":
+		return nil
+	case "auth_invalid":
+		return fmt.Errorf("%w: %s", ErrAuthInvalid, msg.Message)
+	}
+	return fmt.Errorf("expected auth_ok, got %q", msg.Type)
+}
+
+// serve routes results and events from an authenticated connection until it drops
+func (c *WSClient) serve(ctx context.Context, conn *wsConn) error {
+	c.mu.Lock()
+	c.conn = conn
+	subs := make([]*Subscription, 0, len(c.subs))
+	for _, sub := range c.subs {
+		subs = append(subs, sub)
+	}
+	close(c.ready)
+	c.mu.Unlock()
+
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-serveCtx.Done()
+		_ = conn.Close()
+	}()
+	go c.keepalive(serveCtx, conn)
+	go c.resubscribe(serveCtx, subs)
+
+	var err error
+	for {
+		var msg wsMessage
+		msg, err = readWSMessage(conn)
+		if err != nil {
+			break
+		}
+		c.dispatch(msg)
+	}
+
+	c.mu.Lock()
+	c.conn = nil
+	c.ready = make(chan struct{})
+	for id, ch := range c.pending {
+		delete(c.pending, id)
+		ch <- wsResult{err: ErrConnectionLost}
+	}
+	c.mu.Unlock()
+	return err
+}
+
+// keepalive pings Home Assistant and drops the connection when a pong does not arrive in time
+func (c *WSClient) keepalive(ctx context.Context, conn *wsConn) {
+	ticker := time.NewTicker(c.opts.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, c.opts.PongTimeout)
+			err := c.Ping(pingCtx)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				log.Printf("home assistant websocket ping failed: %v", err)
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// resubscribe renews the subscriptions of a previous connection. A subscription Home Assistant
+// refuses is closed, its Err reports why.
+func (c *WSClient) resubscribe(ctx context.Context, subs []*Subscription) {
+	for _, sub := range subs {
+		err := c.renew(ctx, sub)
+		if err == nil || ctx.Err() != nil || errors.Is(err, ErrConnectionLost) {
+			// a lost connection renews the subscription again on the next one
+			continue
+		}
+
+		log.Printf("home assistant websocket: failed to renew subscription %v: %v", sub.cmd["type"], err)
+		c.mu.Lock()
+		if !sub.closed {
+			delete(c.subs, sub.id)
+			sub.closed = true
+			sub.err = fmt.Errorf("renewing subscription: %w", err)
+			close(sub.events)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// renew sends the subscription's command again and moves it to the new id
+func (c *WSClient) renew(ctx context.Context, sub *Subscription) error {
+	c.mu.Lock()
+	if sub.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	if c.conn == nil {
+		c.mu.Unlock()
+		return ErrConnectionLost
+	}
+	ch := make(chan wsResult, 1)
+	id, buf, err := c.prepareLocked(sub.cmd, ch)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	delete(c.subs, sub.id)
+	sub.id = id
+	c.subs[id] = sub
+	conn := c.conn
+	c.mu.Unlock()
+
+	if err := c.write(conn, id, buf); err != nil {
+		return err
+	}
+	_, err = c.wait(ctx, ch)
+	return err
+}
+
+func (c *WSClient) dispatch(msg wsMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch msg.Type {
+	case "result", "pong":
+		ch, ok := c.pending[msg.Id]
+		if !ok {
+			return
+		}
+		delete(c.pending, msg.Id)
+		if msg.Type == "result" && !msg.Success {
+			if msg.Error == nil {
+				msg.Error = &WSError{Code: "unknown_error"}
+			}
+			ch <- wsResult{err: msg.Error}
+			return
+		}
+		ch <- wsResult{result: msg.Result}
+	case "event":
+		sub, ok := c.subs[msg.Id]
+		if !ok {
+			return
+		}
+		var evt Event
+		if err := json.Unmarshal(msg.Event, &evt); err != nil {
+			log.Printf("home assistant websocket: bad event: %v", err)
+			return
+		}
+		select {
+		case sub.events <- evt:
+		default:
+		}
+	}
+}
+
+// Call sends a command and waits for its result. cmd is the command without its id.
+func (c *WSClient) Call(ctx context.Context, cmd map[string]any) (json.RawMessage, error) {
+	ch, err := c.send(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return c.wait(ctx, ch)
+}
+
+func (c *WSClient) wait(ctx context.Context, ch chan wsResult) (json.RawMessage, error) {
+	select {
+	case res := <-ch:
+		return res.result, res.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		for id, pending := range c.pending {
+			if pending == ch {
+				delete(c.pending, id)
+			}
+		}
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// send waits for a connection and sends cmd, returning the channel its result arrives on
+func (c *WSClient) send(ctx context.Context, cmd map[string]any) (chan wsResult, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan wsResult, 1)
+	id, buf, err := c.prepareLocked(cmd, ch)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return ch, c.write(conn, id, buf)
+}
+
+// connection waits until the client is connected and returns the connection with c.mu held
+func (c *WSClient) connection(ctx context.Context) (*wsConn, error) {
+	for {
+		c.mu.Lock()
+		if c.conn != nil {
+			return c.conn, nil
+		}
+		ready := c.ready
+		c.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// prepareLocked assigns the next id to cmd, encodes it and registers ch for its result, c.mu must be held
+func (c *WSClient) prepareLocked(cmd map[string]any, ch chan wsResult) (int, []byte, error) {
+	c.nextId++
+	msg := make(map[string]any, len(cmd)+1)
+	for k, v := range cmd {
+		msg[k] = v
+	}
+	msg["id"] = c.nextId
+
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	c.pending[c.nextId] = ch
+	return c.nextId, buf, nil
+}
+
+// write sends a prepared command without holding c.mu, so a slow write never stalls the reader.
+// A failed write drops the connection, Run reconnects.
+func (c *WSClient) write(conn *wsConn, id int, buf []byte) error {
+	if err := conn.WriteMessage(buf); err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		_ = conn.Close()
+		return err
+	}
+	return nil
+}
+
+// Ping sends a ping and waits for the pong
+func (c *WSClient) Ping(ctx context.Context) error {
+	_, err := c.Call(ctx, map[string]any{"type": "ping"})
+	return err
+}
+
+// CallService calls a service, optionally targeting entities, areas or devices.
+// target may be nil when the entities are part of data.
+func (c *WSClient) CallService(ctx context.Context, domain, service string, data any, target any) (json.RawMessage, error) {
+	cmd := map[string]any{
+		"type":    "call_service",
+		"domain":  domain,
+		"service": service,
+	}
+	if data != nil {
+		cmd["service_data"] = data
+	}
+	if target != nil {
+		cmd["target"] = target
+	}
+	return c.Call(ctx, cmd)
+}
+
+// GetStates retrieves all entity states
+func (c *WSClient) GetStates(ctx context.Context) ([]State, error) {
+	result, err := c.Call(ctx, map[string]any{"type": "get_states"})
+	if err != nil {
+		return nil, err
+	}
+
+	var states []State
+	err = json.Unmarshal(result, &states)
+	return states, err
+}
+
+// SubscribeEvents subscribes to events of the given type, or to every event when eventType is empty
+func (c *WSClient) SubscribeEvents(ctx context.Context, eventType string) (*Subscription, error) {
+	cmd := map[string]any{"type": "subscribe_events"}
+	if eventType != "" {
+		cmd["event_type"] = eventType
+	}
+	return c.subscribe(ctx, cmd)
+}
+
+// SubscribeTrigger subscribes to an automation trigger, e.g.
+// map[string]any{"platform": "state", "entity_id": "binary_sensor.door", "to": "on"}
+func (c *WSClient) SubscribeTrigger(ctx context.Context, trigger any) (*Subscription, error) {
+	return c.subscribe(ctx, map[string]any{"type": "subscribe_trigger", "trigger": trigger})
+}
+
+func (c *WSClient) subscribe(ctx context.Context, cmd map[string]any) (*Subscription, error) {
+	sub := &Subscription{client: c, cmd: cmd, events: make(chan Event, subscriptionBuffer)}
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan wsResult, 1)
+	id, buf, err := c.prepareLocked(cmd, ch)
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	sub.id = id
+	c.subs[id] = sub
+	c.mu.Unlock()
+
+	if err := c.write(conn, id, buf); err == nil {
+		_, err = c.wait(ctx, ch)
+	}
+	if err == nil || errors.Is(err, ErrConnectionLost) {
+		// serve renews every registered subscription on the next connection
+		return sub, nil
+	}
+	c.mu.Lock()
+	delete(c.subs, sub.id)
+	c.mu.Unlock()
+	return nil, err
+}
+
+// Events returns the channel events are delivered on. Events are dropped while the channel is full.
+// The channel is closed once Unsubscribe returns, or if Home Assistant refuses to renew the
+// subscription after a reconnect.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err returns why the subscription was closed, or nil while it is active or after Unsubscribe
+func (s *Subscription) Err() error {
+	s.client.mu.Lock()
+	defer s.client.mu.Unlock()
+	return s.err
+}
+
+// Unsubscribe cancels the subscription. Events keep being delivered until Home Assistant
+// confirms it or the connection is lost, then the Events channel is closed.
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	c := s.client
+	c.mu.Lock()
+	if s.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	id := s.id
+	connected := c.conn != nil
+	c.mu.Unlock()
+
+	var err error
+	if connected {
+		_, err = c.Call(ctx, map[string]any{"type": "unsubscribe_events", "subscription": id})
+		if errors.Is(err, ErrConnectionLost) {
+			err = nil
+		}
+	}
+
+	c.mu.Lock()
+	delete(c.subs, s.id)
+	close(s.events)
+	c.mu.Unlock()
+	return err
+}
+
+func readWSMessage(conn *wsConn) (wsMessage, error) {
+	var msg wsMessage
+	buf, err := conn.ReadMessage()
+	if err != nil {
+		return msg, err
+	}
+	err = json.Unmarshal(buf, &msg)
+	return msg, err
+}
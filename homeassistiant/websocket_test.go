@@ -0,0 +1,374 @@
+package homeassistiant
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testToken = "secret"
+
+// fakeHA is a Home Assistant WebSocket API stand-in. It authenticates clients and
+// hands every authenticated connection to the test through conns.
+type fakeHA struct {
+	t     *testing.T
+	srv   *httptest.Server
+	conns chan *fakeConn
+}
+
+type fakeConn struct {
+	t  *testing.T
+	ws *websocket.Conn
+}
+
+func newFakeHA(t *testing.T) *fakeHA {
+	t.Helper()
+	f := &fakeHA{t: t, conns: make(chan *fakeConn, 4)}
+	upgrader := websocket.Upgrader{}
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/websocket" {
+			http.NotFound(w, r)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conn := &fakeConn{t: t, ws: ws}
+		conn.send(map[string]any{"type": "auth_required", "ha_version": "2026.10.0"})
+		auth := conn.read()
+		if auth["type"] != "auth" || auth["access_token"] != testToken {
+			conn.send(map[string]any{"type": "auth_invalid", "message": "Invalid access token or password"})
+			_ = ws.Close()
+			return
+		}
+		conn.send(map[string]any{"type": "auth_ok", "ha_version": "2026.10.0"})
+		f.conns <- conn
+	}))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// accept waits for the next authenticated connection
+func (f *fakeHA) accept() *fakeConn {
+	f.t.Helper()
+	select {
+	case conn := <-f.conns:
+		return conn
+	case <-time.After(2 * time.Second):
+		f.t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+func (c *fakeConn) send(msg map[string]any) {
+	if err := c.ws.WriteJSON(msg); err != nil {
+		c.t.Errorf("fake HA write: %v", err)
+	}
+}
+
+func (c *fakeConn) read() map[string]any {
+	_ = c.ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg map[string]any
+	if err := c.ws.ReadJSON(&msg); err != nil {
+		c.t.Errorf("fake HA read: %v", err)
+	}
+	return msg
+}
+
+// result replies to the command with the given id
+func (c *fakeConn) result(id any, result any) {
+	c.send(map[string]any{"id": id, "type": "result", "success": true, "result": result})
+}
+
+// startClient runs a client against the fake until the test ends, returning Run's error on the channel
+func startClient(t *testing.T, f *fakeHA, token string) (*WSClient, <-chan error) {
+	t.Helper()
+	client := NewWSClient(f.srv.URL, token, WSOptions{
+		PingInterval:      time.Hour,
+		ReconnectDelay:    10 * time.Millisecond,
+		MaxReconnectDelay: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return client, done
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestWSAuthOk(t *testing.T) {
+	f := newFakeHA(t)
+	client, _ := startClient(t, f, testToken)
+	conn := f.accept()
+
+	go func() {
+		cmd := conn.read()
+		if cmd["type"] != "ping" {
+			t.Errorf("got %v, want ping", cmd["type"])
+		}
+		conn.send(map[string]any{"id": cmd["id"], "type": "pong"})
+	}()
+	if err := client.Ping(testContext(t)); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+}
+
+func TestWSAuthInvalid(t *testing.T) {
+	f := newFakeHA(t)
+	client := NewWSClient(f.srv.URL, "wrong", WSOptions{})
+
+	err := client.Run(testContext(t))
+	if !errors.Is(err, ErrAuthInvalid) {
+		t.Fatalf("Run = %v, want ErrAuthInvalid", err)
+	}
+}
+
+func TestWSCallService(t *testing.T) {
+	f := newFakeHA(t)
+	client, _ := startClient(t, f, testToken)
+	conn := f.accept()
+
+	go func() {
+		cmd := conn.read()
+		if cmd["type"] != "call_service" || cmd["domain"] != "light" || cmd["service"] != "turn_on" {
+			t.Errorf("unexpected command %v", cmd)
+		}
+		data, _ := cmd["service_data"].(map[string]any)
+		if data["brightness"] != float64(128) {
+			t.Errorf("service_data = %v", cmd["service_data"])
+		}
+		target, _ := cmd["target"].(map[string]any)
+		if ids, _ := target["entity_id"].([]any); len(ids) != 1 || ids[0] != "light.lamp" {
+			t.Errorf("target = %v", cmd["target"])
+		}
+		conn.result(cmd["id"], map[string]any{"context": map[string]any{"id": "abc"}})
+
+		cmd = conn.read()
+		conn.send(map[string]any{
+			"id": cmd["id"], "type": "result", "success": false,
+			"error": map[string]any{"code": "not_found", "message": "Service not found."},
+		})
+	}()
+
+	ctx := testContext(t)
+	result, err := client.CallService(ctx, "light", "turn_on", map[string]any{"brightness": 128}, Entities("light.lamp"))
+	if err != nil {
+		t.Fatalf("call_service: %v", err)
+	}
+	if string(result) != `{"context":{"id":"abc"}}` {
+		t.Errorf("result = %s", result)
+	}
+
+	_, err = client.CallService(ctx, "light", "explode", nil, nil)
+	var wsErr *WSError
+	if !errors.As(err, &wsErr) || wsErr.Code != "not_found" {
+		t.Fatalf("err = %v, want not_found WSError", err)
+	}
+}
+
+// subscribeEvents subscribes through client while conn acknowledges, returning the subscription id
+func subscribeEvents(t *testing.T, client *WSClient, conn *fakeConn, eventType string) (*Subscription, any) {
+	t.Helper()
+	ids := make(chan any, 1)
+	go func() {
+		cmd := conn.read()
+		if cmd["type"] != "subscribe_events" || cmd["event_type"] != eventType {
+			t.Errorf("unexpected command %v", cmd)
+		}
+		conn.result(cmd["id"], nil)
+		ids <- cmd["id"]
+	}()
+
+	sub, err := client.SubscribeEvents(testContext(t), eventType)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	return sub, <-ids
+}
+
+func nextWSEvent(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case evt, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("subscription closed: %v", sub.Err())
+		}
+		return evt
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+		return Event{}
+	}
+}
+
+func TestWSSubscribeEvents(t *testing.T) {
+	f := newFakeHA(t)
+	client, _ := startClient(t, f, testToken)
+	conn := f.accept()
+
+	sub, id := subscribeEvents(t, client, conn, "godice_roll")
+	conn.send(map[string]any{"id": id, "type": "event", "event": map[string]any{
+		"event_type": "godice_roll",
+		"data":       map[string]any{"face": 20},
+		"origin":     "LOCAL",
+	}})
+
+	evt := nextWSEvent(t, sub)
+	if evt.EventType != "godice_roll" || string(evt.Data) != `{"face":20}` {
+		t.Errorf("event = %+v", evt)
+	}
+}
+
+func TestWSReconnectResubscribes(t *testing.T) {
+	f := newFakeHA(t)
+	client, _ := startClient(t, f, testToken)
+	conn := f.accept()
+
+	sub, oldId := subscribeEvents(t, client, conn, "state_changed")
+	_ = conn.ws.Close()
+
+	conn = f.accept()
+	cmd := conn.read()
+	if cmd["type"] != "subscribe_events" || cmd["event_type"] != "state_changed" {
+		t.Fatalf("expected the subscription to be renewed, got %v", cmd)
+	}
+	if cmd["id"] == oldId {
+		t.Errorf("renewed subscription reused id %v", oldId)
+	}
+	conn.result(cmd["id"], nil)
+	conn.send(map[string]any{"id": cmd["id"], "type": "event", "event": map[string]any{"event_type": "state_changed"}})
+
+	if evt := nextWSEvent(t, sub); evt.EventType != "state_changed" {
+		t.Errorf("event = %+v", evt)
+	}
+	if err := sub.Err(); err != nil {
+		t.Errorf("Err = %v", err)
+	}
+}
+
+func TestWSResubscribeFailureClosesSubscription(t *testing.T) {
+	f := newFakeHA(t)
+	client, _ := startClient(t, f, testToken)
+	conn := f.accept()
+
+	sub, _ := subscribeEvents(t, client, conn, "state_changed")
+	_ = conn.ws.Close()
+
+	conn = f.accept()
+	cmd := conn.read()
+	conn.send(map[string]any{
+		"id": cmd["id"], "type": "result", "success": false,
+		"error": map[string]any{"code": "unauthorized", "message": "Unauthorized."},
+	})
+
+	select {
+	case _, ok := <-sub.Events():
+		if ok {
+			t.Fatal("expected the events channel to be closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription was not closed")
+	}
+	var wsErr *WSError
+	if !errors.As(sub.Err(), &wsErr) || wsErr.Code != "unauthorized" {
+		t.Errorf("Err = %v, want the unauthorized WSError", sub.Err())
+	}
+}
+
+func TestWSUnsubscribeClosesEvents(t *testing.T) {
+	f := newFakeHA(t)
+	client, _ := startClient(t, f, testToken)
+	conn := f.accept()
+
+	sub, id := subscribeEvents(t, client, conn, "state_changed")
+	done := make(chan error, 1)
+	go func() {
+		done <- sub.Unsubscribe(testContext(t))
+	}()
+
+	cmd := conn.read()
+	if cmd["type"] != "unsubscribe_events" || cmd["subscription"] != id {
+		t.Fatalf("unexpected command %v", cmd)
+	}
+	// events sent before the unsubscribe is confirmed are still delivered
+	conn.send(map[string]any{"id": id, "type": "event", "event": map[string]any{"event_type": "state_changed"}})
+	conn.result(cmd["id"], nil)
+
+	var received int
+	for range sub.Events() {
+		received++
+	}
+	if received != 1 {
+		t.Errorf("received %d events, want 1", received)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Unsubscribe: %v", err)
+	}
+	if err := sub.Unsubscribe(testContext(t)); err != nil {
+		t.Errorf("second Unsubscribe: %v", err)
+	}
+}
+
+func TestWSUnsubscribeWhileDisconnected(t *testing.T) {
+	f := newFakeHA(t)
+	client, _ := startClient(t, f, testToken)
+	conn := f.accept()
+
+	sub, _ := subscribeEvents(t, client, conn, "state_changed")
+	_ = conn.ws.Close()
+	if err := sub.Unsubscribe(testContext(t)); err != nil {
+		t.Errorf("Unsubscribe: %v", err)
+	}
+
+	select {
+	case _, ok := <-sub.Events():
+		if ok {
+			t.Fatal("expected the events channel to be closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription was not closed")
+	}
+}
+
+func TestWSStalledWriteDoesNotBlockResults(t *testing.T) {
+	f := newFakeHA(t)
+	client, _ := startClient(t, f, testToken)
+	conn := f.accept()
+
+	// hold the write lock as a stalled write would, results must still be delivered
+	wconn, err := client.connection(testContext(t))
+	if err != nil {
+		t.Fatalf("connection: %v", err)
+	}
+	client.mu.Unlock()
+	wconn.wmu.Lock()
+
+	ch := make(chan wsResult, 1)
+	client.mu.Lock()
+	client.pending[99] = ch
+	client.mu.Unlock()
+	conn.result(99, "ok")
+
+	select {
+	case res := <-ch:
+		if string(res.result) != `"ok"` {
+			t.Errorf("result = %s", res.result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reader blocked behind the writer")
+	}
+	wconn.wmu.Unlock()
+}
@@ -0,0 +1,70 @@
+package homeassistiant
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// wsMaxMessageSize bounds a single message, get_states on a large install can be several megabytes
+const wsMaxMessageSize = 64 << 20
+
+// wsWriteTimeout bounds a single write so a stalled connection is dropped instead of blocking its callers
+const wsWriteTimeout = 10 * time.Second
+
+// wsConn is a WebSocket connection exchanging text messages. Writes are serialized,
+// reads must only be made from one goroutine.
+type wsConn struct {
+	ws  *websocket.Conn
+	wmu sync.Mutex
+}
+
+// dialWebSocket opens a WebSocket connection to a ws, wss, http or https URL
+func dialWebSocket(ctx context.Context, rawURL string) (*wsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("unsupported websocket scheme %q", u.Scheme)
+	}
+
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	ws.SetReadLimit(wsMaxMessageSize)
+	return &wsConn{ws: ws}, nil
+}
+
+// WriteMessage sends a text message, failing if it cannot be written within wsWriteTimeout
+func (c *wsConn) WriteMessage(msg []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_ = c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.ws.WriteMessage(websocket.TextMessage, msg)
+}
+
+// ReadMessage returns the next data message
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	_, msg, err := c.ws.ReadMessage()
+	return msg, err
+}
+
+// SetReadDeadline bounds the following reads, the zero time removes the deadline
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *wsConn) Close() error {
+	return c.ws.Close()
+}
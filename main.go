@@ -1,130 +1,262 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"godice/config"
 	ha "godice/homeassistiant"
+	"godice/mqtt"
 	pix "godice/pixel"
+	"godice/rules"
 	cn "golang.org/x/image/colornames"
-	"image/color"
+	"log"
+	"log/slog"
+	"os"
+	"sync"
 	"time"
 	"tinygo.org/x/bluetooth"
 )
 
-var conf, confErr = config.LoadConfig("config.yaml")
+var conf *config.AppConfig
 
 func main() {
+	configFile := flag.String("config", "config.yaml", "path to the config file")
+	flag.Usage = usage
+	flag.Parse()
 
-	if confErr != nil {
-		panic(confErr)
+	if err := runCLI(*configFile, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	MultiDieRunner()
-	//SingleDiePixelRunner(conf.HAConfig.URL, conf.HAConfig.Token)
+	//SingleDiePixelRunner(ctx, conf.HAConfig.URL, conf.HAConfig.Token)
 }
 
-func MultiDieRunner() {
+// MultiDieRunner reacts to throws until ctx is done, then waits for the running
+// actions to restore the lights and disconnects the dice
+func MultiDieRunner(ctx context.Context) {
 	adapter := bluetooth.DefaultAdapter
 	_ = adapter.Enable()
-	dieChan := make(chan *pix.Die)
-	go pix.WatchForDice(adapter, dieChan)
-
-	allDie := make(map[uint32]*pix.Die)
-	go func(dch chan *pix.Die) {
-		for {
-			newDie := <-dch
-			allDie[newDie.PixelId] = newDie
-			//fmt.Printf("new die %d on face %d\n", newDie.PixelId, newDie.CurrentFaceValue)
-		}
-	}(dieChan)
+	slog.SetLogLoggerLevel(logLevel(conf.Logging.Level))
+	manager := pix.NewManager(adapter, managerOptions())
+	aggregator := pix.NewAggregator(pix.AggregatorOptions{
+		Window:  conf.Scan.ThrowWindow,
+		Timeout: conf.Scan.ThrowTimeout,
+	})
+	publisher := newPublisher(ctx, manager.Registry())
+	go watchManagedDice(manager, aggregator, publisher)
+	go func() {
+		_ = aggregator.Run(ctx)
+	}()
+	scanning := make(chan struct{})
+	go func() {
+		_ = manager.Run(ctx)
+		close(scanning)
+	}()
 
-	multipleDiceWatcher(&allDie, ha.NewClient(conf.HAConfig.URL, conf.HAConfig.Token))
+	multipleDiceWatcher(ctx, aggregator, newHAClient())
+	<-scanning
+	for _, die := range manager.List() {
+		_ = die.Disconnect()
+	}
 }
 
-func getUpdatedDie(dice *map[uint32]*pix.Die, asOf *map[uint32]time.Time) (updated map[uint32]*pix.Die) {
-	updated = make(map[uint32]*pix.Die)
-	for id, die := range *dice {
-		//fmt.Printf("LastRolled %s\n", die.LastRolled)
-		val, exists := (*asOf)[id]
-		if !exists {
-			(*asOf)[id] = time.Now()
-		}
-		if exists && die.LastRolled.After(val) {
-			updated[id] = die
-		}
+// newHAClient returns nil when the Home Assistant output is disabled
+func newHAClient() *ha.HAClient {
+	if !conf.Outputs.HomeAssistant {
+		return nil
 	}
-	return updated
+	return ha.NewClient(conf.HAConfig.URL, conf.HAConfig.Token)
 }
 
-func lastUpdate(dice *map[uint32]*pix.Die) (latest time.Time) {
-	for _, die := range *dice {
-		if die.LastRolled.After(latest) {
-			latest = die.LastRolled
-		}
+// dieName is the configured nickname of a die, falling back to the name it reports
+func dieName(pixelId uint32, name string) string {
+	if nickname, ok := conf.Dice.Nicknames[pixelId]; ok {
+		return nickname
+	}
+	if name != "" {
+		return name
 	}
-	return latest
+	return formatId(pixelId)
 }
 
-func rollTotal(dice *map[uint32]*pix.Die) (total int) {
-	for _, die := range *dice {
-		total += int(die.CurrentFaceValue)
+func logLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
 	}
-	return total
+	return slog.LevelInfo
 }
 
-func multipleDiceWatcher(dice *map[uint32]*pix.Die, haClient *ha.HAClient) {
-	target := conf.HAConfig.LightEntities[0]
+// watchManagedDice adds every die the manager discovers to the aggregator
+func watchManagedDice(manager *pix.Manager, aggregator *pix.Aggregator, publisher *mqtt.Publisher) {
+	events, _ := manager.Events(8)
+	for evt := range events {
+		switch evt.Type {
+		case pix.ManagerEventDiscovered:
+			aggregator.Watch(evt.Die)
+			if publisher != nil {
+				publisher.Watch(evt.Die)
+			}
+		case pix.ManagerEventLost:
+			aggregator.Unwatch(evt.Die)
+			if publisher != nil {
+				publisher.Unwatch(evt.Die)
+			}
+		}
+	}
+}
 
-	haClient.LightOff(target)
-	time.Sleep(500 * time.Millisecond)
-	haClient.LightTemperature(target, 2500)
+// newPublisher connects to the MQTT broker and publishes the registry's dice, it returns nil when MQTT is disabled
+func newPublisher(ctx context.Context, registry *pix.Registry) *mqtt.Publisher {
+	mqttConf := conf.Outputs.MQTT
+	if !mqttConf.Enabled {
+		return nil
+	}
 
-	lastRollMap := make(map[uint32]time.Time)
-	//lastRollChecked := lastUpdate(dice)
+	var publisher *mqtt.Publisher
+	client := mqtt.NewClient(mqtt.Options{
+		Broker:   mqttConf.Broker,
+		ClientId: mqttConf.ClientId,
+		Username: mqttConf.Username,
+		Password: mqttConf.Password,
+		Will:     mqtt.Will(mqttConf.TopicPrefix),
+		Backoff: pix.Backoff{
+			Initial:    conf.Reconnect.InitialBackoff,
+			Max:        conf.Reconnect.MaxBackoff,
+			Multiplier: conf.Reconnect.Multiplier,
+		},
+		OnConnect: func() {
+			publisher.PublishAll()
+		},
+	})
+	publisher = mqtt.NewPublisher(client, registry, mqtt.PublisherOptions{
+		DiscoveryPrefix: mqttConf.DiscoveryPrefix,
+		TopicPrefix:     mqttConf.TopicPrefix,
+		Name: func(state pix.DieState) string {
+			return dieName(state.PixelId, state.Name)
+		},
+	})
+	go func() {
+		if err := client.Run(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("mqtt output stopped: %v", err)
+		}
+	}()
+	return publisher
+}
+
+// multipleDiceWatcher runs the rules for every throw until ctx is done and the started actions have finished
+func multipleDiceWatcher(ctx context.Context, aggregator *pix.Aggregator, haClient *ha.HAClient) {
+	engine, executor := newRules(haClient)
+
+	var playing sync.WaitGroup
+	defer playing.Wait()
+	throws, unsubscribe := aggregator.Throws(4)
+	defer unsubscribe()
 	for {
-		updatedDice := getUpdatedDie(dice, &lastRollMap)
-		if len(updatedDice) == 0 {
-			//fmt.Printf("no dice updated as of %s\n", lastRollChecked)
-			time.Sleep(2 * time.Second)
-			continue
+		var throw pix.Throw
+		select {
+		case <-ctx.Done():
+			return
+		case throw = <-throws:
 		}
 
-		rollTotal := rollTotal(&updatedDice)
-		fmt.Printf("Roll Total: %d\n", rollTotal)
-		if rollTotal == 20 {
-			haClient.LightCycleColorsEz(target, []color.RGBA{
-				cn.Red,
-				cn.Orange,
-				cn.Yellow,
-				cn.Green,
-				cn.Blue,
-				cn.Indigo,
-				cn.Purple,
-			})
-		} else if rollTotal >= 15 {
-			haClient.LightColor(target, cn.Royalblue)
-		} else if rollTotal >= 10 {
-			haClient.LightColor(target, cn.Green)
-		} else if rollTotal >= 5 {
-			haClient.LightColor(target, cn.Orange)
-		} else if rollTotal > 1 {
-			haClient.LightColor(target, cn.Red)
-		} else {
-			haClient.LightCycleColors(target, []color.RGBA{cn.Red, cn.Red, cn.Red}, 500*time.Millisecond, true)
+		for _, d := range throw.Dice {
+			if d.Crooked {
+				fmt.Printf("%s is crooked, reroll it\n", dieName(d.PixelId, d.Name))
+			}
+		}
+		if len(throw.Counted()) == 0 && !throw.Crooked() {
+			continue
 		}
 
-		for id := range updatedDice {
-			lastRollMap[id] = time.Now()
+		fmt.Printf("Roll Total: %d\n", throw.Total())
+		go fireRollEvents(ctx, haClient, throw)
+		if executor == nil {
+			continue
 		}
-		time.Sleep(500 * time.Millisecond)
-		haClient.LightTemperature(target, 2500)
-		time.Sleep(5 * time.Second)
+		playback := executor.Start(ctx, throw, engine.Actions(throw))
+		playing.Add(1)
+		go func() {
+			defer playing.Done()
+			reportPlayback(playback)
+		}()
 	}
+}
 
+func newRules(haClient *ha.HAClient) (*rules.Engine, *rules.Executor) {
+	engine, err := rules.NewEngine(conf.Rules)
+	must("load rules", err)
+	if haClient == nil {
+		return engine, nil
+	}
+	restore := conf.HAConfig.Restore != "none"
+	return engine, &rules.Executor{
+		HA:           haClient,
+		Entities:     conf.HAConfig.LightEntities,
+		Effects:      ha.NewEffectRunner(haClient, ha.EffectOptions{Restore: conf.HAConfig.Restore}),
+		Restore:      restore,
+		RestoreAfter: conf.HAConfig.RestoreAfter,
+	}
+}
+
+// reportPlayback logs the error of a rule's actions unless they were interrupted by a newer throw or shutdown
+func reportPlayback(playback *ha.Playback) {
+	if err := playback.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("rule actions failed: %v", err)
+	}
+}
+
+// rollEventData is the payload of the roll event fired for each settled die
+type rollEventData struct {
+	PixelId   uint32 `json:"pixel_id"`
+	Name      string `json:"name"`
+	Face      int    `json:"face"`
+	Total     int    `json:"total"`
+	DieType   string `json:"die_type"`
+	RollState string `json:"roll_state"`
+}
+
+// fireRollEvents fires the configured Home Assistant roll event for every settled die in the throw,
+// giving up when ctx is done
+func fireRollEvents(ctx context.Context, haClient *ha.HAClient, throw pix.Throw) {
+	if haClient == nil || conf.Outputs.RollEvent == "" {
+		return
+	}
+	total := throw.Total()
+	for _, d := range throw.Dice {
+		if !d.Settled {
+			continue
+		}
+		_, err := haClient.FireEvent(ctx, conf.Outputs.RollEvent, rollEventData{
+			PixelId:   d.PixelId,
+			Name:      dieName(d.PixelId, d.Name),
+			Face:      d.Value,
+			Total:     total,
+			DieType:   d.DieType.String(),
+			RollState: pix.RollStateName(d.RollState),
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("firing %s for die %d failed: %v", conf.Outputs.RollEvent, d.PixelId, err)
+		}
+	}
 }
 
-func SingleDiePixelRunner(haUrl string, haToken string) {
+// SingleDiePixelRunner connects the first die found and runs the rules for its rolls until ctx is done
+func SingleDiePixelRunner(ctx context.Context, haUrl string, haToken string) {
 	adapter := bluetooth.DefaultAdapter
-	haClient := ha.NewClient(haUrl, haToken)
+	var haClient *ha.HAClient
+	if conf.Outputs.HomeAssistant {
+		haClient = ha.NewClient(haUrl, haToken)
+	}
 
 	die := &pix.Die{}
 	must("enable BLE stack", adapter.Enable())
@@ -140,50 +272,41 @@ func SingleDiePixelRunner(haUrl string, haToken string) {
 		Fade:      128,
 		LoopCount: 0,
 	}))
-	go singleDieWatcher(die, haClient)
-	select {}
+	singleDieWatcher(ctx, die, haClient)
+	_ = die.Disconnect()
 }
 
-func singleDieWatcher(die *pix.Die, haClient *ha.HAClient) {
-	target := conf.HAConfig.LightEntities[0]
-
-	haClient.LightOff(target)
-	time.Sleep(500 * time.Millisecond)
-	haClient.LightTemperature(target, 2500)
+func singleDieWatcher(ctx context.Context, die *pix.Die, haClient *ha.HAClient) {
+	engine, executor := newRules(haClient)
 
-	lastUpdated := die.LastRolled
+	var playing sync.WaitGroup
+	defer playing.Wait()
+	events, unsubscribe := die.Subscribe(8)
+	defer unsubscribe()
 	for {
-		if die.LastRolled.After(lastUpdated) {
-			fmt.Printf("Roll: %d\n", die.CurrentFaceValue)
-			if die.CurrentFaceValue == 20 {
-				haClient.LightCycleColorsEz(target, []color.RGBA{
-					cn.Red,
-					cn.Orange,
-					cn.Yellow,
-					cn.Green,
-					cn.Blue,
-					cn.Indigo,
-					cn.Purple,
-				})
-			} else if die.CurrentFaceValue >= 15 {
-				haClient.LightColor(target, cn.Royalblue)
-			} else if die.CurrentFaceValue >= 10 {
-				haClient.LightColor(target, cn.Green)
-			} else if die.CurrentFaceValue >= 5 {
-				haClient.LightColor(target, cn.Orange)
-			} else if die.CurrentFaceValue > 1 {
-				haClient.LightColor(target, cn.Red)
-			} else {
-				haClient.LightCycleColors(target, []color.RGBA{cn.Red, cn.Red, cn.Red}, 500*time.Millisecond, true)
-			}
-			lastUpdated = die.LastRolled
+		var evt pix.Event
+		select {
+		case <-ctx.Done():
+			return
+		case evt = <-events:
+		}
+		if evt.Type != pix.EventRolled {
+			continue
 		}
-		time.Sleep(500 * time.Millisecond)
-		haClient.LightTemperature(target, 2500)
-		time.Sleep(5 * time.Second)
 
+		fmt.Printf("%s rolled %d\n", dieName(evt.PixelId, evt.Name), evt.CurrentFaceValue)
+		throw := pix.SingleThrow(evt)
+		go fireRollEvents(ctx, haClient, throw)
+		if executor == nil {
+			continue
+		}
+		playback := executor.Start(ctx, throw, engine.Actions(throw))
+		playing.Add(1)
+		go func() {
+			defer playing.Done()
+			reportPlayback(playback)
+		}()
 	}
-
 }
 
 func must(action string, err error) {
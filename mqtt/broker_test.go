@@ -0,0 +1,233 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBroker is a minimal MQTT 3.1.1 broker for the tests. It records what clients send,
+// answers CONNECT with connackCode and routes publishes to matching exact-topic subscribers.
+type testBroker struct {
+	t           *testing.T
+	ln          net.Listener
+	connackCode byte
+
+	mu        sync.Mutex
+	conns     []net.Conn
+	connects  int
+	pings     int
+	retained  map[string][]byte
+	published []Message
+	subs      map[net.Conn][]string
+	changed   chan struct{}
+}
+
+func newTestBroker(t *testing.T, connackCode byte) *testBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	b := &testBroker{
+		t:           t,
+		ln:          ln,
+		connackCode: connackCode,
+		retained:    make(map[string][]byte),
+		subs:        make(map[net.Conn][]string),
+		changed:     make(chan struct{}, 1),
+	}
+	go b.accept()
+	t.Cleanup(func() {
+		_ = ln.Close()
+		b.drop()
+	})
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func (b *testBroker) accept() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns = append(b.conns, conn)
+		b.mu.Unlock()
+		go b.serve(conn)
+	}
+}
+
+// drop closes every client connection, as a broker restart would
+func (b *testBroker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, conn := range b.conns {
+		_ = conn.Close()
+	}
+	b.conns = nil
+	b.subs = make(map[net.Conn][]string)
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		header, body, err := readTestPacket(r)
+		if err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			b.update(func() { b.connects++ })
+			_, _ = conn.Write([]byte{0x20, 2, 0, b.connackCode})
+			if b.connackCode != 0 {
+				return
+			}
+		case 3: // PUBLISH
+			topicLen := int(binary.BigEndian.Uint16(body))
+			msg := Message{
+				Topic:   string(body[2 : 2+topicLen]),
+				Payload: append([]byte(nil), body[2+topicLen:]...),
+				Retain:  header&0x01 != 0,
+			}
+			b.update(func() {
+				b.published = append(b.published, msg)
+				if msg.Retain {
+					b.retained[msg.Topic] = msg.Payload
+				}
+			})
+			b.route(msg)
+		case 8: // SUBSCRIBE
+			packetId := body[:2]
+			topicLen := int(binary.BigEndian.Uint16(body[2:]))
+			topic := string(body[4 : 4+topicLen])
+			b.update(func() { b.subs[conn] = append(b.subs[conn], topic) })
+			_, _ = conn.Write([]byte{0x90, 3, packetId[0], packetId[1], 0})
+		case 12: // PINGREQ
+			b.update(func() { b.pings++ })
+			_, _ = conn.Write([]byte{0xD0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+// route delivers msg to the clients subscribed to its topic
+func (b *testBroker) route(msg Message) {
+	b.mu.Lock()
+	var targets []net.Conn
+	for conn, topics := range b.subs {
+		for _, topic := range topics {
+			if topic == msg.Topic {
+				targets = append(targets, conn)
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	for _, conn := range targets {
+		_, _ = conn.Write(testPublishPacket(msg))
+	}
+}
+
+// publish sends msg from the broker to its subscribers, as another client would
+func (b *testBroker) publish(msg Message) {
+	b.route(msg)
+}
+
+func (b *testBroker) update(f func()) {
+	b.mu.Lock()
+	f()
+	b.mu.Unlock()
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+// waitFor polls cond with the broker locked until it holds
+func (b *testBroker) waitFor(what string, cond func() bool) {
+	b.t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		b.mu.Lock()
+		ok := cond()
+		b.mu.Unlock()
+		if ok {
+			return
+		}
+		select {
+		case <-b.changed:
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			b.t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func (b *testBroker) subscribed(topic string) bool {
+	for _, topics := range b.subs {
+		for _, t := range topics {
+			if t == topic {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func readTestPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7F) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func testPublishPacket(msg Message) []byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(msg.Topic)))
+	body = append(body, msg.Topic...)
+	body = append(body, msg.Payload...)
+
+	header := byte(0x30)
+	if msg.Retain {
+		header |= 0x01
+	}
+	packet := []byte{header}
+	for length := len(body); ; {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
@@ -0,0 +1,196 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"godice/pixel"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrNotConnected is returned when publishing while the client is not connected to the broker
+var ErrNotConnected = errors.New("not connected to the mqtt broker")
+
+// ErrConnectionRefused is returned by Run when the broker rejects the client's CONNECT
+var ErrConnectionRefused = errors.New("mqtt connection refused")
+
+// Message is an MQTT application message, always sent at QoS 0
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Options configure a Client, zero values use the defaults
+type Options struct {
+	// Broker is the broker URL, e.g. tcp://localhost:1883 or ssl://broker:8883
+	Broker   string
+	ClientId string
+	Username string
+	Password string
+	// KeepAlive is the keepalive interval sent to the broker, default 30s
+	KeepAlive time.Duration
+	// Will is published by the broker when the client disappears
+	Will *Message
+	// OnConnect is called after every successful connect, e.g. to republish retained state
+	OnConnect func()
+	// Backoff paces the attempts to reach the broker, default pixel.DefaultBackoff.
+	// Reconnects after a drop wait at most Backoff.Max.
+	Backoff pixel.Backoff
+}
+
+// Client is an MQTT 3.1.1 client. Run keeps it connected, renewing subscriptions after each reconnect.
+type Client struct {
+	opts   Options
+	client paho.Client
+
+	mu       sync.Mutex
+	handlers map[string]func(Message)
+}
+
+// subscribeTimeout bounds the wait for a SUBACK
+const subscribeTimeout = 10 * time.Second
+
+// NewClient creates a client for the broker in opts
+func NewClient(opts Options) *Client {
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = 30 * time.Second
+	}
+	if opts.Backoff == (pixel.Backoff{}) {
+		opts.Backoff = pixel.DefaultBackoff
+	}
+
+	c := &Client{opts: opts, handlers: make(map[string]func(Message))}
+	clientOpts := paho.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientId).
+		SetProtocolVersion(4).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetKeepAlive(opts.KeepAlive).
+		SetPingTimeout(10 * time.Second).
+		SetConnectTimeout(10 * time.Second).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(opts.Backoff.Max).
+		SetOrderMatters(false).
+		SetOnConnectHandler(func(paho.Client) { c.onConnect() }).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("mqtt: connection lost: %v, reconnecting", err)
+		})
+	if opts.Will != nil {
+		clientOpts.SetBinaryWill(opts.Will.Topic, opts.Will.Payload, 0, opts.Will.Retain)
+	}
+	c.client = paho.NewClient(clientOpts)
+	return c
+}
+
+// Run connects to the broker and stays connected until ctx is done, retrying with backoff
+// until the broker is first reached. It returns ErrConnectionRefused without retrying if
+// the broker rejects the credentials.
+func (c *Client) Run(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		err := c.connect(ctx)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrConnectionRefused) {
+			return err
+		}
+
+		delay := c.opts.Backoff.Delay(attempt)
+		log.Printf("mqtt: %v, reconnecting in %s", err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	<-ctx.Done()
+	c.client.Disconnect(250)
+	return ctx.Err()
+}
+
+func (c *Client) connect(ctx context.Context) error {
+	token := c.client.Connect()
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		c.client.Disconnect(0)
+		return ctx.Err()
+	}
+	if err := token.Error(); err != nil {
+		if connect, ok := token.(*paho.ConnectToken); ok {
+			// return codes 1 to 5 are the broker refusing the CONNECT, retrying will not help
+			if code := connect.ReturnCode(); code >= 1 && code <= 5 {
+				return fmt.Errorf("%w: %v", ErrConnectionRefused, err)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// onConnect renews the subscriptions, the session is clean after every connect
+func (c *Client) onConnect() {
+	c.mu.Lock()
+	topics := make([]string, 0, len(c.handlers))
+	for topic := range c.handlers {
+		topics = append(topics, topic)
+	}
+	c.mu.Unlock()
+
+	for _, topic := range topics {
+		if err := c.subscribe(topic); err != nil {
+			log.Printf("mqtt: failed to subscribe to %s: %v", topic, err)
+		}
+	}
+	if c.opts.OnConnect != nil {
+		c.opts.OnConnect()
+	}
+}
+
+// Publish sends msg at QoS 0, returning ErrNotConnected while the broker is unreachable
+func (c *Client) Publish(msg Message) error {
+	if !c.client.IsConnectionOpen() {
+		return ErrNotConnected
+	}
+	token := c.client.Publish(msg.Topic, 0, msg.Retain, msg.Payload)
+	token.Wait()
+	return token.Error()
+}
+
+// Subscribe registers handler for messages on topic.
+// The subscription is sent now if connected and renewed on every reconnect.
+func (c *Client) Subscribe(topic string, handler func(Message)) error {
+	c.mu.Lock()
+	c.handlers[topic] = handler
+	c.mu.Unlock()
+
+	if !c.client.IsConnectionOpen() {
+		return nil
+	}
+	return c.subscribe(topic)
+}
+
+func (c *Client) subscribe(topic string) error {
+	token := c.client.Subscribe(topic, 0, func(_ paho.Client, msg paho.Message) {
+		c.mu.Lock()
+		handler := c.handlers[topic]
+		c.mu.Unlock()
+		if handler != nil {
+			handler(Message{Topic: msg.Topic(), Payload: msg.Payload(), Retain: msg.Retained()})
+		}
+	})
+	if !token.WaitTimeout(subscribeTimeout) {
+		return fmt.Errorf("no SUBACK for %s within %s", topic, subscribeTimeout)
+	}
+	return token.Error()
+}
@@ -0,0 +1,108 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"godice/pixel"
+	"testing"
+	"time"
+)
+
+// startTestClient runs a client against the broker until the test ends
+func startTestClient(t *testing.T, b *testBroker, opts Options) (*Client, <-chan error) {
+	t.Helper()
+	opts.Broker = b.url()
+	if opts.ClientId == "" {
+		opts.ClientId = "godice-test"
+	}
+	opts.Backoff = pixel.Backoff{Initial: 10 * time.Millisecond, Max: 100 * time.Millisecond, Multiplier: 2}
+	client := NewClient(opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		done <- client.Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return client, done
+}
+
+func TestConnectRefused(t *testing.T) {
+	b := newTestBroker(t, 4) // bad user name or password
+	_, done := startTestClient(t, b, Options{Username: "godice", Password: "wrong"})
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrConnectionRefused) {
+			t.Fatalf("Run = %v, want ErrConnectionRefused", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run kept retrying a refused connection")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.connects != 1 {
+		t.Errorf("connects = %d, want 1", b.connects)
+	}
+}
+
+func TestPublishRetainedAndWill(t *testing.T) {
+	b := newTestBroker(t, 0)
+	connected := make(chan struct{}, 1)
+	client, _ := startTestClient(t, b, Options{
+		Will:      Will("godice"),
+		OnConnect: func() { connected <- struct{}{} },
+	})
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnConnect was not called")
+	}
+	if err := client.Publish(Message{Topic: "godice/status", Payload: []byte("online"), Retain: true}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	b.waitFor("the retained status", func() bool { return string(b.retained["godice/status"]) == "online" })
+}
+
+func TestPublishNotConnected(t *testing.T) {
+	client := NewClient(Options{Broker: "tcp://127.0.0.1:1"})
+	if err := client.Publish(Message{Topic: "godice/status"}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Publish = %v, want ErrNotConnected", err)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	b := newTestBroker(t, 0)
+	startTestClient(t, b, Options{KeepAlive: time.Second})
+
+	b.waitFor("a PINGREQ", func() bool { return b.pings > 0 })
+}
+
+func TestReconnectResubscribes(t *testing.T) {
+	b := newTestBroker(t, 0)
+	received := make(chan Message, 4)
+	client, _ := startTestClient(t, b, Options{})
+	if err := client.Subscribe("homeassistant/status", func(msg Message) { received <- msg }); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	b.waitFor("the subscription", func() bool { return b.subscribed("homeassistant/status") })
+
+	b.drop()
+	b.waitFor("the reconnect", func() bool { return b.connects >= 2 && b.subscribed("homeassistant/status") })
+
+	b.publish(Message{Topic: "homeassistant/status", Payload: []byte("online")})
+	select {
+	case msg := <-received:
+		if string(msg.Payload) != "online" {
+			t.Errorf("payload = %q", msg.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message after the reconnect")
+	}
+}
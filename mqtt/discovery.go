@@ -0,0 +1,290 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"godice/pixel"
+	"log"
+	"sync"
+	"time"
+)
+
+// PublisherOptions configure a Publisher, zero values use the defaults
+type PublisherOptions struct {
+	// DiscoveryPrefix is Home Assistant's discovery prefix, default "homeassistant"
+	DiscoveryPrefix string
+	// TopicPrefix is the root of the state topics, default "godice"
+	TopicPrefix string
+	// Name returns the display name of a die, default the name it reports
+	Name func(state pixel.DieState) string
+}
+
+// Publisher exposes dice as Home Assistant devices through MQTT discovery.
+// Each die gets last face and battery sensors, charging, rolling and connected
+// binary sensors, a roll event entity and a roll device trigger.
+type Publisher struct {
+	client   *Client
+	registry *pixel.Registry
+	opts     PublisherOptions
+
+	mu      sync.Mutex
+	watched map[*pixel.Die]func()
+}
+
+// dieStatePayload is the JSON published to a die's state topic
+type dieStatePayload struct {
+	Face      int    `json:"face"`
+	FaceIndex uint8  `json:"face_index"`
+	RollState string `json:"roll_state"`
+	Rolling   bool   `json:"rolling"`
+	Battery   uint8  `json:"battery"`
+	Charging  bool   `json:"charging"`
+	Rssi      int8   `json:"rssi"`
+}
+
+// rollPayload is the JSON published to a die's roll topic for every settled roll
+type rollPayload struct {
+	EventType string `json:"event_type"`
+	PixelId   uint32 `json:"pixel_id"`
+	Name      string `json:"name"`
+	DieType   string `json:"die_type"`
+	Face      int    `json:"face"`
+	Time      string `json:"time"`
+}
+
+// NewPublisher creates a publisher for the dice in registry. It republishes
+// every die's discovery config whenever Home Assistant announces it is online.
+func NewPublisher(client *Client, registry *pixel.Registry, opts PublisherOptions) *Publisher {
+	if opts.DiscoveryPrefix == "" {
+		opts.DiscoveryPrefix = "homeassistant"
+	}
+	if opts.TopicPrefix == "" {
+		opts.TopicPrefix = "godice"
+	}
+	if opts.Name == nil {
+		opts.Name = func(state pixel.DieState) string {
+			if state.Name != "" {
+				return state.Name
+			}
+			return fmt.Sprintf("Pixel %08x", state.PixelId)
+		}
+	}
+
+	p := &Publisher{
+		client:   client,
+		registry: registry,
+		opts:     opts,
+		watched:  make(map[*pixel.Die]func()),
+	}
+	_ = client.Subscribe(opts.DiscoveryPrefix+"/status", func(msg Message) {
+		if string(msg.Payload) == "online" {
+			p.PublishAll()
+		}
+	})
+	return p
+}
+
+// Will is the last will to give the Client so the dice show as unavailable when godice stops
+func Will(topicPrefix string) *Message {
+	if topicPrefix == "" {
+		topicPrefix = "godice"
+	}
+	return &Message{Topic: topicPrefix + "/status", Payload: []byte("offline"), Retain: true}
+}
+
+// Watch publishes the die's entities and keeps their state up to date
+func (p *Publisher) Watch(die *pixel.Die) {
+	p.mu.Lock()
+	if _, ok := p.watched[die]; ok {
+		p.mu.Unlock()
+		return
+	}
+	events, stop := die.Subscribe(16)
+	p.watched[die] = stop
+	p.mu.Unlock()
+
+	p.publishDie(die.Snapshot(), true)
+	go func() {
+		for evt := range events {
+			switch evt.Type {
+			case pixel.EventRolled:
+				p.publishRoll(evt, "rolled")
+				p.publishState(evt.DieState)
+			case pixel.EventCrooked:
+				p.publishRoll(evt, "crooked")
+				p.publishState(evt.DieState)
+			case pixel.EventRollStarted, pixel.EventBatteryChanged:
+				p.publishState(evt.DieState)
+			case pixel.EventConnected:
+				p.publishConnected(evt.PixelId, true)
+			case pixel.EventDisconnected:
+				p.publishConnected(evt.PixelId, false)
+			}
+		}
+	}()
+}
+
+// Unwatch stops following the die and marks it disconnected, keeping its entities in Home Assistant
+func (p *Publisher) Unwatch(die *pixel.Die) {
+	p.mu.Lock()
+	stop, ok := p.watched[die]
+	delete(p.watched, die)
+	p.mu.Unlock()
+	if ok {
+		stop()
+		p.publishConnected(die.PixelId(), false)
+	}
+}
+
+// PublishAll republishes the bridge status and every registered die, e.g. after the broker or Home Assistant restarts
+func (p *Publisher) PublishAll() {
+	p.publish(p.opts.TopicPrefix+"/status", []byte("online"), true)
+	for _, die := range p.registry.List() {
+		p.mu.Lock()
+		_, watched := p.watched[die]
+		p.mu.Unlock()
+		p.publishDie(die.Snapshot(), watched)
+	}
+}
+
+func (p *Publisher) publishDie(state pixel.DieState, connected bool) {
+	p.publishDiscovery(state)
+	p.publishState(state)
+	p.publishConnected(state.PixelId, connected)
+}
+
+func (p *Publisher) dieTopic(id uint32, suffix string) string {
+	return fmt.Sprintf("%s/%08x/%s", p.opts.TopicPrefix, id, suffix)
+}
+
+// publishDiscovery sends the retained discovery config of every entity of the die
+func (p *Publisher) publishDiscovery(state pixel.DieState) {
+	uid := fmt.Sprintf("godice_%08x", state.PixelId)
+	device := map[string]any{
+		"identifiers":  []string{uid},
+		"name":         p.opts.Name(state),
+		"manufacturer": "Systemic Games",
+		"model":        "Pixels " + state.DieType.String(),
+	}
+	if state.BuildTimestamp != 0 {
+		device["sw_version"] = time.Unix(int64(state.BuildTimestamp), 0).UTC().Format("2006-01-02")
+	}
+	availability := []map[string]string{{"topic": p.opts.TopicPrefix + "/status"}}
+	stateTopic := p.dieTopic(state.PixelId, "state")
+	rollTopic := p.dieTopic(state.PixelId, "roll")
+
+	entities := []struct {
+		component string
+		object    string
+		config    map[string]any
+	}{
+		{"sensor", "face", map[string]any{
+			"name":           "Last face",
+			"icon":           "mdi:dice-multiple",
+			"state_topic":    stateTopic,
+			"value_template": "{{ value_json.face }}",
+		}},
+		{"sensor", "battery", map[string]any{
+			"name":                "Battery",
+			"device_class":        "battery",
+			"unit_of_measurement": "%",
+			"state_class":         "measurement",
+			"entity_category":     "diagnostic",
+			"state_topic":         stateTopic,
+			"value_template":      "{{ value_json.battery }}",
+		}},
+		{"binary_sensor", "charging", map[string]any{
+			"name":           "Charging",
+			"device_class":   "battery_charging",
+			"state_topic":    stateTopic,
+			"value_template": "{{ 'ON' if value_json.charging else 'OFF' }}",
+		}},
+		{"binary_sensor", "rolling", map[string]any{
+			"name":           "Rolling",
+			"device_class":   "moving",
+			"state_topic":    stateTopic,
+			"value_template": "{{ 'ON' if value_json.rolling else 'OFF' }}",
+		}},
+		{"binary_sensor", "connected", map[string]any{
+			"name":            "Connected",
+			"device_class":    "connectivity",
+			"entity_category": "diagnostic",
+			"state_topic":     p.dieTopic(state.PixelId, "connected"),
+		}},
+		{"event", "roll", map[string]any{
+			"name":        "Roll",
+			"event_types": []string{"rolled", "crooked"},
+			"state_topic": rollTopic,
+		}},
+		{"device_automation", "rolled", map[string]any{
+			"automation_type": "trigger",
+			"type":            "rolled",
+			"subtype":         "die",
+			"topic":           rollTopic,
+			"value_template":  "{{ value_json.event_type }}",
+			"payload":         "rolled",
+		}},
+	}
+
+	for _, entity := range entities {
+		config := entity.config
+		config["device"] = device
+		if entity.component != "device_automation" {
+			config["unique_id"] = uid + "_" + entity.object
+			config["availability"] = availability
+		}
+		payload, err := json.Marshal(config)
+		if err != nil {
+			log.Printf("mqtt: failed to encode %s discovery: %v", entity.object, err)
+			continue
+		}
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", p.opts.DiscoveryPrefix, entity.component, uid, entity.object)
+		p.publish(topic, payload, true)
+	}
+}
+
+func (p *Publisher) publishState(state pixel.DieState) {
+	payload, err := json.Marshal(dieStatePayload{
+		Face:      state.CurrentFaceValue,
+		FaceIndex: state.CurrentFaceIndex,
+		RollState: pixel.RollStateName(state.RollState),
+		Rolling:   pixel.IsRolling(state.RollState),
+		Battery:   state.BatteryLevel,
+		Charging:  state.BatteryCharging,
+		Rssi:      state.Rssi,
+	})
+	if err != nil {
+		return
+	}
+	p.publish(p.dieTopic(state.PixelId, "state"), payload, true)
+}
+
+func (p *Publisher) publishRoll(evt pixel.Event, eventType string) {
+	payload, err := json.Marshal(rollPayload{
+		EventType: eventType,
+		PixelId:   evt.PixelId,
+		Name:      p.opts.Name(evt.DieState),
+		DieType:   evt.DieType.String(),
+		Face:      evt.CurrentFaceValue,
+		Time:      evt.Time.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return
+	}
+	p.publish(p.dieTopic(evt.PixelId, "roll"), payload, false)
+}
+
+func (p *Publisher) publishConnected(id uint32, connected bool) {
+	payload := "OFF"
+	if connected {
+		payload = "ON"
+	}
+	p.publish(p.dieTopic(id, "connected"), []byte(payload), true)
+}
+
+func (p *Publisher) publish(topic string, payload []byte, retain bool) {
+	err := p.client.Publish(Message{Topic: topic, Payload: payload, Retain: retain})
+	if err != nil && err != ErrNotConnected {
+		log.Printf("mqtt: failed to publish %s: %v", topic, err)
+	}
+}
@@ -0,0 +1,87 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"godice/pixel"
+	"godice/pixel/sim"
+	"testing"
+)
+
+func TestPublisherDiscovery(t *testing.T) {
+	b := newTestBroker(t, 0)
+
+	simDie := sim.New(sim.Options{PixelId: 0xabcd1234, DieType: pixel.DieTypeD20, Name: "Crit"})
+	die, err := simDie.Connect()
+	if err != nil {
+		t.Fatalf("connect sim: %v", err)
+	}
+	defer die.Disconnect()
+	registry := pixel.NewRegistry()
+	registry.Add(die)
+
+	var publisher *Publisher
+	client, _ := startTestClient(t, b, Options{OnConnect: func() { publisher.PublishAll() }})
+	publisher = NewPublisher(client, registry, PublisherOptions{})
+	publisher.Watch(die)
+	defer publisher.Unwatch(die)
+
+	faceConfig := "homeassistant/sensor/godice_abcd1234/face/config"
+	b.waitFor("the discovery config", func() bool { return b.retained[faceConfig] != nil })
+
+	b.mu.Lock()
+	var config map[string]any
+	err = json.Unmarshal(b.retained[faceConfig], &config)
+	online := string(b.retained["godice/status"])
+	b.mu.Unlock()
+	if err != nil {
+		t.Fatalf("decode discovery config: %v", err)
+	}
+	if config["unique_id"] != "godice_abcd1234_face" || config["state_topic"] != "godice/abcd1234/state" {
+		t.Errorf("face config = %v", config)
+	}
+	if online != "online" {
+		t.Errorf("status = %q, want online", online)
+	}
+
+	simDie.RollValue(20)
+	b.waitFor("the roll", func() bool {
+		for _, msg := range b.published {
+			if msg.Topic == "godice/abcd1234/roll" && !msg.Retain {
+				return true
+			}
+		}
+		return false
+	})
+
+	b.mu.Lock()
+	var state dieStatePayload
+	err = json.Unmarshal(b.retained["godice/abcd1234/state"], &state)
+	b.mu.Unlock()
+	if err != nil {
+		t.Fatalf("decode state: %v", err)
+	}
+	if state.Face != 20 || state.Rolling {
+		t.Errorf("state = %+v, want face 20 and not rolling", state)
+	}
+}
+
+func TestPublisherRepublishesWhenHomeAssistantStarts(t *testing.T) {
+	b := newTestBroker(t, 0)
+	registry := pixel.NewRegistry()
+	simDie := sim.New(sim.Options{PixelId: 0x1})
+	die, err := simDie.Connect()
+	if err != nil {
+		t.Fatalf("connect sim: %v", err)
+	}
+	defer die.Disconnect()
+	registry.Add(die)
+
+	client, _ := startTestClient(t, b, Options{})
+	NewPublisher(client, registry, PublisherOptions{})
+	b.waitFor("the birth subscription", func() bool { return b.subscribed("homeassistant/status") })
+
+	b.publish(Message{Topic: "homeassistant/status", Payload: []byte("online")})
+	b.waitFor("the republished config", func() bool {
+		return b.retained["homeassistant/sensor/godice_00000001/battery/config"] != nil
+	})
+}
@@ -0,0 +1,157 @@
+package pixel
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+	"tinygo.org/x/bluetooth"
+)
+
+// ErrNoAdvertisementData is returned for scan results without Pixel manufacturer and service data
+var ErrNoAdvertisementData = errors.New("no pixel advertisement data")
+
+// Advertisement is the die state a Pixel broadcasts while it is not connected.
+//
+// The firmware's manufacturer data starts with the LED count and the design and color
+// bytes, which BLE stacks report as the little-endian company ID: the LED count is its
+// low byte. They are followed by the roll state, the current face index and the battery
+// level, with the top bit of the battery byte set while charging. Firmware that reports
+// its die type puts it first, ahead of the roll state; a d10 and a d00 can only be told
+// apart by it.
+// The service data holds the little-endian Pixel ID and build timestamp.
+type Advertisement struct {
+	Address        string
+	Name           string
+	Rssi           int16
+	PixelId        uint32
+	BuildTimestamp uint32
+	LedCount       uint8
+	DesignAndColor uint8
+	// DieType is DieTypeUnknown when the firmware does not advertise it
+	DieType          DieType
+	RollState        uint8
+	CurrentFaceIndex uint8
+	BatteryLevel     uint8
+	BatteryCharging  bool
+}
+
+// ParseAdvertisement decodes the Pixel advertisement payload of a scan result
+func ParseAdvertisement(result bluetooth.ScanResult) (Advertisement, error) {
+	adv, err := ParseAdvertisementData(result.ManufacturerData(), result.ServiceData())
+	if err != nil {
+		return Advertisement{}, err
+	}
+	adv.Address = result.Address.String()
+	adv.Name = result.LocalName()
+	adv.Rssi = result.RSSI
+	return adv, nil
+}
+
+// ParseAdvertisementData decodes Pixel manufacturer and service data elements
+func ParseAdvertisementData(manufacturerData []bluetooth.ManufacturerDataElement, serviceData []bluetooth.ServiceDataElement) (Advertisement, error) {
+	var adv Advertisement
+
+	var foundManufacturer, foundService bool
+	for _, element := range manufacturerData {
+		if len(element.Data) < 3 {
+			continue
+		}
+		adv.LedCount = uint8(element.CompanyID)
+		adv.DesignAndColor = uint8(element.CompanyID >> 8)
+		data := element.Data
+		if len(data) >= 4 {
+			adv.DieType = DieType(data[0])
+			data = data[1:]
+		}
+		adv.RollState = data[0]
+		adv.CurrentFaceIndex = data[1]
+		adv.BatteryLevel = data[2] & 0x7F
+		adv.BatteryCharging = data[2]&0x80 != 0
+		foundManufacturer = true
+		break
+	}
+	for _, element := range serviceData {
+		if len(element.Data) < 8 {
+			continue
+		}
+		adv.PixelId = binary.LittleEndian.Uint32(element.Data)
+		adv.BuildTimestamp = binary.LittleEndian.Uint32(element.Data[4:])
+		foundService = true
+		break
+	}
+
+	if !foundManufacturer || !foundService {
+		return Advertisement{}, ErrNoAdvertisementData
+	}
+	return adv, nil
+}
+
+// AdvertisementData encodes the advertisement as Pixel manufacturer and service data
+func (adv Advertisement) AdvertisementData() ([]bluetooth.ManufacturerDataElement, []bluetooth.ServiceDataElement) {
+	battery := adv.BatteryLevel & 0x7F
+	if adv.BatteryCharging {
+		battery |= 0x80
+	}
+	manufacturer := bluetooth.ManufacturerDataElement{
+		CompanyID: uint16(adv.DesignAndColor)<<8 | uint16(adv.LedCount),
+		Data:      []byte{adv.RollState, adv.CurrentFaceIndex, battery},
+	}
+	if adv.DieType != DieTypeUnknown {
+		manufacturer.Data = append([]byte{uint8(adv.DieType)}, manufacturer.Data...)
+	}
+
+	service := bluetooth.ServiceDataElement{UUID: pixelServiceUuid, Data: make([]byte, 8)}
+	binary.LittleEndian.PutUint32(service.Data, adv.PixelId)
+	binary.LittleEndian.PutUint32(service.Data[4:], adv.BuildTimestamp)
+
+	return []bluetooth.ManufacturerDataElement{manufacturer}, []bluetooth.ServiceDataElement{service}
+}
+
+// NewPassiveDie creates a Die tracked only through its advertisements, without a connection
+func NewPassiveDie(adv Advertisement) *Die {
+	die := &Die{}
+	die.ApplyAdvertisement(adv)
+	return die
+}
+
+// ApplyAdvertisement updates the die's state from an advertisement,
+// emitting the same roll and battery events as a connected die
+func (die *Die) ApplyAdvertisement(adv Advertisement) {
+	die.mu.Lock()
+	defer die.mu.Unlock()
+
+	die.pixelId = adv.PixelId
+	die.buildTimestamp = adv.BuildTimestamp
+	die.ledCount = adv.LedCount
+	if die.dieType == DieTypeUnknown {
+		die.dieType = DetectDieType(uint8(adv.DieType), adv.LedCount)
+	}
+	die.designAndColor = adv.DesignAndColor
+	if adv.Name != "" {
+		die.name = adv.Name
+	}
+	if adv.Rssi != 0 {
+		die.rssi = int8(adv.Rssi)
+	}
+	die.lastSeen = time.Now()
+
+	state := uint8(BattStateOk)
+	if adv.BatteryCharging {
+		state = BattStateCharging
+	}
+	die.updateBattery(adv.BatteryLevel, state)
+
+	if adv.RollState != die.rollState || adv.CurrentFaceIndex != die.currentFaceIndex {
+		// a die can be rolled and settle on a new face between two advertisements; a die that
+		// reported Rolled and then OnFace has already had its roll counted
+		if isSettled(adv.RollState) && !IsRolling(die.rollState) && die.rollState != RollStateRolled &&
+			adv.CurrentFaceIndex != die.currentFaceIndex && !die.lastRolled.IsZero() {
+			die.readRollStateMessage(MessageRollState{Id: MsgTypeRollState, RollState: RollStateRolling})
+		}
+		die.readRollStateMessage(MessageRollState{
+			Id:               MsgTypeRollState,
+			RollState:        adv.RollState,
+			CurrentFaceIndex: adv.CurrentFaceIndex,
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package pixel_test
+
+import (
+	"encoding/binary"
+	"godice/pixel"
+	"godice/pixel/sim"
+	"reflect"
+	"testing"
+	"tinygo.org/x/bluetooth"
+)
+
+// d20Advertisement is the on-air advertising and scan response payload of a d20 lying
+// on face 20, charging at 85%, as the firmware lays it out
+var d20Advertisement = []byte{
+	// flags
+	0x02, 0x01, 0x06,
+	// manufacturer data: LED count, design and color, roll state, face index, battery
+	0x06, 0xFF, 0x14, 0x03, pixel.RollStateOnFace, 0x13, 0xD5,
+	// 128-bit service data: the Pixels service UUID, Pixel ID and build timestamp
+	0x19, 0x21,
+	0x9E, 0xCA, 0xDC, 0x24, 0x0E, 0xE5, 0xA9, 0xE0, 0x93, 0xF3, 0xA3, 0xB5, 0x01, 0x00, 0x40, 0x6E,
+	0x4D, 0x3C, 0x2B, 0x1A, 0xB3, 0xA2, 0xF1, 0x66,
+	// complete local name
+	0x04, 0x09, 'D', '2', '0',
+}
+
+// parseAdvertisingPayload splits a raw payload into its manufacturer and service data elements
+func parseAdvertisingPayload(t *testing.T, payload []byte) ([]bluetooth.ManufacturerDataElement, []bluetooth.ServiceDataElement) {
+	t.Helper()
+	var manufacturer []bluetooth.ManufacturerDataElement
+	var service []bluetooth.ServiceDataElement
+	for len(payload) > 0 {
+		length := int(payload[0])
+		if length == 0 || length >= len(payload) {
+			t.Fatalf("bad AD structure length %d", length)
+		}
+		adType, data := payload[1], payload[2:1+length]
+		switch adType {
+		case 0xFF:
+			manufacturer = append(manufacturer, bluetooth.ManufacturerDataElement{
+				CompanyID: binary.LittleEndian.Uint16(data),
+				Data:      data[2:],
+			})
+		case 0x21:
+			var uuid [16]byte
+			for i := range uuid {
+				uuid[i] = data[15-i]
+			}
+			service = append(service, bluetooth.ServiceDataElement{UUID: bluetooth.NewUUID(uuid), Data: data[16:]})
+		}
+		payload = payload[1+length:]
+	}
+	return manufacturer, service
+}
+
+func TestParseAdvertisementData(t *testing.T) {
+	manufacturer, service := parseAdvertisingPayload(t, d20Advertisement)
+	adv, err := pixel.ParseAdvertisementData(manufacturer, service)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	want := pixel.Advertisement{
+		PixelId:          0x1A2B3C4D,
+		BuildTimestamp:   0x66F1A2B3,
+		LedCount:         20,
+		DesignAndColor:   3,
+		RollState:        pixel.RollStateOnFace,
+		CurrentFaceIndex: 19,
+		BatteryLevel:     85,
+		BatteryCharging:  true,
+	}
+	if adv != want {
+		t.Fatalf("advertisement = %+v, want %+v", adv, want)
+	}
+
+	die := pixel.NewPassiveDie(adv)
+	if state := die.Snapshot(); state.DieType != pixel.DieTypeD20 || state.CurrentFaceValue != 20 {
+		t.Errorf("passive die is a %s on %d, want a d20 on 20", state.DieType, state.CurrentFaceValue)
+	}
+
+	encodedManufacturer, encodedService := want.AdvertisementData()
+	if !reflect.DeepEqual(encodedManufacturer, manufacturer) {
+		t.Errorf("encoded manufacturer data = %+v, want %+v", encodedManufacturer, manufacturer)
+	}
+	if !reflect.DeepEqual(encodedService, service) {
+		t.Errorf("encoded service data = %+v, want %+v", encodedService, service)
+	}
+}
+
+func TestParseAdvertisementDataMissing(t *testing.T) {
+	manufacturer, service := parseAdvertisingPayload(t, d20Advertisement)
+	if _, err := pixel.ParseAdvertisementData(manufacturer, nil); err != pixel.ErrNoAdvertisementData {
+		t.Errorf("without service data: %v", err)
+	}
+	if _, err := pixel.ParseAdvertisementData(nil, service); err != pixel.ErrNoAdvertisementData {
+		t.Errorf("without manufacturer data: %v", err)
+	}
+}
+
+func TestSimAdvertisement(t *testing.T) {
+	simDie := sim.New(sim.Options{PixelId: 0x99, DieType: pixel.DieTypeD8, DesignAndColor: 5, BatteryLevel: 40})
+	simDie.RollValue(7)
+
+	adv, err := pixel.ParseAdvertisementData(simDie.AdvertisementData())
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if adv.PixelId != 0x99 || adv.LedCount != 8 || adv.DieType != pixel.DieTypeD8 || adv.DesignAndColor != 5 || adv.BatteryLevel != 40 {
+		t.Errorf("advertisement = %+v", adv)
+	}
+	if state := pixel.NewPassiveDie(adv).Snapshot(); state.DieType != pixel.DieTypeD8 || state.CurrentFaceValue != 7 {
+		t.Errorf("passive die is a %s on %d, want a d8 on 7", state.DieType, state.CurrentFaceValue)
+	}
+}
+
+// rolledFaces applies the advertisements to a passive die and returns the faces it reported rolling
+func rolledFaces(t *testing.T, ads ...pixel.Advertisement) []int {
+	t.Helper()
+	die := pixel.NewPassiveDie(ads[0])
+	events, stop := die.Subscribe(len(ads) * 2)
+	defer stop()
+	for _, adv := range ads[1:] {
+		die.ApplyAdvertisement(adv)
+	}
+
+	var faces []int
+	for len(events) > 0 {
+		if evt := <-events; evt.Type == pixel.EventRolled {
+			faces = append(faces, evt.CurrentFaceValue)
+		}
+	}
+	return faces
+}
+
+func TestApplyAdvertisementRolls(t *testing.T) {
+	advertise := func(rollState uint8, faceIndex uint8) pixel.Advertisement {
+		return pixel.Advertisement{PixelId: 7, LedCount: 20, RollState: rollState, CurrentFaceIndex: faceIndex, BatteryLevel: 50}
+	}
+	tests := []struct {
+		name string
+		ads  []pixel.Advertisement
+		want []int
+	}{
+		{
+			"rolling, rolled then on face",
+			[]pixel.Advertisement{
+				advertise(pixel.RollStateOnFace, 2),
+				advertise(pixel.RollStateRolling, 2),
+				advertise(pixel.RollStateRolled, 9),
+				advertise(pixel.RollStateOnFace, 9),
+			},
+			[]int{10},
+		},
+		{
+			"rolled between advertisements",
+			[]pixel.Advertisement{
+				advertise(pixel.RollStateOnFace, 2),
+				advertise(pixel.RollStateRolling, 2),
+				advertise(pixel.RollStateOnFace, 4),
+				advertise(pixel.RollStateOnFace, 11),
+			},
+			[]int{5, 12},
+		},
+		{
+			"still on the same face",
+			[]pixel.Advertisement{
+				advertise(pixel.RollStateOnFace, 2),
+				advertise(pixel.RollStateRolling, 2),
+				advertise(pixel.RollStateOnFace, 4),
+				advertise(pixel.RollStateOnFace, 4),
+				advertise(pixel.RollStateRolled, 4),
+			},
+			[]int{5},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := rolledFaces(t, test.ads...); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("rolled %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseAdvertisementDieType(t *testing.T) {
+	// firmware that reports its die type puts it ahead of the roll state
+	manufacturer := []bluetooth.ManufacturerDataElement{{
+		CompanyID: 0x030A,
+		Data:      []byte{uint8(pixel.DieTypeD00), pixel.RollStateOnFace, 4, 60},
+	}}
+	_, service := parseAdvertisingPayload(t, d20Advertisement)
+	adv, err := pixel.ParseAdvertisementData(manufacturer, service)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if adv.DieType != pixel.DieTypeD00 || adv.LedCount != 10 || adv.CurrentFaceIndex != 4 || adv.BatteryLevel != 60 {
+		t.Errorf("advertisement = %+v", adv)
+	}
+	if state := pixel.NewPassiveDie(adv).Snapshot(); state.DieType != pixel.DieTypeD00 || state.CurrentFaceValue != 40 {
+		t.Errorf("passive die is a %s on %d, want a d00 on 40", state.DieType, state.CurrentFaceValue)
+	}
+	if encoded, _ := adv.AdvertisementData(); !reflect.DeepEqual(encoded, manufacturer) {
+		t.Errorf("encoded manufacturer data = %+v, want %+v", encoded, manufacturer)
+	}
+
+	// without it the LED count can only say d10
+	manufacturer[0].Data = manufacturer[0].Data[1:]
+	if adv, err = pixel.ParseAdvertisementData(manufacturer, service); err != nil || adv.DieType != pixel.DieTypeUnknown {
+		t.Fatalf("advertisement without a die type = %+v, %v", adv, err)
+	}
+	if state := pixel.NewPassiveDie(adv).Snapshot(); state.DieType != pixel.DieTypeD10 {
+		t.Errorf("passive die is a %s, want a d10", state.DieType)
+	}
+}
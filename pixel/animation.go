@@ -0,0 +1,39 @@
+package pixel
+
+import "image/color"
+
+type MessagePlayAnimationEvent struct {
+	Id              uint8
+	Event           uint8
+	RemoteFaceIndex uint8
+	LoopCount       uint8
+}
+
+func parsePlayAnimationEventMessage(buf []byte) (MessagePlayAnimationEvent, error) {
+	if err := checkMessage(buf, 4, MsgTypePlayAnimationEvent); err != nil {
+		return MessagePlayAnimationEvent{}, err
+	}
+	msg := MessagePlayAnimationEvent{
+		Id:              buf[0],
+		Event:           buf[1],
+		RemoteFaceIndex: buf[2],
+		LoopCount:       buf[3],
+	}
+	return msg, nil
+}
+
+type MessageDefaultAnimationSetColor struct {
+	Id    uint8
+	Color color.RGBA
+}
+
+func parseDefaultAnimationSetColorMessage(buf []byte) (MessageDefaultAnimationSetColor, error) {
+	if err := checkMessage(buf, 4, MsgTypeDefaultAnimationSetColor); err != nil {
+		return MessageDefaultAnimationSetColor{}, err
+	}
+	msg := MessageDefaultAnimationSetColor{
+		Id:    buf[0],
+		Color: color.RGBA{R: buf[3], G: buf[2], B: buf[1], A: 0xFF},
+	}
+	return msg, nil
+}
@@ -6,21 +6,32 @@ type MessageBatteryLevel struct {
 	BatteryState uint8
 }
 
-func parseBatteryLevelMessage(buf []byte) MessageBatteryLevel {
+func parseBatteryLevelMessage(buf []byte) (MessageBatteryLevel, error) {
+	if err := checkMessage(buf, 3, MsgTypeBatteryLevel); err != nil {
+		return MessageBatteryLevel{}, err
+	}
 	msg := MessageBatteryLevel{
 		Id:           buf[0],
 		BatteryLevel: buf[1],
 		BatteryState: buf[2],
 	}
-	return msg
+	return msg, nil
 }
 
-func (die *Die) readBatteryBuffer(buf []byte) {
-	msg := parseBatteryLevelMessage(buf)
-	die.readBatteryMsg(msg)
+func (msg MessageBatteryLevel) ToBuffer() []byte {
+	return []byte{MsgTypeBatteryLevel, msg.BatteryLevel, msg.BatteryState}
 }
 
 func (die *Die) readBatteryMsg(msg MessageBatteryLevel) {
-	die.batteryLevel = msg.BatteryLevel
-	die.batteryCharging = msg.BatteryState == BattStateCharging
+	die.updateBattery(msg.BatteryLevel, msg.BatteryState)
+}
+
+func (die *Die) updateBattery(level uint8, state uint8) {
+	charging := state == BattStateCharging
+	changed := die.batteryLevel != level || die.batteryCharging != charging
+	die.batteryLevel = level
+	die.batteryCharging = charging
+	if changed {
+		die.emit(EventBatteryChanged)
+	}
 }
@@ -2,6 +2,7 @@ package pixel
 
 import (
 	"encoding/binary"
+	"fmt"
 	"time"
 )
 
@@ -9,46 +10,198 @@ type MessageIAmADie struct {
 	Id               uint8
 	LedCount         uint8
 	DesignAndColor   uint8
-	Reserved         uint8
+	DieType          uint8
 	DataSetHash      uint32
 	PixelId          uint32
 	AvailableFlash   uint16
 	BuildTimestamp   uint32
 	RollState        uint8
 	CurrentFaceIndex uint8
-	CurrentFaceValue uint8
 	BatteryLevel     uint8
 	BatteryState     uint8
 }
 
-func parseIAmADieMessage(buf []byte) MessageIAmADie {
+func parseIAmADieMessage(buf []byte) (MessageIAmADie, error) {
+	if err := checkMessage(buf, 22, MsgTypeIAmADie); err != nil {
+		return MessageIAmADie{}, err
+	}
 	msg := MessageIAmADie{
 		Id:               buf[0],
 		LedCount:         buf[1],
 		DesignAndColor:   buf[2],
-		Reserved:         buf[3],
+		DieType:          buf[3],
 		DataSetHash:      binary.LittleEndian.Uint32(buf[4:]),
 		PixelId:          binary.LittleEndian.Uint32(buf[8:]),
 		AvailableFlash:   binary.LittleEndian.Uint16(buf[12:]),
 		BuildTimestamp:   binary.LittleEndian.Uint32(buf[14:]),
 		RollState:        buf[18],
 		CurrentFaceIndex: buf[19],
-		CurrentFaceValue: buf[19] + 1,
 		BatteryLevel:     buf[20],
 		BatteryState:     buf[21],
 	}
-	return msg
+	return msg, nil
+}
+
+func (msg MessageIAmADie) ToBuffer() (buf []byte) {
+	buf = make([]byte, 22)
+	buf[0] = MsgTypeIAmADie
+	buf[1] = msg.LedCount
+	buf[2] = msg.DesignAndColor
+	buf[3] = msg.DieType
+	binary.LittleEndian.PutUint32(buf[4:], msg.DataSetHash)
+	binary.LittleEndian.PutUint32(buf[8:], msg.PixelId)
+	binary.LittleEndian.PutUint16(buf[12:], msg.AvailableFlash)
+	binary.LittleEndian.PutUint32(buf[14:], msg.BuildTimestamp)
+	buf[18] = msg.RollState
+	buf[19] = msg.CurrentFaceIndex
+	buf[20] = msg.BatteryLevel
+	buf[21] = msg.BatteryState
+
+	return buf
 }
 
 func (die *Die) readIAmADieMsg(msg MessageIAmADie) {
-	die.PixelId = msg.PixelId
+	die.pixelId = msg.PixelId
 	die.ledCount = msg.LedCount
 	die.designAndColor = msg.DesignAndColor
-	die.CurrentFaceIndex = msg.CurrentFaceIndex
-	die.CurrentFaceValue = msg.CurrentFaceValue
+	die.currentFaceIndex = msg.CurrentFaceIndex
+	die.dieType = DetectDieType(msg.DieType, msg.LedCount)
+	die.currentFaceValue = die.dieType.FaceValue(msg.CurrentFaceIndex)
 	die.rollState = msg.RollState
-	die.batteryLevel = msg.BatteryLevel
 	die.buildTimestamp = msg.BuildTimestamp
-	die.batteryCharging = msg.BatteryState == BattStateCharging
-	die.LastRolled = time.Now()
+	die.lastRolled = time.Now()
+	die.updateBattery(msg.BatteryLevel, msg.BatteryState)
+}
+
+type MessageBlinkAck struct {
+}
+
+func (msg MessageBlinkAck) ToBuffer() []byte {
+	return []byte{MsgTypeBlinkAck}
+}
+
+func parseBlinkAckMessage(buf []byte) (MessageBlinkAck, error) {
+	if err := checkMessage(buf, 1, MsgTypeBlinkAck); err != nil {
+		return MessageBlinkAck{}, err
+	}
+	return MessageBlinkAck{}, nil
+}
+
+type MessageDebugLog struct {
+	Id      uint8
+	Message string
+}
+
+func parseDebugLogMessage(buf []byte) (MessageDebugLog, error) {
+	if err := checkMessage(buf, 1, MsgTypeDebugLog); err != nil {
+		return MessageDebugLog{}, err
+	}
+	msg := MessageDebugLog{
+		Id:      buf[0],
+		Message: parseCString(buf[1:]),
+	}
+	return msg, nil
+}
+
+type MessageNotifyUser struct {
+	Id             uint8
+	TimeoutSeconds uint8
+	Ok             bool
+	Cancel         bool
+	Message        string
+}
+
+func parseNotifyUserMessage(buf []byte) (MessageNotifyUser, error) {
+	if err := checkMessage(buf, 4, MsgTypeNotifyUser); err != nil {
+		return MessageNotifyUser{}, err
+	}
+	msg := MessageNotifyUser{
+		Id:             buf[0],
+		TimeoutSeconds: buf[1],
+		Ok:             buf[2] != 0,
+		Cancel:         buf[3] != 0,
+		Message:        parseCString(buf[4:]),
+	}
+	return msg, nil
+}
+
+type MessageSetNameAck struct {
+	Id uint8
+}
+
+func parseSetNameAckMessage(buf []byte) (MessageSetNameAck, error) {
+	if err := checkMessage(buf, 1, MsgTypeSetNameAck); err != nil {
+		return MessageSetNameAck{}, err
+	}
+	return MessageSetNameAck{Id: buf[0]}, nil
+}
+
+func (msg MessageSetNameAck) ToBuffer() []byte {
+	return []byte{MsgTypeSetNameAck}
+}
+
+type MessageSetDesignAndColorAck struct {
+	Id uint8
+}
+
+func parseSetDesignAndColorAckMessage(buf []byte) (MessageSetDesignAndColorAck, error) {
+	if err := checkMessage(buf, 1, MsgTypeSetDesignAndColorAck); err != nil {
+		return MessageSetDesignAndColorAck{}, err
+	}
+	return MessageSetDesignAndColorAck{Id: buf[0]}, nil
+}
+
+func (msg MessageSetDesignAndColorAck) ToBuffer() []byte {
+	return []byte{MsgTypeSetDesignAndColorAck}
+}
+
+// parseMessage decodes a notification into its typed message struct
+func parseMessage(buf []byte) (any, error) {
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("%w: empty buffer", ErrShortMessage)
+	}
+
+	switch buf[0] {
+	case MsgTypeIAmADie:
+		return asMessage(parseIAmADieMessage(buf))
+	case MsgTypeRollState:
+		return asMessage(parseRollStateMessage(buf))
+	case MsgTypeTelemetry:
+		return asMessage(parseTelemetryMessage(buf))
+	case MsgTypeBulkSetupAck:
+		return asMessage(parseBulkSetupAckMessage(buf))
+	case MsgTypeBulkDataAck:
+		return asMessage(parseBulkDataAckMessage(buf))
+	case MsgTypeTransferAnimationSetAck, MsgTypeTransferSettingsAck,
+		MsgTypeTransferTestAnimationSetAck, MsgTypeTransferInstantAnimationSetAck:
+		return asMessage(parseTransferAckMessage(buf))
+	case MsgTypeDebugLog:
+		return asMessage(parseDebugLogMessage(buf))
+	case MsgTypePlayAnimationEvent:
+		return asMessage(parsePlayAnimationEventMessage(buf))
+	case MsgTypeBlinkAck:
+		return asMessage(parseBlinkAckMessage(buf))
+	case MsgTypeDefaultAnimationSetColor:
+		return asMessage(parseDefaultAnimationSetColorMessage(buf))
+	case MsgTypeBatteryLevel:
+		return asMessage(parseBatteryLevelMessage(buf))
+	case MsgTypeRssi:
+		return asMessage(parseRssiMessage(buf))
+	case MsgTypeNotifyUser:
+		return asMessage(parseNotifyUserMessage(buf))
+	case MsgTypeSetDesignAndColorAck:
+		return asMessage(parseSetDesignAndColorAckMessage(buf))
+	case MsgTypeSetNameAck:
+		return asMessage(parseSetNameAckMessage(buf))
+	case MsgTypeTemperature:
+		return asMessage(parseTemperatureMessage(buf))
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnknownMessage, buf[0])
+}
+
+func asMessage[T any](msg T, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
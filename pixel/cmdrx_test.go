@@ -0,0 +1,69 @@
+package pixel
+
+import (
+	"errors"
+	"testing"
+)
+
+// FuzzParseMessage feeds arbitrary notifications to the decoders and to a die,
+// which must turn every frame into either a message or an error
+func FuzzParseMessage(f *testing.F) {
+	seeds := [][]byte{
+		MessageIAmADie{LedCount: 20, DieType: uint8(DieTypeD20), PixelId: 0x1234, CurrentFaceIndex: 19, BatteryLevel: 80}.ToBuffer(),
+		MessageRollState{RollState: RollStateOnFace, CurrentFaceIndex: 5}.ToBuffer(),
+		MessageBatteryLevel{BatteryLevel: 50, BatteryState: 1}.ToBuffer(),
+		MessageRssi{Value: -60}.ToBuffer(),
+		MessageTemperature{McuTemperatureTimes100: 2500, BatteryTemperatureTimes100: 2400}.ToBuffer(),
+		MessageBlinkAck{}.ToBuffer(),
+		MessageSetNameAck{}.ToBuffer(),
+		MessageSetDesignAndColorAck{}.ToBuffer(),
+		{MsgTypeDebugLog, 'h', 'i', 0},
+		{MsgTypeNotifyUser, 5, 1, 0, 'o', 'k'},
+		{MsgTypeTelemetry},
+		{MsgTypeBulkSetupAck},
+		{MsgTypeBulkDataAck, 0, 0},
+		{MsgTypeTransferAnimationSetAck, 1},
+		{MsgTypePlayAnimationEvent, 1, 2},
+		{MsgTypeDefaultAnimationSetColor, 1, 2, 3, 4},
+		{0xFF},
+		{},
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		msg, err := parseMessage(buf)
+		switch {
+		case err != nil:
+			if msg != nil {
+				t.Errorf("parseMessage(%x) returned %T with error %v", buf, msg, err)
+			}
+			if !errors.Is(err, ErrShortMessage) && !errors.Is(err, ErrUnknownMessage) {
+				t.Errorf("parseMessage(%x) error %v is neither ErrShortMessage nor ErrUnknownMessage", buf, err)
+			}
+		case msg == nil:
+			t.Errorf("parseMessage(%x) returned neither a message nor an error", buf)
+		}
+
+		die := &Die{}
+		events, stop := die.Subscribe(64)
+		defer stop()
+		die.PixelCharacteristicReceiver(buf)
+
+		want := EventMessage
+		if err != nil {
+			want = EventError
+		}
+		for {
+			select {
+			case evt := <-events:
+				if evt.Type == want {
+					return
+				}
+			default:
+				t.Fatalf("the die did not emit %s for %x", want, buf)
+			}
+		}
+	})
+}
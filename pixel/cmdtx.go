@@ -2,16 +2,27 @@ package pixel
 
 import (
 	"encoding/binary"
+	"errors"
 	"image/color"
+	"unicode/utf8"
 )
 
+// ErrNotConnected is returned when sending to a die that has no transport
+var ErrNotConnected = errors.New("die not connected")
+
 type TxMessage interface {
 	ToBuffer() []byte
 }
 
 func (die *Die) SendMsg(msg TxMessage) error {
-	_, err := die.writeChar.WriteWithoutResponse(msg.ToBuffer())
-	return err
+	die.mu.RLock()
+	transport := die.transport
+	die.mu.RUnlock()
+
+	if transport == nil {
+		return ErrNotConnected
+	}
+	return transport.Write(msg.ToBuffer())
 }
 
 type MessageWhoAreYou struct {
@@ -35,13 +46,159 @@ func (msg MessageBlink) ToBuffer() (buf []byte) {
 	buf[0] = MsgTypeBlink
 	buf[1] = msg.Count
 	binary.LittleEndian.PutUint16(buf[2:], msg.Duration)
+	// the firmware reads the color as a little endian 0x00RRGGBB word
 	buf[4] = msg.Color.B
 	buf[5] = msg.Color.G
 	buf[6] = msg.Color.R
-	buf[7] = msg.Color.A
 	binary.LittleEndian.PutUint32(buf[8:], msg.FaceMask)
 	buf[12] = msg.Fade
 	buf[13] = msg.LoopCount
 
 	return buf
 }
+
+// Telemetry Request Modes
+const (
+	TelemetryRequestModeOff = iota
+	TelemetryRequestModeOnce
+	TelemetryRequestModeRepeat
+)
+
+// MaxNameLength is the longest name, in bytes, a die accepts
+const MaxNameLength = 31
+
+type MessageRequestRollState struct {
+}
+
+func (msg MessageRequestRollState) ToBuffer() []byte {
+	return []byte{MsgTypeRequestRollState}
+}
+
+type MessageRequestBatteryLevel struct {
+}
+
+func (msg MessageRequestBatteryLevel) ToBuffer() []byte {
+	return []byte{MsgTypeRequestBatteryLevel}
+}
+
+type MessageRequestRssi struct {
+	RequestMode uint8
+	MinInterval uint16
+}
+
+func (msg MessageRequestRssi) ToBuffer() (buf []byte) {
+	buf = make([]byte, 4)
+	buf[0] = MsgTypeRequestRssi
+	buf[1] = msg.RequestMode
+	binary.LittleEndian.PutUint16(buf[2:], msg.MinInterval)
+
+	return buf
+}
+
+type MessageRequestTemperature struct {
+}
+
+func (msg MessageRequestTemperature) ToBuffer() []byte {
+	return []byte{MsgTypeRequestTemperature}
+}
+
+type MessageRequestTelemetry struct {
+	RequestMode uint8
+	MinInterval uint16
+}
+
+func (msg MessageRequestTelemetry) ToBuffer() (buf []byte) {
+	buf = make([]byte, 4)
+	buf[0] = MsgTypeRequestTelemetry
+	buf[1] = msg.RequestMode
+	binary.LittleEndian.PutUint16(buf[2:], msg.MinInterval)
+
+	return buf
+}
+
+type MessageStopAllAnimations struct {
+}
+
+func (msg MessageStopAllAnimations) ToBuffer() []byte {
+	return []byte{MsgTypeStopAllAnimations}
+}
+
+type MessageSetName struct {
+	Name string
+}
+
+// ToBuffer encodes the name null terminated, truncated to at most MaxNameLength bytes
+// without splitting a UTF-8 character
+func (msg MessageSetName) ToBuffer() (buf []byte) {
+	name := []byte(msg.Name)
+	if len(name) > MaxNameLength {
+		n := MaxNameLength
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = name[:n]
+	}
+
+	buf = make([]byte, len(name)+2)
+	buf[0] = MsgTypeSetName
+	copy(buf[1:], name)
+
+	return buf
+}
+
+// ParseSetName decodes a SetName message the way the firmware reads it, up to the null terminator
+func ParseSetName(buf []byte) (MessageSetName, error) {
+	if err := checkMessage(buf, 1, MsgTypeSetName); err != nil {
+		return MessageSetName{}, err
+	}
+	return MessageSetName{Name: parseCString(buf[1:])}, nil
+}
+
+type MessageSetDesignAndColor struct {
+	DesignAndColor uint8
+}
+
+func (msg MessageSetDesignAndColor) ToBuffer() []byte {
+	return []byte{MsgTypeSetDesignAndColor, msg.DesignAndColor}
+}
+
+type MessageSleep struct {
+}
+
+func (msg MessageSleep) ToBuffer() []byte {
+	return []byte{MsgTypeSleep}
+}
+
+type MessageCalibrate struct {
+}
+
+func (msg MessageCalibrate) ToBuffer() []byte {
+	return []byte{MsgTypeCalibrate}
+}
+
+type MessageCalibrateFace struct {
+	FaceIndex uint8
+}
+
+func (msg MessageCalibrateFace) ToBuffer() []byte {
+	return []byte{MsgTypeCalibrateFace, msg.FaceIndex}
+}
+
+type MessagePlayAnimation struct {
+	Animation       uint8
+	RemoteFaceIndex uint8
+	LoopCount       uint8
+}
+
+func (msg MessagePlayAnimation) ToBuffer() []byte {
+	return []byte{MsgTypePlayAnimation, msg.Animation, msg.RemoteFaceIndex, msg.LoopCount}
+}
+
+type MessageStopAnimation struct {
+	Animation       uint8
+	RemoteFaceIndex uint8
+}
+
+func (msg MessageStopAnimation) ToBuffer() []byte {
+	return []byte{MsgTypeStopAnimation, msg.Animation, msg.RemoteFaceIndex}
+}
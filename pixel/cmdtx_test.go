@@ -0,0 +1,250 @@
+package pixel
+
+import (
+	"bytes"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// The expected frames follow the message layouts of the Pixels firmware: a message
+// type byte followed by the packed, little endian fields.
+
+func TestEncodeMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  TxMessage
+		want []byte
+	}{
+		{"who are you", MessageWhoAreYou{}, []byte{1}},
+		{
+			"blink",
+			MessageBlink{
+				Count:     3,
+				Duration:  1000,
+				Color:     color.RGBA{R: 0x11, G: 0x22, B: 0x33, A: 0xFF},
+				FaceMask:  0x000FFFFF,
+				Fade:      128,
+				LoopCount: 2,
+			},
+			// count, duration u16, color 0x00RRGGBB u32, face mask u32, fade, loop count
+			[]byte{29, 3, 0xE8, 0x03, 0x33, 0x22, 0x11, 0x00, 0xFF, 0xFF, 0x0F, 0x00, 128, 2},
+		},
+		{"request roll state", MessageRequestRollState{}, []byte{23}},
+		{"request battery level", MessageRequestBatteryLevel{}, []byte{33}},
+		{"request rssi", MessageRequestRssi{RequestMode: TelemetryRequestModeRepeat, MinInterval: 500}, []byte{35, 2, 0xF4, 0x01}},
+		{"request temperature", MessageRequestTemperature{}, []byte{60}},
+		{"request telemetry", MessageRequestTelemetry{RequestMode: TelemetryRequestModeOnce, MinInterval: 0x0102}, []byte{26, 1, 0x02, 0x01}},
+		{"stop all animations", MessageStopAllAnimations{}, []byte{59}},
+		{"set name", MessageSetName{Name: "D20"}, []byte{51, 'D', '2', '0', 0}},
+		{"set empty name", MessageSetName{}, []byte{51, 0}},
+		{
+			"set long name",
+			MessageSetName{Name: "abcdefghijklmnopqrstuvwxyz0123456789"},
+			append(append([]byte{51}, "abcdefghijklmnopqrstuvwxyz01234"...), 0),
+		},
+		{
+			// "é" would straddle the 31 byte limit, so it is left out whole
+			"set long multi-byte name",
+			MessageSetName{Name: "abcdefghijklmnopqrstuvwxyz0123é"},
+			append(append([]byte{51}, "abcdefghijklmnopqrstuvwxyz0123"...), 0),
+		},
+		{
+			"set long name of three byte characters",
+			MessageSetName{Name: strings.Repeat("€", 11)},
+			append(append([]byte{51}, strings.Repeat("€", 10)...), 0),
+		},
+		{"set design and color", MessageSetDesignAndColor{DesignAndColor: 7}, []byte{47, 7}},
+		{"sleep", MessageSleep{}, []byte{53}},
+		{"calibrate", MessageCalibrate{}, []byte{37}},
+		{"calibrate face", MessageCalibrateFace{FaceIndex: 19}, []byte{38, 19}},
+		{"play animation", MessagePlayAnimation{Animation: 4, RemoteFaceIndex: 19, LoopCount: 1}, []byte{19, 4, 19, 1}},
+		{"stop animation", MessageStopAnimation{Animation: 4, RemoteFaceIndex: 19}, []byte{21, 4, 19}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.msg.ToBuffer(); !bytes.Equal(got, test.want) {
+				t.Errorf("ToBuffer() = % x, want % x", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetNameIsValidUTF8(t *testing.T) {
+	for _, name := range []string{strings.Repeat("é", 20), strings.Repeat("€", 20), strings.Repeat("🎲", 20), "a" + strings.Repeat("🎲", 20)} {
+		buf := MessageSetName{Name: name}.ToBuffer()
+		encoded := buf[1 : len(buf)-1]
+		if len(encoded) > MaxNameLength || !utf8.Valid(encoded) || !strings.HasPrefix(name, string(encoded)) {
+			t.Errorf("%q encoded as %q", name, encoded)
+		}
+	}
+}
+
+func TestDurationsAreClamped(t *testing.T) {
+	transport := NewMemoryTransport()
+	die := &Die{transport: transport}
+	for _, duration := range []time.Duration{1500 * time.Millisecond, 70 * time.Second, -time.Second} {
+		if err := die.Blink(color.RGBA{R: 0xFF}, 1, duration, 1); err != nil {
+			t.Fatalf("Blink: %v", err)
+		}
+	}
+	if err := die.RequestTelemetry(TelemetryRequestModeRepeat, time.Hour); err != nil {
+		t.Fatalf("RequestTelemetry: %v", err)
+	}
+
+	written := transport.Written()
+	for i, want := range []uint16{1500, 65535, 0} {
+		if got := uint16(written[i][2]) | uint16(written[i][3])<<8; got != want {
+			t.Errorf("blink %d duration = %d, want %d", i, got, want)
+		}
+	}
+	if !bytes.Equal(written[3], []byte{MsgTypeRequestTelemetry, TelemetryRequestModeRepeat, 0xFF, 0xFF}) {
+		t.Errorf("telemetry request = % x, want the interval clamped to 65535ms", written[3])
+	}
+}
+
+func TestDecodeMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		want any
+		// encodes reports whether the message's ToBuffer must give buf back
+		encodes bool
+	}{
+		{
+			"i am a die",
+			// led count, design and color, die type, data set hash u32, pixel id u32, available flash u16,
+			// build timestamp u32, roll state, face index, battery level, battery state
+			[]byte{2, 20, 3, 9, 0x44, 0x33, 0x22, 0x11, 0x78, 0x56, 0x34, 0x12, 0x00, 0x10,
+				0x80, 0x5F, 0x12, 0x67, RollStateOnFace, 19, 85, BattStateCharging},
+			MessageIAmADie{
+				Id:               MsgTypeIAmADie,
+				LedCount:         20,
+				DesignAndColor:   3,
+				DieType:          9,
+				DataSetHash:      0x11223344,
+				PixelId:          0x12345678,
+				AvailableFlash:   0x1000,
+				BuildTimestamp:   0x67125F80,
+				RollState:        RollStateOnFace,
+				CurrentFaceIndex: 19,
+				BatteryLevel:     85,
+				BatteryState:     BattStateCharging,
+			},
+			true,
+		},
+		{
+			"roll state",
+			[]byte{3, RollStateRolling, 7},
+			MessageRollState{Id: MsgTypeRollState, RollState: RollStateRolling, CurrentFaceIndex: 7},
+			true,
+		},
+		{
+			"telemetry",
+			// acc x, y, z i16, face confidence i32, time u32, roll state, face index, battery level,
+			// battery state, controller state, voltage, vcoil, rssi i8, channel, mcu and battery temperature i16,
+			// internal charge, force disable charging, led current
+			[]byte{4, 0x18, 0xFC, 0xE8, 0x03, 0x00, 0x00, 0xE8, 0x03, 0x00, 0x00, 0x10, 0x27, 0x00, 0x00,
+				RollStateOnFace, 5, 90, BattStateOk, 2, 205, 210, 0xC4, 12, 0xC4, 0x09, 0x60, 0x09, 1, 0, 42},
+			MessageTelemetry{
+				Id:                         MsgTypeTelemetry,
+				AccXTimes1000:              -1000,
+				AccYTimes1000:              1000,
+				FaceConfidenceTimes1000:    1000,
+				Time:                       10000,
+				RollState:                  RollStateOnFace,
+				CurrentFaceIndex:           5,
+				BatteryLevel:               90,
+				BatteryState:               BattStateOk,
+				BatteryControllerState:     2,
+				VoltageTimes50:             205,
+				VCoilTimes50:               210,
+				Rssi:                       -60,
+				ChannelIndex:               12,
+				McuTemperatureTimes100:     2500,
+				BatteryTemperatureTimes100: 2400,
+				InternalChargeState:        true,
+				LedCurrent:                 42,
+			},
+			false,
+		},
+		{"bulk setup ack", []byte{6}, MessageBulkSetupAck{Id: MsgTypeBulkSetupAck}, false},
+		{"bulk data ack", []byte{8, 0x00, 0x01}, MessageBulkDataAck{Id: MsgTypeBulkDataAck, Offset: 256}, false},
+		{
+			"transfer animation set ack",
+			[]byte{10, TransferAckUpToDate},
+			MessageTransferAck{Id: MsgTypeTransferAnimationSetAck, Result: TransferAckUpToDate},
+			false,
+		},
+		{"debug log", []byte{18, 'o', 'k', 0, 'x'}, MessageDebugLog{Id: MsgTypeDebugLog, Message: "ok"}, false},
+		{
+			"play animation event",
+			[]byte{20, 1, 19, 2},
+			MessagePlayAnimationEvent{Id: MsgTypePlayAnimationEvent, Event: 1, RemoteFaceIndex: 19, LoopCount: 2},
+			false,
+		},
+		{"blink ack", []byte{30}, MessageBlinkAck{}, true},
+		{
+			"default animation set color",
+			[]byte{32, 0x33, 0x22, 0x11},
+			MessageDefaultAnimationSetColor{Id: MsgTypeDefaultAnimationSetColor, Color: color.RGBA{R: 0x11, G: 0x22, B: 0x33, A: 0xFF}},
+			false,
+		},
+		{
+			"battery level",
+			[]byte{34, 42, BattStateLow},
+			MessageBatteryLevel{Id: MsgTypeBatteryLevel, BatteryLevel: 42, BatteryState: BattStateLow},
+			true,
+		},
+		{"rssi", []byte{36, 0xBA}, MessageRssi{Id: MsgTypeRssi, Value: -70}, true},
+		{
+			"notify user",
+			[]byte{39, 30, 1, 0, 'h', 'i', 0},
+			MessageNotifyUser{Id: MsgTypeNotifyUser, TimeoutSeconds: 30, Ok: true, Message: "hi"},
+			false,
+		},
+		{"set design and color ack", []byte{48}, MessageSetDesignAndColorAck{Id: MsgTypeSetDesignAndColorAck}, true},
+		{"set name ack", []byte{52}, MessageSetNameAck{Id: MsgTypeSetNameAck}, true},
+		{
+			"temperature",
+			[]byte{61, 0xC4, 0x09, 0x38, 0xFF},
+			MessageTemperature{Id: MsgTypeTemperature, McuTemperatureTimes100: 2500, BatteryTemperatureTimes100: -200},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseMessage(test.buf)
+			if err != nil {
+				t.Fatalf("parseMessage(% x): %v", test.buf, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseMessage(% x) = %+v, want %+v", test.buf, got, test.want)
+			}
+			if !test.encodes {
+				return
+			}
+			if encoded := got.(TxMessage).ToBuffer(); !bytes.Equal(encoded, test.buf) {
+				t.Errorf("ToBuffer() = % x, want % x", encoded, test.buf)
+			}
+		})
+	}
+}
+
+func TestParseSetName(t *testing.T) {
+	for _, name := range []string{"", "D20", strings.Repeat("€", 11)} {
+		buf := MessageSetName{Name: name}.ToBuffer()
+		msg, err := ParseSetName(buf)
+		if err != nil || !strings.HasPrefix(name, msg.Name) || !bytes.Equal(msg.ToBuffer(), buf) {
+			t.Errorf("ParseSetName(% x) = %q, %v", buf, msg.Name, err)
+		}
+	}
+	if _, err := ParseSetName([]byte{MsgTypeSleep}); err == nil {
+		t.Error("parsed a Sleep message as SetName")
+	}
+}
@@ -0,0 +1,100 @@
+package pixel
+
+import (
+	"image/color"
+	"time"
+)
+
+// Blink flashes the given faces of the die; durations over 65.535 seconds are clamped
+func (die *Die) Blink(c color.RGBA, count uint8, duration time.Duration, faceMask uint32) error {
+	return die.SendMsg(MessageBlink{
+		Count:    count,
+		Duration: milliseconds16(duration),
+		Color:    c,
+		FaceMask: faceMask,
+		Fade:     128,
+	})
+}
+
+// RequestRollState asks the die to report its roll state
+func (die *Die) RequestRollState() error {
+	return die.SendMsg(MessageRequestRollState{})
+}
+
+// RequestBatteryLevel asks the die to report its battery level
+func (die *Die) RequestBatteryLevel() error {
+	return die.SendMsg(MessageRequestBatteryLevel{})
+}
+
+// RequestRssi asks the die to report the signal strength it sees
+func (die *Die) RequestRssi() error {
+	return die.SendMsg(MessageRequestRssi{RequestMode: TelemetryRequestModeOnce})
+}
+
+// RequestTemperature asks the die to report its MCU and battery temperatures
+func (die *Die) RequestTemperature() error {
+	return die.SendMsg(MessageRequestTemperature{})
+}
+
+// RequestTelemetry starts, stops or requests a single telemetry report; minInterval is clamped to 65.535 seconds
+func (die *Die) RequestTelemetry(mode uint8, minInterval time.Duration) error {
+	return die.SendMsg(MessageRequestTelemetry{
+		RequestMode: mode,
+		MinInterval: milliseconds16(minInterval),
+	})
+}
+
+// StopAllAnimations stops every animation playing on the die
+func (die *Die) StopAllAnimations() error {
+	return die.SendMsg(MessageStopAllAnimations{})
+}
+
+// SetName renames the die, truncating the name to MaxNameLength bytes without splitting a character
+func (die *Die) SetName(name string) error {
+	msg := MessageSetName{Name: name}
+	if err := die.SendMsg(msg); err != nil {
+		return err
+	}
+
+	die.mu.Lock()
+	defer die.mu.Unlock()
+	die.name = parseCString(msg.ToBuffer()[1:])
+	return nil
+}
+
+// SetDesignAndColor sets the die's DnC* design and color
+func (die *Die) SetDesignAndColor(designAndColor uint8) error {
+	return die.SendMsg(MessageSetDesignAndColor{DesignAndColor: designAndColor})
+}
+
+// Sleep puts the die to sleep, which also drops the connection
+func (die *Die) Sleep() error {
+	return die.SendMsg(MessageSleep{})
+}
+
+// Calibrate starts the die's face calibration routine
+func (die *Die) Calibrate() error {
+	return die.SendMsg(MessageCalibrate{})
+}
+
+// CalibrateFace calibrates the face currently facing up as faceIndex
+func (die *Die) CalibrateFace(faceIndex uint8) error {
+	return die.SendMsg(MessageCalibrateFace{FaceIndex: faceIndex})
+}
+
+// PlayAnimation plays an animation from the die's animation set
+func (die *Die) PlayAnimation(animation uint8, remoteFaceIndex uint8, loopCount uint8) error {
+	return die.SendMsg(MessagePlayAnimation{
+		Animation:       animation,
+		RemoteFaceIndex: remoteFaceIndex,
+		LoopCount:       loopCount,
+	})
+}
+
+// StopAnimation stops an animation started with PlayAnimation
+func (die *Die) StopAnimation(animation uint8, remoteFaceIndex uint8) error {
+	return die.SendMsg(MessageStopAnimation{
+		Animation:       animation,
+		RemoteFaceIndex: remoteFaceIndex,
+	})
+}
@@ -0,0 +1,180 @@
+package pixel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrDisconnected is returned by Connection.Run when the die is disconnected with Die.Disconnect
+var ErrDisconnected = errors.New("die disconnected")
+
+type ConnState uint8
+
+// Connection States
+const (
+	ConnStateDisconnected ConnState = iota
+	ConnStateConnecting
+	ConnStateConnected
+	ConnStateBackoff
+)
+
+var connStateNames = map[ConnState]string{
+	ConnStateDisconnected: "disconnected",
+	ConnStateConnecting:   "connecting",
+	ConnStateConnected:    "connected",
+	ConnStateBackoff:      "backoff",
+}
+
+func (s ConnState) String() string {
+	if name, ok := connStateNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Backoff controls the delay between reconnect attempts
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// DefaultBackoff starts retrying after half a second, doubling up to 30 seconds
+var DefaultBackoff = Backoff{
+	Initial:    500 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+}
+
+// Delay returns how long to wait before the given retry attempt, counting from 0
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if delay > float64(b.Max) {
+		return b.Max
+	}
+	return time.Duration(delay)
+}
+
+// Connection keeps a Die connected, redialing with exponential backoff whenever the link drops
+type Connection struct {
+	Die             *Die
+	Backoff         Backoff
+	IdentifyTimeout time.Duration
+
+	dial  DialFunc
+	mu    sync.Mutex
+	state ConnState
+}
+
+// NewConnection creates a managed connection for die using dial to (re)establish the transport
+func NewConnection(die *Die, dial DialFunc) *Connection {
+	return &Connection{
+		Die:             die,
+		Backoff:         DefaultBackoff,
+		IdentifyTimeout: 5 * time.Second,
+		dial:            dial,
+	}
+}
+
+// State returns the current connection state
+func (c *Connection) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Run connects the die and keeps it connected until ctx is done, then disconnects it.
+// If the die is already attached to a transport Run starts by watching that link.
+// Run returns ErrDisconnected, without reconnecting, once Die.Disconnect is called.
+func (c *Connection) Run(ctx context.Context) error {
+	requested := c.Die.disconnectRequested()
+	attempt := 0
+	for {
+		select {
+		case <-requested:
+			_ = c.Die.disconnect()
+			c.setState(ConnStateDisconnected)
+			return ErrDisconnected
+		default:
+		}
+
+		transport := c.Die.currentTransport()
+		if transport == nil {
+			var err error
+			c.setState(ConnStateConnecting)
+			transport, err = c.connect(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					c.setState(ConnStateDisconnected)
+					return ctx.Err()
+				}
+
+				delay := c.Backoff.Delay(attempt)
+				attempt++
+				log.Printf("die %d connect attempt %d failed, retrying in %s: %v", c.Die.PixelId(), attempt, delay, err)
+				c.setState(ConnStateBackoff)
+				select {
+				case <-ctx.Done():
+					c.setState(ConnStateDisconnected)
+					return ctx.Err()
+				case <-requested:
+				case <-time.After(delay):
+				}
+				continue
+			}
+		}
+
+		attempt = 0
+		c.setState(ConnStateConnected)
+
+		var dropped <-chan struct{}
+		if notifier, ok := transport.(DisconnectNotifier); ok {
+			dropped = notifier.Disconnected()
+		}
+		select {
+		case <-ctx.Done():
+			_ = c.Die.disconnect()
+			c.setState(ConnStateDisconnected)
+			return ctx.Err()
+		case <-requested:
+		case <-dropped:
+			c.Die.detach(transport)
+			c.setState(ConnStateDisconnected)
+		}
+	}
+}
+
+func (c *Connection) connect(ctx context.Context) (Transport, error) {
+	transport, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Die.attach(transport); err != nil {
+		_ = transport.Disconnect()
+		return nil, err
+	}
+
+	identifyCtx, cancel := context.WithTimeout(ctx, c.IdentifyTimeout)
+	defer cancel()
+	if _, err := c.Die.Identify(identifyCtx); err != nil {
+		_ = c.Die.disconnect()
+		return nil, fmt.Errorf("identify failed: %w", err)
+	}
+	return transport, nil
+}
+
+func (c *Connection) setState(state ConnState) {
+	c.mu.Lock()
+	changed := c.state != state
+	c.state = state
+	c.mu.Unlock()
+
+	if changed {
+		c.Die.emitConnState(state)
+	}
+}
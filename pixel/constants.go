@@ -10,7 +10,7 @@ const (
 
 // DieType
 const (
-	DieTypeUnknown = iota
+	DieTypeUnknown DieType = iota
 	DieTypeD4
 	DieTypeD6
 	DieTypeD8
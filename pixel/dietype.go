@@ -0,0 +1,130 @@
+package pixel
+
+type DieType uint8
+
+var dieTypeNames = map[DieType]string{
+	DieTypeUnknown:  "unknown",
+	DieTypeD4:       "d4",
+	DieTypeD6:       "d6",
+	DieTypeD8:       "d8",
+	DieTypeD10:      "d10",
+	DieTypeD00:      "d00",
+	DieTypeD12:      "d12",
+	DieTypeD20:      "d20",
+	DieTypeD6Pipped: "d6pipped",
+	DieTypeD6Fudge:  "d6fudge",
+}
+
+func (t DieType) String() string {
+	if name, ok := dieTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// FaceCount returns the number of faces of the die type, or 0 if it is unknown
+func (t DieType) FaceCount() int {
+	switch t {
+	case DieTypeD4:
+		return 4
+	case DieTypeD6, DieTypeD6Pipped, DieTypeD6Fudge:
+		return 6
+	case DieTypeD8:
+		return 8
+	case DieTypeD10, DieTypeD00:
+		return 10
+	case DieTypeD12:
+		return 12
+	case DieTypeD20:
+		return 20
+	}
+	return 0
+}
+
+// FaceValue maps a face index to the value the face counts as: 1-10 for a d10,
+// whose 0 face counts as 10, 00-90 for a percentile d00 and -1, 0 or +1 for a fudge die
+func (t DieType) FaceValue(faceIndex uint8) int {
+	switch t {
+	case DieTypeD10:
+		if faceIndex == 0 {
+			return 10
+		}
+		return int(faceIndex)
+	case DieTypeD00:
+		return int(faceIndex) * 10
+	case DieTypeD6Fudge:
+		return int(faceIndex%3) - 1
+	}
+	return int(faceIndex) + 1
+}
+
+// MinValue returns the lowest face value of the die type
+func (t DieType) MinValue() int {
+	switch t {
+	case DieTypeD00:
+		return 0
+	case DieTypeD6Fudge:
+		return -1
+	}
+	return 1
+}
+
+// MaxValue returns the highest face value of the die type
+func (t DieType) MaxValue() int {
+	switch t {
+	case DieTypeD00:
+		return 90
+	case DieTypeD6Fudge:
+		return 1
+	}
+	return t.FaceCount()
+}
+
+// PercentileValue combines the face values of a d00 and a d10 into a d100 roll of 1-100:
+// the d10's 10 counts as 0 here, and 00 with 0 is 100
+func PercentileValue(tens int, ones int) int {
+	value := tens + ones%10
+	if value == 0 {
+		return 100
+	}
+	return value
+}
+
+// DetectDieType derives the die type from the type reported by the firmware,
+// falling back to the LED count for firmware that does not report it. The LED
+// count cannot tell a d00 from a d10, so without a reported type both are d10s.
+func DetectDieType(reported uint8, ledCount uint8) DieType {
+	if reported > uint8(DieTypeUnknown) && reported <= uint8(DieTypeD6Fudge) {
+		return DieType(reported)
+	}
+
+	switch ledCount {
+	case 4:
+		return DieTypeD4
+	case 6:
+		return DieTypeD6
+	case 8:
+		return DieTypeD8
+	case 10:
+		return DieTypeD10
+	case 12:
+		return DieTypeD12
+	case 20:
+		return DieTypeD20
+	case 21:
+		return DieTypeD6Pipped
+	}
+	return DieTypeUnknown
+}
+
+// DieType returns the die's type, derived from what it reported about itself
+func (die *Die) DieType() DieType {
+	die.mu.RLock()
+	defer die.mu.RUnlock()
+	return die.dieType
+}
+
+// FaceCount returns the number of faces of the die, or 0 if its type is unknown
+func (die *Die) FaceCount() int {
+	return die.DieType().FaceCount()
+}
@@ -0,0 +1,92 @@
+package pixel
+
+import "testing"
+
+func TestFaceValue(t *testing.T) {
+	tests := []struct {
+		dieType DieType
+		faces   []int
+	}{
+		{DieTypeD4, []int{1, 2, 3, 4}},
+		{DieTypeD6, []int{1, 2, 3, 4, 5, 6}},
+		{DieTypeD6Pipped, []int{1, 2, 3, 4, 5, 6}},
+		{DieTypeD6Fudge, []int{-1, 0, 1, -1, 0, 1}},
+		{DieTypeD8, []int{1, 2, 3, 4, 5, 6, 7, 8}},
+		{DieTypeD10, []int{10, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{DieTypeD00, []int{0, 10, 20, 30, 40, 50, 60, 70, 80, 90}},
+		{DieTypeD12, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		{DieTypeD20, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dieType.String(), func(t *testing.T) {
+			if count := test.dieType.FaceCount(); count != len(test.faces) {
+				t.Errorf("FaceCount() = %d, want %d", count, len(test.faces))
+			}
+			lowest, highest := test.faces[0], test.faces[0]
+			for i, want := range test.faces {
+				if got := test.dieType.FaceValue(uint8(i)); got != want {
+					t.Errorf("FaceValue(%d) = %d, want %d", i, got, want)
+				}
+				lowest, highest = min(lowest, want), max(highest, want)
+			}
+			if test.dieType.MinValue() != lowest || test.dieType.MaxValue() != highest {
+				t.Errorf("values %d to %d, want %d to %d", test.dieType.MinValue(), test.dieType.MaxValue(), lowest, highest)
+			}
+		})
+	}
+
+	if count := DieTypeUnknown.FaceCount(); count != 0 {
+		t.Errorf("unknown FaceCount() = %d", count)
+	}
+}
+
+func TestPercentileValue(t *testing.T) {
+	tests := []struct {
+		tens, ones int
+		want       int
+	}{
+		{0, 1, 1},
+		{0, 9, 9},
+		{0, 10, 100},
+		{40, 10, 40},
+		{40, 7, 47},
+		{90, 9, 99},
+	}
+	for _, test := range tests {
+		if got := PercentileValue(test.tens, test.ones); got != test.want {
+			t.Errorf("PercentileValue(%d, %d) = %d, want %d", test.tens, test.ones, got, test.want)
+		}
+	}
+}
+
+func TestDetectDieType(t *testing.T) {
+	tests := []struct {
+		name     string
+		reported uint8
+		ledCount uint8
+		want     DieType
+	}{
+		{"reported d20", uint8(DieTypeD20), 20, DieTypeD20},
+		{"reported d00", uint8(DieTypeD00), 10, DieTypeD00},
+		{"reported d10", uint8(DieTypeD10), 10, DieTypeD10},
+		{"reported fudge", uint8(DieTypeD6Fudge), 6, DieTypeD6Fudge},
+		{"reported type wins over LEDs", uint8(DieTypeD8), 20, DieTypeD8},
+		{"out of range report", 99, 12, DieTypeD12},
+		{"4 LEDs", 0, 4, DieTypeD4},
+		{"6 LEDs", 0, 6, DieTypeD6},
+		{"8 LEDs", 0, 8, DieTypeD8},
+		{"10 LEDs", 0, 10, DieTypeD10},
+		{"12 LEDs", 0, 12, DieTypeD12},
+		{"20 LEDs", 0, 20, DieTypeD20},
+		{"21 LEDs is a pipped d6", 0, 21, DieTypeD6Pipped},
+		{"unknown LED count", 0, 7, DieTypeUnknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DetectDieType(test.reported, test.ledCount); got != test.want {
+				t.Errorf("DetectDieType(%d, %d) = %s, want %s", test.reported, test.ledCount, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package pixel
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrShortMessage is returned when a notification is shorter than its message type requires
+	ErrShortMessage = errors.New("message too short")
+	// ErrUnexpectedType is returned when a parser is handed a message of another type
+	ErrUnexpectedType = errors.New("unexpected message type")
+	// ErrUnknownMessage is returned for message types the decoder does not understand
+	ErrUnknownMessage = errors.New("unknown message type")
+)
+
+// checkMessage verifies that buf is one of the given message types and at least size bytes long
+func checkMessage(buf []byte, size int, msgTypes ...uint8) error {
+	if len(buf) == 0 {
+		return fmt.Errorf("%w: empty buffer", ErrShortMessage)
+	}
+
+	expected := false
+	for _, msgType := range msgTypes {
+		if buf[0] == msgType {
+			expected = true
+			break
+		}
+	}
+	if !expected {
+		return fmt.Errorf("%w: got %d, want %v", ErrUnexpectedType, buf[0], msgTypes)
+	}
+
+	if len(buf) < size {
+		return fmt.Errorf("%w: type %d is %d bytes, want %d", ErrShortMessage, buf[0], len(buf), size)
+	}
+	return nil
+}
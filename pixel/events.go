@@ -0,0 +1,158 @@
+package pixel
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType uint8
+
+// Event Types
+const (
+	EventRollStarted EventType = iota
+	EventRolled
+	EventCrooked
+	EventBatteryChanged
+	EventConnected
+	EventDisconnected
+	EventMessage
+	EventError
+	EventConnectionState
+)
+
+var eventTypeNames = map[EventType]string{
+	EventRollStarted:     "roll_started",
+	EventRolled:          "rolled",
+	EventCrooked:         "crooked",
+	EventBatteryChanged:  "battery_changed",
+	EventConnected:       "connected",
+	EventDisconnected:    "disconnected",
+	EventMessage:         "message",
+	EventError:           "error",
+	EventConnectionState: "connection_state",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Event is a state change reported by a Die, carrying the die's state at the time of the change
+type Event struct {
+	DieState
+	Type EventType
+	Die  *Die
+	Time time.Time
+	// Message is the decoded message for EventMessage, e.g. MessageRssi or MessageTelemetry
+	Message any
+	// Err is the decoding error for EventError, wrapping ErrShortMessage, ErrUnexpectedType or ErrUnknownMessage
+	Err error
+	// ConnState is the new state of a managed Connection for EventConnectionState
+	ConnState ConnState
+}
+
+// subscribers fans values out to buffered channels without ever blocking the publisher
+type subscribers[T any] struct {
+	mu     sync.Mutex
+	nextId int
+	chans  map[int]chan T
+}
+
+func (subs *subscribers[T]) subscribe(buffer int) (<-chan T, func()) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	if subs.chans == nil {
+		subs.chans = make(map[int]chan T)
+	}
+	id := subs.nextId
+	subs.nextId++
+	ch := make(chan T, buffer)
+	subs.chans[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			subs.mu.Lock()
+			defer subs.mu.Unlock()
+			delete(subs.chans, id)
+			close(ch)
+		})
+	}
+}
+
+func (subs *subscribers[T]) publish(value T) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	for _, ch := range subs.chans {
+		select {
+		case ch <- value:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the die's events and a function to cancel the subscription.
+// Delivery never blocks the die: events are dropped for a subscriber whose buffer is full.
+func (die *Die) Subscribe(buffer int) (<-chan Event, func()) {
+	return die.subscribers.subscribe(buffer)
+}
+
+// emit must be called with die.mu held
+func (die *Die) emit(eventType EventType) {
+	die.publish(Event{
+		DieState: die.snapshot(),
+		Type:     eventType,
+		Die:      die,
+		Time:     time.Now(),
+	})
+}
+
+// emitMessage must be called with die.mu held
+func (die *Die) emitMessage(msg any) {
+	die.publish(Event{
+		DieState: die.snapshot(),
+		Type:     EventMessage,
+		Die:      die,
+		Time:     time.Now(),
+		Message:  msg,
+	})
+}
+
+// emitError must be called with die.mu held
+func (die *Die) emitError(err error) {
+	die.publish(Event{
+		DieState: die.snapshot(),
+		Type:     EventError,
+		Die:      die,
+		Time:     time.Now(),
+		Err:      err,
+	})
+}
+
+func (die *Die) emitConnState(state ConnState) {
+	die.mu.RLock()
+	defer die.mu.RUnlock()
+	die.publish(Event{
+		DieState:  die.snapshot(),
+		Type:      EventConnectionState,
+		Die:       die,
+		Time:      time.Now(),
+		ConnState: state,
+	})
+}
+
+func (die *Die) publish(evt Event) {
+	die.subscribers.publish(evt)
+}
+
+// IsRolling reports whether a RollState* value means the die is being handled or rolled
+func IsRolling(rollState uint8) bool {
+	return rollState == RollStateHandling || rollState == RollStateRolling
+}
+
+func isSettled(rollState uint8) bool {
+	return rollState == RollStateOnFace || rollState == RollStateRolled
+}
@@ -0,0 +1,327 @@
+package pixel
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"tinygo.org/x/bluetooth"
+)
+
+type ManagerEventType uint8
+
+// Manager Event Types
+const (
+	ManagerEventDiscovered ManagerEventType = iota
+	ManagerEventLost
+)
+
+func (t ManagerEventType) String() string {
+	switch t {
+	case ManagerEventDiscovered:
+		return "discovered"
+	case ManagerEventLost:
+		return "lost"
+	}
+	return "unknown"
+}
+
+// ManagerEvent reports a die joining or leaving the set of dice a Manager can reach.
+// Each die is discovered and lost once; its reconnects are reported by the die's own
+// EventConnected and EventDisconnected events.
+type ManagerEvent struct {
+	Type    ManagerEventType
+	Die     *Die
+	PixelId uint32
+	Name    string
+	Time    time.Time
+}
+
+// ManagerOptions configures which dice a Manager connects to
+type ManagerOptions struct {
+	// AllowIds and AllowNames, when not empty, restrict the manager to the listed dice
+	AllowIds   []uint32
+	AllowNames []string
+	// DenyIds and DenyNames exclude dice even if they are allowed
+	DenyIds   []uint32
+	DenyNames []string
+	// MaxConnections caps the number of dice held at once, 0 means no limit
+	MaxConnections int
+	Backoff        Backoff
+	// Passive tracks dice from their advertisements only, without connecting.
+	// Passive dice not seen for PassiveTimeout are dropped.
+	Passive        bool
+	PassiveTimeout time.Duration
+}
+
+// Manager scans for Pixel dice, connects to the allowed ones and keeps them
+// in a Registry keyed by PixelId
+type Manager struct {
+	adapter  *bluetooth.Adapter
+	opts     ManagerOptions
+	registry *Registry
+	events   subscribers[ManagerEvent]
+
+	mu        sync.Mutex
+	addresses map[string]bool
+	ignored   map[string]bool
+	managed   map[uint32]*managedDie
+}
+
+type managedDie struct {
+	address string
+	cancel  context.CancelFunc
+}
+
+// NewManager creates a dice manager scanning with adapter
+func NewManager(adapter *bluetooth.Adapter, opts ManagerOptions) *Manager {
+	if opts.Backoff == (Backoff{}) {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.PassiveTimeout == 0 {
+		opts.PassiveTimeout = 30 * time.Second
+	}
+	return &Manager{
+		adapter:   adapter,
+		opts:      opts,
+		registry:  NewRegistry(),
+		addresses: make(map[string]bool),
+		ignored:   make(map[string]bool),
+		managed:   make(map[uint32]*managedDie),
+	}
+}
+
+// Registry returns the registry holding the managed dice
+func (m *Manager) Registry() *Registry {
+	return m.registry
+}
+
+// Get returns the managed die with the given ID
+func (m *Manager) Get(id uint32) (*Die, bool) {
+	return m.registry.Get(id)
+}
+
+// List returns the managed dice ordered by PixelId
+func (m *Manager) List() []*Die {
+	return m.registry.List()
+}
+
+// Events returns a channel of discovered and lost events and a function to cancel the subscription
+func (m *Manager) Events(buffer int) (<-chan ManagerEvent, func()) {
+	return m.events.subscribe(buffer)
+}
+
+// Add registers an already connected die, e.g. a simulated one, with the manager
+func (m *Manager) Add(die *Die) {
+	m.track(die, &managedDie{})
+}
+
+// Remove disconnects the die with the given ID and drops it from the registry
+func (m *Manager) Remove(id uint32) {
+	m.mu.Lock()
+	managed, ok := m.managed[id]
+	delete(m.managed, id)
+	if ok && managed.address != "" {
+		delete(m.addresses, managed.address)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	die, _ := m.registry.Get(id)
+	if managed.cancel != nil {
+		managed.cancel()
+	} else {
+		_ = die.Disconnect()
+	}
+	m.registry.Remove(id)
+	m.publish(ManagerEventLost, die)
+}
+
+// Run scans for dice until ctx is done, connecting to every allowed die it finds.
+// Connected dice are kept connected, reconnecting with the configured backoff.
+func (m *Manager) Run(ctx context.Context) error {
+	watchAdapter(m.adapter)
+	go func() {
+		<-ctx.Done()
+		_ = m.adapter.StopScan()
+	}()
+	if m.opts.Passive {
+		go m.expirePassive(ctx)
+	}
+
+	for ctx.Err() == nil {
+		err := m.adapter.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
+			if result.HasServiceUUID(pixelServiceUuid) {
+				m.handleScanResult(ctx, result)
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("scan failed: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+func (m *Manager) handleScanResult(ctx context.Context, result bluetooth.ScanResult) {
+	if m.opts.Passive {
+		m.handleAdvertisement(result)
+		return
+	}
+
+	address := result.Address.String()
+	name := result.LocalName()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.addresses[address] || m.ignored[address] || !m.nameAllowed(name) {
+		return
+	}
+	if m.opts.MaxConnections > 0 && len(m.addresses) >= m.opts.MaxConnections {
+		return
+	}
+	m.addresses[address] = true
+	go m.connect(ctx, result.Address, name)
+}
+
+func (m *Manager) handleAdvertisement(result bluetooth.ScanResult) {
+	adv, err := ParseAdvertisement(result)
+	if err != nil {
+		return
+	}
+	if die, ok := m.registry.Get(adv.PixelId); ok {
+		die.ApplyAdvertisement(adv)
+		return
+	}
+
+	m.mu.Lock()
+	allowed := m.nameAllowed(adv.Name) && m.idAllowed(adv.PixelId)
+	m.mu.Unlock()
+	if allowed {
+		m.track(NewPassiveDie(adv), &managedDie{})
+	}
+}
+
+func (m *Manager) expirePassive(ctx context.Context) {
+	ticker := time.NewTicker(m.opts.PassiveTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, state := range m.registry.Snapshots() {
+				if time.Since(state.LastSeen) > m.opts.PassiveTimeout {
+					m.Remove(state.PixelId)
+				}
+			}
+		}
+	}
+}
+
+func (m *Manager) connect(ctx context.Context, address bluetooth.Address, name string) {
+	die := &Die{name: name}
+	conn := NewConnection(die, BLEDialer(m.adapter, address))
+	conn.Backoff = m.opts.Backoff
+	if _, err := conn.connect(ctx); err != nil {
+		log.Printf("connecting to %s failed: %v", address, err)
+		m.forget(address.String())
+		return
+	}
+
+	id := die.PixelId()
+	if _, exists := m.registry.Get(id); exists || !m.idAllowed(id) {
+		_ = die.Disconnect()
+		m.forget(address.String())
+		if !exists {
+			m.ignore(address.String())
+		}
+		return
+	}
+
+	connCtx, cancel := context.WithCancel(ctx)
+	m.track(die, &managedDie{address: address.String(), cancel: cancel})
+	go func() {
+		err := conn.Run(connCtx)
+		m.Remove(id)
+		if errors.Is(err, ErrDisconnected) {
+			// the die was disconnected on purpose, so scanning must not dial it again
+			m.ignore(address.String())
+		}
+	}()
+}
+
+func (m *Manager) track(die *Die, managed *managedDie) {
+	id := die.PixelId()
+	m.mu.Lock()
+	m.managed[id] = managed
+	m.mu.Unlock()
+	m.registry.Add(die)
+	m.publish(ManagerEventDiscovered, die)
+}
+
+func (m *Manager) forget(address string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.addresses, address)
+}
+
+// ignore stops a denied die's address from being dialed again
+func (m *Manager) ignore(address string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ignored[address] = true
+}
+
+func (m *Manager) publish(eventType ManagerEventType, die *Die) {
+	state := die.Snapshot()
+	m.events.publish(ManagerEvent{
+		Type:    eventType,
+		Die:     die,
+		PixelId: state.PixelId,
+		Name:    state.Name,
+		Time:    time.Now(),
+	})
+}
+
+func (m *Manager) idAllowed(id uint32) bool {
+	for _, denied := range m.opts.DenyIds {
+		if denied == id {
+			return false
+		}
+	}
+	if len(m.opts.AllowIds) == 0 {
+		return true
+	}
+	for _, allowed := range m.opts.AllowIds {
+		if allowed == id {
+			return true
+		}
+	}
+	return false
+}
+
+// nameAllowed must be called with m.mu held
+func (m *Manager) nameAllowed(name string) bool {
+	for _, denied := range m.opts.DenyNames {
+		if denied == name {
+			return false
+		}
+	}
+	if len(m.opts.AllowNames) == 0 {
+		return true
+	}
+	for _, allowed := range m.opts.AllowNames {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package pixel_test
+
+import (
+	"context"
+	"errors"
+	"godice/pixel"
+	"godice/pixel/sim"
+	"testing"
+	"time"
+)
+
+func TestManagerEventsOncePerDie(t *testing.T) {
+	simDie, die := connectSim(t, sim.Options{PixelId: 0x42, DieType: pixel.DieTypeD20})
+	manager := pixel.NewManager(nil, pixel.ManagerOptions{})
+	managerEvents, unsubscribe := manager.Events(8)
+	defer unsubscribe()
+	dieEvents, stop := die.Subscribe(16)
+	defer stop()
+
+	conn := pixel.NewConnection(die, simDie.Dial)
+	conn.Backoff = pixel.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	go func() {
+		_ = conn.Run(ctx)
+		close(running)
+	}()
+	defer func() {
+		cancel()
+		<-running
+	}()
+
+	manager.Add(die)
+	if evt := <-managerEvents; evt.Type != pixel.ManagerEventDiscovered || evt.PixelId != 0x42 || evt.Die != die {
+		t.Fatalf("first manager event = %+v, want the die discovered", evt)
+	}
+
+	for i := 0; i < 2; i++ {
+		simDie.Drop()
+		nextEvent(t, dieEvents, pixel.EventDisconnected)
+		nextEvent(t, dieEvents, pixel.EventConnected)
+	}
+	select {
+	case evt := <-managerEvents:
+		t.Fatalf("reconnecting published %s", evt.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	<-running
+	manager.Remove(0x42)
+	manager.Remove(0x42)
+	if evt := <-managerEvents; evt.Type != pixel.ManagerEventLost || evt.PixelId != 0x42 {
+		t.Fatalf("manager event after Remove = %+v, want the die lost", evt)
+	}
+	select {
+	case evt := <-managerEvents:
+		t.Fatalf("unexpected %s after the die was lost", evt.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, ok := manager.Get(0x42); ok {
+		t.Error("the removed die is still registered")
+	}
+}
+
+func TestConnectionStopsOnDisconnect(t *testing.T) {
+	simDie, die := connectSim(t, sim.Options{PixelId: 0x43, DieType: pixel.DieTypeD6})
+	events, stop := die.Subscribe(16)
+	defer stop()
+
+	conn := pixel.NewConnection(die, simDie.Dial)
+	conn.Backoff = pixel.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1}
+	result := make(chan error, 1)
+	go func() { result <- conn.Run(context.Background()) }()
+
+	// a dropped link is redialed, a disconnect asked for is not
+	simDie.Drop()
+	nextEvent(t, events, pixel.EventDisconnected)
+	nextEvent(t, events, pixel.EventConnected)
+	if err := die.Disconnect(); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+	select {
+	case err := <-result:
+		if !errors.Is(err, pixel.ErrDisconnected) {
+			t.Errorf("Run = %v, want ErrDisconnected", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run kept the die connected after Disconnect")
+	}
+	if state := conn.State(); state != pixel.ConnStateDisconnected {
+		t.Errorf("state = %s", state)
+	}
+	for len(events) > 0 {
+		if evt := <-events; evt.Type == pixel.EventConnected {
+			t.Error("the die reconnected after Disconnect")
+		}
+	}
+}
+
+func TestConnectionStopsOnDisconnectWhileRedialing(t *testing.T) {
+	die := &pixel.Die{}
+	conn := pixel.NewConnection(die, func(ctx context.Context) (pixel.Transport, error) {
+		return nil, errors.New("out of range")
+	})
+	conn.Backoff = pixel.Backoff{Initial: time.Minute, Max: time.Minute, Multiplier: 1}
+	result := make(chan error, 1)
+	go func() { result <- conn.Run(context.Background()) }()
+
+	for conn.State() != pixel.ConnStateBackoff {
+		time.Sleep(time.Millisecond)
+	}
+	_ = die.Disconnect()
+	select {
+	case err := <-result:
+		if !errors.Is(err, pixel.ErrDisconnected) {
+			t.Errorf("Run = %v, want ErrDisconnected", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run kept redialing after Disconnect")
+	}
+}
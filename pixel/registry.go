@@ -0,0 +1,73 @@
+package pixel
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry is a concurrency-safe collection of dice keyed by PixelId
+type Registry struct {
+	mu   sync.RWMutex
+	dice map[uint32]*Die
+}
+
+// NewRegistry creates an empty dice registry
+func NewRegistry() *Registry {
+	return &Registry{dice: make(map[uint32]*Die)}
+}
+
+// Add registers a die under its current PixelId, replacing any die with the same ID
+func (r *Registry) Add(die *Die) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dice[die.PixelId()] = die
+}
+
+// Remove drops the die with the given ID
+func (r *Registry) Remove(id uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.dice, id)
+}
+
+// Get returns the die with the given ID
+func (r *Registry) Get(id uint32) (*Die, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	die, ok := r.dice[id]
+	return die, ok
+}
+
+// Len returns the number of registered dice
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.dice)
+}
+
+// List returns the registered dice ordered by PixelId
+func (r *Registry) List() []*Die {
+	r.mu.RLock()
+	ids := make([]uint32, 0, len(r.dice))
+	for id := range r.dice {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	dice := make([]*Die, 0, len(ids))
+	for _, id := range ids {
+		dice = append(dice, r.dice[id])
+	}
+	r.mu.RUnlock()
+	return dice
+}
+
+// Snapshots returns the state of every registered die ordered by PixelId
+func (r *Registry) Snapshots() []DieState {
+	dice := r.List()
+	states := make([]DieState, 0, len(dice))
+	for _, die := range dice {
+		states = append(states, die.Snapshot())
+	}
+	return states
+}
@@ -0,0 +1,141 @@
+package pixel
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"time"
+)
+
+// TimeoutError is returned by Request when the reply does not arrive before the context is done
+type TimeoutError struct {
+	Request uint8
+	Reply   uint8
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("no reply of type %d to message %d: %v", e.Reply, e.Request, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Request sends msg and blocks until the die answers with a message of replyType,
+// returning the decoded reply, or a *TimeoutError once ctx is done.
+func (die *Die) Request(ctx context.Context, msg TxMessage, replyType uint8) (any, error) {
+	ch := make(chan any, 1)
+	die.mu.Lock()
+	if die.waiters == nil {
+		die.waiters = make(map[uint8][]chan any)
+	}
+	die.waiters[replyType] = append(die.waiters[replyType], ch)
+	die.mu.Unlock()
+	defer die.removeWaiter(replyType, ch)
+
+	buf := msg.ToBuffer()
+	if err := die.SendMsg(msg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-ch:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, &TimeoutError{Request: buf[0], Reply: replyType, Err: ctx.Err()}
+	}
+}
+
+// Identify asks the die who it is and waits for the answer
+func (die *Die) Identify(ctx context.Context) (MessageIAmADie, error) {
+	return requestAs[MessageIAmADie](ctx, die, MessageWhoAreYou{}, MsgTypeIAmADie)
+}
+
+// QueryRollState requests the die's roll state and waits for it
+func (die *Die) QueryRollState(ctx context.Context) (MessageRollState, error) {
+	return requestAs[MessageRollState](ctx, die, MessageRequestRollState{}, MsgTypeRollState)
+}
+
+// QueryBatteryLevel requests the die's battery level and waits for it
+func (die *Die) QueryBatteryLevel(ctx context.Context) (MessageBatteryLevel, error) {
+	return requestAs[MessageBatteryLevel](ctx, die, MessageRequestBatteryLevel{}, MsgTypeBatteryLevel)
+}
+
+// QueryRssi requests the signal strength seen by the die and waits for it
+func (die *Die) QueryRssi(ctx context.Context) (MessageRssi, error) {
+	msg := MessageRequestRssi{RequestMode: TelemetryRequestModeOnce}
+	return requestAs[MessageRssi](ctx, die, msg, MsgTypeRssi)
+}
+
+// QueryTemperature requests the die's temperatures and waits for them
+func (die *Die) QueryTemperature(ctx context.Context) (MessageTemperature, error) {
+	return requestAs[MessageTemperature](ctx, die, MessageRequestTemperature{}, MsgTypeTemperature)
+}
+
+// BlinkAndWait flashes the given faces of the die and waits for the die to acknowledge it.
+// Durations over 65.535 seconds are clamped.
+func (die *Die) BlinkAndWait(ctx context.Context, c color.RGBA, count uint8, duration time.Duration, faceMask uint32) error {
+	msg := MessageBlink{
+		Count:    count,
+		Duration: milliseconds16(duration),
+		Color:    c,
+		FaceMask: faceMask,
+		Fade:     128,
+	}
+	_, err := die.Request(ctx, msg, MsgTypeBlinkAck)
+	return err
+}
+
+// SetNameAndWait renames the die and waits for the die to acknowledge it
+func (die *Die) SetNameAndWait(ctx context.Context, name string) error {
+	msg := MessageSetName{Name: name}
+	if _, err := die.Request(ctx, msg, MsgTypeSetNameAck); err != nil {
+		return err
+	}
+
+	die.mu.Lock()
+	defer die.mu.Unlock()
+	die.name = parseCString(msg.ToBuffer()[1:])
+	return nil
+}
+
+func requestAs[T any](ctx context.Context, die *Die, msg TxMessage, replyType uint8) (T, error) {
+	var zero T
+	reply, err := die.Request(ctx, msg, replyType)
+	if err != nil {
+		return zero, err
+	}
+	typed, ok := reply.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: reply %T", ErrUnexpectedType, reply)
+	}
+	return typed, nil
+}
+
+// resolveWaiters must be called with die.mu held
+func (die *Die) resolveWaiters(msgType uint8, msg any) {
+	for _, ch := range die.waiters[msgType] {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	delete(die.waiters, msgType)
+}
+
+func (die *Die) removeWaiter(msgType uint8, ch chan any) {
+	die.mu.Lock()
+	defer die.mu.Unlock()
+
+	waiters := die.waiters[msgType]
+	for i, waiter := range waiters {
+		if waiter == ch {
+			die.waiters[msgType] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(die.waiters[msgType]) == 0 {
+		delete(die.waiters, msgType)
+	}
+}
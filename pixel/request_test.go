@@ -0,0 +1,95 @@
+package pixel_test
+
+import (
+	"context"
+	"godice/pixel"
+	"godice/pixel/sim"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentUse hammers a die from many goroutines at once; run it with -race
+func TestConcurrentUse(t *testing.T) {
+	simDie, die := connectSim(t, sim.Options{PixelId: 0x77, DieType: pixel.DieTypeD6, BatteryLevel: 60, Rssi: -55})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	const workers, rounds = 8, 50
+	var wg sync.WaitGroup
+	run := func(work func(worker int, round int)) {
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				for round := 0; round < rounds; round++ {
+					work(worker, round)
+				}
+			}(w)
+		}
+	}
+
+	run(func(worker int, round int) {
+		events, stop := die.Subscribe(4)
+		select {
+		case <-events:
+		default:
+		}
+		stop()
+		stop()
+	})
+	run(func(worker int, round int) {
+		if state := die.Snapshot(); state.PixelId != 0x77 || state.DieType != pixel.DieTypeD6 {
+			t.Errorf("snapshot = %+v", state)
+		}
+	})
+	run(func(worker int, round int) {
+		switch (worker + round) % 4 {
+		case 0:
+			if msg, err := die.Identify(ctx); err != nil || msg.PixelId != 0x77 {
+				t.Errorf("Identify = %+v, %v", msg, err)
+			}
+		case 1:
+			if _, err := die.QueryBatteryLevel(ctx); err != nil {
+				t.Errorf("QueryBatteryLevel: %v", err)
+			}
+		case 2:
+			if _, err := die.QueryRollState(ctx); err != nil {
+				t.Errorf("QueryRollState: %v", err)
+			}
+		case 3:
+			if msg, err := die.QueryRssi(ctx); err != nil || msg.Value != -55 {
+				t.Errorf("QueryRssi = %+v, %v", msg, err)
+			}
+		}
+	})
+	run(func(worker int, round int) {
+		switch worker % 3 {
+		case 0:
+			simDie.RollValue(round%6 + 1)
+		case 1:
+			simDie.SetBattery(uint8(round), round%2 == 0)
+		default:
+			_ = die.RequestRollState()
+		}
+	})
+
+	// a subscriber that lives through the whole run must keep receiving events once it has room again
+	events, stop := die.Subscribe(16)
+	defer stop()
+	wg.Wait()
+	for len(events) > 0 {
+		<-events
+	}
+	simDie.RollValue(4)
+	for {
+		select {
+		case evt := <-events:
+			if evt.Type == pixel.EventRolled && evt.CurrentFaceValue == 4 {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("the long lived subscriber missed the last roll")
+		}
+	}
+}
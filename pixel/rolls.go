@@ -6,22 +6,60 @@ type MessageRollState struct {
 	Id               uint8
 	RollState        uint8
 	CurrentFaceIndex uint8
-	CurrentFaceValue uint8
 }
 
-func parseRollStateMessage(buf []byte) MessageRollState {
+func parseRollStateMessage(buf []byte) (MessageRollState, error) {
+	if err := checkMessage(buf, 3, MsgTypeRollState); err != nil {
+		return MessageRollState{}, err
+	}
 	msg := MessageRollState{
 		Id:               buf[0],
 		RollState:        buf[1],
 		CurrentFaceIndex: buf[2],
-		CurrentFaceValue: buf[2] + 1,
 	}
-	return msg
+	return msg, nil
+}
+
+func (msg MessageRollState) ToBuffer() []byte {
+	return []byte{MsgTypeRollState, msg.RollState, msg.CurrentFaceIndex}
 }
 
 func (die *Die) readRollStateMessage(msg MessageRollState) {
+	wasRolling := IsRolling(die.rollState)
 	die.rollState = msg.RollState
-	die.CurrentFaceIndex = msg.CurrentFaceIndex
-	die.CurrentFaceValue = msg.CurrentFaceValue
-	die.LastRolled = time.Now()
+
+	switch {
+	case IsRolling(msg.RollState):
+		if !wasRolling {
+			die.emit(EventRollStarted)
+		}
+	case isSettled(msg.RollState):
+		die.currentFaceIndex = msg.CurrentFaceIndex
+		die.currentFaceValue = die.dieType.FaceValue(msg.CurrentFaceIndex)
+		die.lastRolled = time.Now()
+		if wasRolling {
+			die.emit(EventRolled)
+		}
+	case msg.RollState == RollStateCrooked:
+		if wasRolling {
+			die.emit(EventCrooked)
+		}
+	}
+}
+
+var rollStateNames = map[uint8]string{
+	RollStateUnknown:  "unknown",
+	RollStateRolled:   "rolled",
+	RollStateHandling: "handling",
+	RollStateRolling:  "rolling",
+	RollStateCrooked:  "crooked",
+	RollStateOnFace:   "on_face",
+}
+
+// RollStateName returns the snake_case name of a RollState* value
+func RollStateName(state uint8) string {
+	if name, ok := rollStateNames[state]; ok {
+		return name
+	}
+	return "unknown"
 }
@@ -1,8 +1,11 @@
 package pixel
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
+	"sync"
 	"time"
 	"tinygo.org/x/bluetooth"
 )
@@ -11,101 +14,139 @@ var pixelServiceUuid, _ = bluetooth.ParseUUID(PixelsService)
 var notifyCharacterUuid, _ = bluetooth.ParseUUID(PixelNotifyCharacteristic)
 var writeCharacteristicUUid, _ = bluetooth.ParseUUID(PixelWriteCharacteristic)
 
+// Die is a connected Pixel die. Its state is updated from the transport's
+// notification goroutine and is read through Snapshot.
 type Die struct {
-	device           bluetooth.Device
-	writeChar        bluetooth.DeviceCharacteristic
-	notifyChar       bluetooth.DeviceCharacteristic
-	ledCount         uint8
-	PixelId          uint32
-	CurrentFaceIndex uint8
-	CurrentFaceValue uint8
-	rollState        uint8
-	batteryLevel     uint8
-	batteryCharging  bool
-	buildTimestamp   uint32
-	designAndColor   uint8
-	LastRolled       time.Time
+	mu                         sync.RWMutex
+	transport                  Transport
+	ledCount                   uint8
+	pixelId                    uint32
+	currentFaceIndex           uint8
+	currentFaceValue           int
+	rollState                  uint8
+	batteryLevel               uint8
+	batteryCharging            bool
+	buildTimestamp             uint32
+	designAndColor             uint8
+	dieType                    DieType
+	name                       string
+	rssi                       int8
+	mcuTemperatureTimes100     int16
+	batteryTemperatureTimes100 int16
+	lastRolled                 time.Time
+	lastSeen                   time.Time
+	subscribers                subscribers[Event]
+	waiters                    map[uint8][]chan any
+	// disconnectRequest is closed by Disconnect to stop the Connection running the die
+	disconnectRequest chan struct{}
 }
 
-func WatchForDice(adapter *bluetooth.Adapter, dieChan chan<- *Die) {
+// WatchForDice connects to every Pixel die found while scanning and sends it to dieChan once identified.
+// Each die is kept connected by a Connection until ctx is done, when scanning stops and WatchForDice returns.
+func WatchForDice(ctx context.Context, adapter *bluetooth.Adapter, dieChan chan<- *Die) {
+	var mu sync.Mutex
 	seenPixelDice := make(map[string]bool)
-	adapter.SetConnectHandler(func(device bluetooth.Device, connected bool) {
-		seenPixelDice[device.Address.String()] = connected
-		fmt.Printf("Device %s status: %v\n", device.Address, connected)
+	watchAdapter(adapter).onConnect(func(device bluetooth.Device, connected bool) {
+		slog.Debug("device connection changed", "address", device.Address.String(), "connected", connected)
 	})
+	go func() {
+		<-ctx.Done()
+		_ = adapter.StopScan()
+	}()
 
-	for {
+	for ctx.Err() == nil {
 		devCh := make(chan bluetooth.ScanResult, 1)
 		err := adapter.Scan(func(adapter *bluetooth.Adapter, device bluetooth.ScanResult) {
 			if !device.HasServiceUUID(pixelServiceUuid) {
 				return
 			}
-			connected := seenPixelDice[device.Address.String()]
-			println(fmt.Sprintf("scanned: %v", device.Address))
-			if !connected {
-				println(fmt.Sprintf("Found device: %s", device.Address))
-				devCh <- device
+			mu.Lock()
+			managed := seenPixelDice[device.Address.String()]
+			mu.Unlock()
+			slog.Debug("scanned", "address", device.Address.String())
+			if !managed {
+				log.Printf("found device %s", device.Address)
+				select {
+				case devCh <- device:
+				default:
+				}
 				adapter.StopScan()
-				//time.Sleep(100 * time.Millisecond)
 			}
 		})
-		if err != nil {
-			println(fmt.Errorf("scan failed: %v", err))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("scan failed: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
 		}
 
-		device := <-devCh
-		connected := seenPixelDice[device.Address.String()]
-		//println(fmt.Sprintf("connected: %v", connected))
-		if connected {
+		var device bluetooth.ScanResult
+		select {
+		case device = <-devCh:
+		case <-ctx.Done():
+			return
+		}
+		mu.Lock()
+		managed := seenPixelDice[device.Address.String()]
+		mu.Unlock()
+		if managed {
 			continue
 		}
-		result, err := adapter.Connect(device.Address, bluetooth.ConnectionParams{})
-		if err != nil {
-			println(fmt.Errorf("connection failed: %v", err))
+
+		die := &Die{}
+		conn := NewConnection(die, BLEDialer(adapter, device.Address))
+		if _, err := conn.connect(ctx); err != nil {
+			log.Printf("connecting to %s failed: %v", device.Address, err)
+			continue
+		}
+		log.Printf("connected device %s", device.Address)
+
+		mu.Lock()
+		seenPixelDice[device.Address.String()] = true
+		mu.Unlock()
+		go func(address string) {
+			_ = conn.Run(ctx)
+			mu.Lock()
+			delete(seenPixelDice, address)
+			mu.Unlock()
+		}(device.Address.String())
+		select {
+		case dieChan <- die:
+		case <-ctx.Done():
 			return
 		}
-		die, _ := ConnectDev(&result, 5*time.Second)
-		dieChan <- die
+	}
+}
 
-		//println(fmt.Sprintf("Connect device: %s", result.Address))
-		time.Sleep(3 * time.Second)
+// NewDie creates a Die on top of an already connected transport
+func NewDie(transport Transport) (*Die, error) {
+	die := &Die{}
+	if err := die.attach(transport); err != nil {
+		return nil, err
 	}
+	return die, nil
 }
 
 func ConnectDev(device *bluetooth.Device, timeout time.Duration) (*Die, error) {
-	var die Die
-	die.device = *device
-
-	services, err := device.DiscoverServices([]bluetooth.UUID{pixelServiceUuid})
+	transport, err := NewBLETransport(*device)
 	if err != nil {
-		return nil, fmt.Errorf("service discovery failed: %v", err)
+		return nil, err
 	}
 
-	for _, service := range services {
-		if service.UUID().String() != PixelsService {
-			continue
-		}
-
-		chars, _ := service.DiscoverCharacteristics([]bluetooth.UUID{notifyCharacterUuid, writeCharacteristicUUid})
-		for _, char := range chars {
-			if char.UUID().String() == PixelNotifyCharacteristic {
-				die.notifyChar = char
-				err = die.notifyChar.EnableNotifications(die.PixelCharacteristicReceiver)
-			} else if char.UUID().String() == PixelWriteCharacteristic {
-				die.writeChar = char
-			}
-		}
+	die, err := NewDie(transport)
+	if err != nil {
+		return nil, err
 	}
 
-	_ = die.SendMsg(MessageWhoAreYou{})
-	end := time.Now().Add(timeout)
-	for time.Now().Before(end) {
-		if die.PixelId != 0 {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := die.Identify(ctx); err != nil {
+		_ = die.Disconnect()
+		return nil, err
 	}
-	return &die, nil
+	return die, nil
 }
 
 func (die *Die) Connect(adapter *bluetooth.Adapter) error {
@@ -122,68 +163,107 @@ func (die *Die) Connect(adapter *bluetooth.Adapter) error {
 	}
 
 	result := <-ch
-	device, err := adapter.Connect(result.Address, bluetooth.ConnectionParams{})
+	transport, err := BLEDialer(adapter, result.Address)(context.Background())
 	if err != nil {
-		return fmt.Errorf("connection failed: %v", err)
+		return err
 	}
-	die.device = device
+	return die.attach(transport)
+}
 
-	services, err := device.DiscoverServices([]bluetooth.UUID{pixelServiceUuid})
-	if err != nil {
-		return fmt.Errorf("service discovery failed: %v", err)
+// Disconnect closes the die's transport. A Connection running the die stops instead of reconnecting.
+func (die *Die) Disconnect() error {
+	die.mu.Lock()
+	if die.disconnectRequest != nil {
+		close(die.disconnectRequest)
+		die.disconnectRequest = nil
+	}
+	die.mu.Unlock()
+	return die.disconnect()
+}
+
+// disconnectRequested returns a channel that the next call to Disconnect closes
+func (die *Die) disconnectRequested() <-chan struct{} {
+	die.mu.Lock()
+	defer die.mu.Unlock()
+	if die.disconnectRequest == nil {
+		die.disconnectRequest = make(chan struct{})
 	}
+	return die.disconnectRequest
+}
 
-	for _, service := range services {
-		if service.UUID().String() != PixelsService {
-			continue
-		}
+// disconnect closes the die's transport without stopping its Connection
+func (die *Die) disconnect() error {
+	die.mu.Lock()
+	transport := die.transport
+	die.transport = nil
+	die.mu.Unlock()
+	if transport == nil {
+		return ErrNotConnected
+	}
 
-		chars, _ := service.DiscoverCharacteristics([]bluetooth.UUID{notifyCharacterUuid, writeCharacteristicUUid})
-		for _, char := range chars {
-			if char.UUID().String() == PixelNotifyCharacteristic {
-				die.notifyChar = char
-				err = die.notifyChar.EnableNotifications(die.PixelCharacteristicReceiver)
-			} else if char.UUID().String() == PixelWriteCharacteristic {
-				die.writeChar = char
-			}
-		}
+	err := transport.Disconnect()
+	die.mu.Lock()
+	die.emit(EventDisconnected)
+	die.mu.Unlock()
+	return err
+}
+
+// detach forgets a transport whose link has dropped
+func (die *Die) detach(transport Transport) {
+	die.mu.Lock()
+	defer die.mu.Unlock()
+	if die.transport != transport {
+		return
 	}
+	die.transport = nil
+	die.emit(EventDisconnected)
+}
 
-	if err != nil {
-		return fmt.Errorf("notification failed: %v", err)
+func (die *Die) currentTransport() Transport {
+	die.mu.RLock()
+	defer die.mu.RUnlock()
+	return die.transport
+}
+
+func (die *Die) attach(transport Transport) error {
+	if err := transport.Subscribe(die.PixelCharacteristicReceiver); err != nil {
+		return err
 	}
+
+	die.mu.Lock()
+	defer die.mu.Unlock()
+	die.transport = transport
+	die.emit(EventConnected)
 	return nil
 }
 
 func (die *Die) PixelCharacteristicReceiver(buf []byte) {
-	if len(buf) == 0 {
+	die.mu.Lock()
+	defer die.mu.Unlock()
+
+	msg, err := parseMessage(buf)
+	if err != nil {
+		log.Printf("failed to parse %x: %v", buf, err)
+		die.emitError(err)
 		return
 	}
 
-	switch buf[0] {
-	case MsgTypeIAmADie:
-		msg := parseIAmADieMessage(buf)
-		die.readIAmADieMsg(msg)
-
-		log.Printf("Received IAmADie: %+v", msg)
-	case MsgTypeRollState:
-		msg := parseRollStateMessage(buf)
-		log.Printf("Received RollState: %+v", msg)
-		if msg.RollState == RollStateOnFace || msg.RollState == RollStateRolled {
-			die.CurrentFaceIndex = msg.CurrentFaceIndex
-			die.CurrentFaceValue = msg.CurrentFaceValue
-			die.LastRolled = time.Now()
-		}
-	case MsgTypeBlinkAck:
-		log.Printf("Blink Ack: %x", buf)
-	case MsgTypeBatteryLevel:
-		msg := parseBatteryLevelMessage(buf)
-		die.readBatteryBuffer(buf)
-		log.Printf("Received BatteryLevel: %+v", msg)
-	default:
-
-		log.Printf("received %d: %x", buf[0], buf)
-
+	switch m := msg.(type) {
+	case MessageIAmADie:
+		die.readIAmADieMsg(m)
+	case MessageRollState:
+		die.readRollStateMessage(m)
+	case MessageBatteryLevel:
+		die.readBatteryMsg(m)
+	case MessageTelemetry:
+		die.readTelemetryMsg(m)
+	case MessageRssi:
+		die.readRssiMsg(m)
+	case MessageTemperature:
+		die.readTemperatureMsg(m)
 	}
 
+	slog.Debug("received message", "type", fmt.Sprintf("%T", msg), "msg", fmt.Sprintf("%+v", msg))
+	die.resolveWaiters(buf[0], msg)
+	die.emitMessage(msg)
 }
@@ -0,0 +1,359 @@
+// Package sim provides a virtual Pixel die that speaks the real message protocol,
+// so dice logic can be exercised without Bluetooth hardware.
+package sim
+
+import (
+	"context"
+	"errors"
+	"godice/pixel"
+	"math/rand"
+	"sync"
+	"time"
+	"tinygo.org/x/bluetooth"
+)
+
+// Options configures a simulated die
+type Options struct {
+	PixelId        uint32
+	DieType        pixel.DieType
+	Name           string
+	DesignAndColor uint8
+	BatteryLevel   uint8
+	Rssi           int8
+	// Temperature is reported in degrees Celsius for both the MCU and battery
+	Temperature float64
+	Charging    bool
+	// Seed seeds the random roll generator
+	Seed int64
+	// Script is a list of face indexes returned by Roll before falling back to random rolls
+	Script []int
+	// RollDelay is the pause between the Handling, Rolling and OnFace notifications
+	RollDelay time.Duration
+}
+
+// Die is a simulated Pixel die. It implements pixel.Transport, delivering
+// notifications synchronously on the goroutine that triggered them.
+type Die struct {
+	mu        sync.Mutex
+	opts      Options
+	handler   func(buf []byte)
+	rng       *rand.Rand
+	script    []int
+	faceIndex uint8
+	rollState uint8
+	closed    bool
+	asleep    bool
+	done      chan struct{}
+}
+
+// ErrAsleep is returned when dialing a simulated die that has been put to sleep
+var ErrAsleep = errors.New("simulated die is asleep")
+
+var ledCounts = map[pixel.DieType]uint8{
+	pixel.DieTypeD4:       4,
+	pixel.DieTypeD6:       6,
+	pixel.DieTypeD8:       8,
+	pixel.DieTypeD10:      10,
+	pixel.DieTypeD00:      10,
+	pixel.DieTypeD12:      12,
+	pixel.DieTypeD20:      20,
+	pixel.DieTypeD6Pipped: 21,
+	pixel.DieTypeD6Fudge:  6,
+}
+
+// New creates a simulated die, defaulting to a fully charged d20
+func New(opts Options) *Die {
+	if opts.DieType == pixel.DieTypeUnknown {
+		opts.DieType = pixel.DieTypeD20
+	}
+	if opts.PixelId == 0 {
+		opts.PixelId = uint32(rand.New(rand.NewSource(opts.Seed)).Int31()) | 1
+	}
+	if opts.BatteryLevel == 0 {
+		opts.BatteryLevel = 100
+	}
+
+	return &Die{
+		opts:      opts,
+		rng:       rand.New(rand.NewSource(opts.Seed)),
+		script:    append([]int(nil), opts.Script...),
+		rollState: pixel.RollStateOnFace,
+		done:      make(chan struct{}),
+	}
+}
+
+// Connect creates a pixel.Die on top of the simulator and identifies it
+func (d *Die) Connect() (*pixel.Die, error) {
+	die, err := pixel.NewDie(d)
+	if err != nil {
+		return nil, err
+	}
+	if err := die.SendMsg(pixel.MessageWhoAreYou{}); err != nil {
+		return nil, err
+	}
+	return die, nil
+}
+
+// PixelId returns the simulated die's ID
+func (d *Die) PixelId() uint32 {
+	return d.opts.PixelId
+}
+
+// Name returns the simulated die's name
+func (d *Die) Name() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.opts.Name
+}
+
+// FaceCount returns the number of faces of the simulated die type
+func (d *Die) FaceCount() int {
+	return d.opts.DieType.FaceCount()
+}
+
+func (d *Die) Write(buf []byte) error {
+	d.mu.Lock()
+	closed := d.closed
+	d.mu.Unlock()
+	if closed {
+		return pixel.ErrTransportClosed
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+
+	switch buf[0] {
+	case pixel.MsgTypeWhoAreYou:
+		d.notify(d.iAmADie())
+	case pixel.MsgTypeBlink:
+		d.notify(pixel.MessageBlinkAck{})
+	case pixel.MsgTypeRequestBatteryLevel:
+		d.notify(d.batteryLevel())
+	case pixel.MsgTypeRequestRollState:
+		d.mu.Lock()
+		msg := pixel.MessageRollState{RollState: d.rollState, CurrentFaceIndex: d.faceIndex}
+		d.mu.Unlock()
+		d.notify(msg)
+	case pixel.MsgTypeRequestRssi:
+		d.notify(pixel.MessageRssi{Value: d.opts.Rssi})
+	case pixel.MsgTypeRequestTemperature:
+		temp := int16(d.opts.Temperature * 100)
+		d.notify(pixel.MessageTemperature{McuTemperatureTimes100: temp, BatteryTemperatureTimes100: temp})
+	case pixel.MsgTypeSetName:
+		if msg, err := pixel.ParseSetName(buf); err == nil {
+			d.mu.Lock()
+			d.opts.Name = msg.Name
+			d.mu.Unlock()
+		}
+		d.notify(pixel.MessageSetNameAck{})
+	case pixel.MsgTypeSetDesignAndColor:
+		if len(buf) > 1 {
+			d.mu.Lock()
+			d.opts.DesignAndColor = buf[1]
+			d.mu.Unlock()
+		}
+		d.notify(pixel.MessageSetDesignAndColorAck{})
+	case pixel.MsgTypeSleep:
+		d.mu.Lock()
+		d.asleep = true
+		d.mu.Unlock()
+		_ = d.Disconnect()
+	}
+	return nil
+}
+
+func (d *Die) Subscribe(handler func(buf []byte)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return pixel.ErrTransportClosed
+	}
+	d.handler = handler
+	return nil
+}
+
+func (d *Die) Disconnect() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closed {
+		close(d.done)
+	}
+	d.closed = true
+	d.handler = nil
+	return nil
+}
+
+func (d *Die) Disconnected() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.done
+}
+
+// Dial reconnects to the simulated die, failing with ErrAsleep while it sleeps.
+// It can be used as a pixel.DialFunc.
+func (d *Die) Dial(ctx context.Context) (pixel.Transport, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.asleep {
+		return nil, ErrAsleep
+	}
+	if d.closed {
+		d.closed = false
+		d.done = make(chan struct{})
+	}
+	return d, nil
+}
+
+// Drop simulates the link to the die being lost
+func (d *Die) Drop() {
+	_ = d.Disconnect()
+}
+
+// Wake lets a sleeping die be dialed again
+func (d *Die) Wake() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.asleep = false
+}
+
+// Advertisement returns the advertisement the simulated die would broadcast in its current state
+func (d *Die) Advertisement() pixel.Advertisement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return pixel.Advertisement{
+		Name:             d.opts.Name,
+		PixelId:          d.opts.PixelId,
+		LedCount:         ledCounts[d.opts.DieType],
+		DesignAndColor:   d.opts.DesignAndColor,
+		DieType:          d.opts.DieType,
+		RollState:        d.rollState,
+		CurrentFaceIndex: d.faceIndex,
+		BatteryLevel:     d.opts.BatteryLevel,
+		BatteryCharging:  d.opts.Charging,
+	}
+}
+
+// AdvertisementData returns the manufacturer and service data the simulated die would broadcast,
+// encoded the way the firmware lays them out
+func (d *Die) AdvertisementData() ([]bluetooth.ManufacturerDataElement, []bluetooth.ServiceDataElement) {
+	return d.Advertisement().AdvertisementData()
+}
+
+// Roll throws the die, landing on the next scripted face or a seeded random one.
+// It returns the face index rolled.
+func (d *Die) Roll() int {
+	d.mu.Lock()
+	var face int
+	if len(d.script) > 0 {
+		face = d.script[0]
+		d.script = d.script[1:]
+	} else {
+		face = d.rng.Intn(d.opts.DieType.FaceCount())
+	}
+	d.mu.Unlock()
+
+	d.RollFace(face)
+	return face
+}
+
+// RollFace throws the die so that it lands on the given face index
+func (d *Die) RollFace(faceIndex int) {
+	d.rollTo(uint8(faceIndex), pixel.RollStateOnFace)
+}
+
+// RollValue throws the die so that it lands on a face showing value,
+// returning false if the die type has no such face
+func (d *Die) RollValue(value int) bool {
+	for i := 0; i < d.opts.DieType.FaceCount(); i++ {
+		if d.opts.DieType.FaceValue(uint8(i)) == value {
+			d.RollFace(i)
+			return true
+		}
+	}
+	return false
+}
+
+// RollCrooked throws the die so that it settles without a clear face up
+func (d *Die) RollCrooked() {
+	d.mu.Lock()
+	face := d.faceIndex
+	d.mu.Unlock()
+	d.rollTo(face, pixel.RollStateCrooked)
+}
+
+// SetBattery updates the battery state and reports it to the subscriber
+func (d *Die) SetBattery(level uint8, charging bool) {
+	d.mu.Lock()
+	d.opts.BatteryLevel = level
+	d.opts.Charging = charging
+	d.mu.Unlock()
+	d.notify(d.batteryLevel())
+}
+
+func (d *Die) rollTo(faceIndex uint8, final uint8) {
+	d.mu.Lock()
+	from := d.faceIndex
+	d.mu.Unlock()
+
+	d.setRollState(pixel.RollStateHandling, from)
+	time.Sleep(d.opts.RollDelay)
+	d.setRollState(pixel.RollStateRolling, from)
+	time.Sleep(d.opts.RollDelay)
+	d.setRollState(final, faceIndex)
+}
+
+func (d *Die) setRollState(state uint8, faceIndex uint8) {
+	d.mu.Lock()
+	d.rollState = state
+	d.faceIndex = faceIndex
+	d.mu.Unlock()
+	d.notify(pixel.MessageRollState{RollState: state, CurrentFaceIndex: faceIndex})
+}
+
+func (d *Die) iAmADie() pixel.MessageIAmADie {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return pixel.MessageIAmADie{
+		LedCount:         ledCounts[d.opts.DieType],
+		DesignAndColor:   d.opts.DesignAndColor,
+		DieType:          uint8(d.opts.DieType),
+		PixelId:          d.opts.PixelId,
+		RollState:        d.rollState,
+		CurrentFaceIndex: d.faceIndex,
+		BatteryLevel:     d.opts.BatteryLevel,
+		BatteryState:     d.batteryState(),
+	}
+}
+
+func (d *Die) batteryLevel() pixel.MessageBatteryLevel {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return pixel.MessageBatteryLevel{
+		BatteryLevel: d.opts.BatteryLevel,
+		BatteryState: d.batteryState(),
+	}
+}
+
+func (d *Die) batteryState() uint8 {
+	if d.opts.Charging {
+		return pixel.BattStateCharging
+	}
+	if d.opts.BatteryLevel < 10 {
+		return pixel.BattStateLow
+	}
+	return pixel.BattStateOk
+}
+
+func (d *Die) notify(msg pixel.TxMessage) {
+	d.mu.Lock()
+	handler := d.handler
+	d.mu.Unlock()
+
+	if handler != nil {
+		handler(msg.ToBuffer())
+	}
+}
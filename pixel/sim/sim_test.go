@@ -0,0 +1,177 @@
+package sim_test
+
+import (
+	"context"
+	"errors"
+	"godice/pixel"
+	"godice/pixel/sim"
+	"testing"
+	"time"
+)
+
+func connect(t *testing.T, simDie *sim.Die) *pixel.Die {
+	t.Helper()
+	die, err := simDie.Connect()
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { _ = die.Disconnect() })
+	return die
+}
+
+// nextRollEvent skips to the next roll_started, rolled or crooked event
+func nextRollEvent(t *testing.T, events <-chan pixel.Event) pixel.Event {
+	t.Helper()
+	for {
+		select {
+		case evt := <-events:
+			switch evt.Type {
+			case pixel.EventRollStarted, pixel.EventRolled, pixel.EventCrooked:
+				return evt
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no roll event")
+		}
+	}
+}
+
+func TestScriptedRollsInOrder(t *testing.T) {
+	simDie := sim.New(sim.Options{PixelId: 1, DieType: pixel.DieTypeD6, Script: []int{3, 0, 5}})
+	die := connect(t, simDie)
+	events, stop := die.Subscribe(64)
+	defer stop()
+
+	for _, want := range []int{3, 0, 5} {
+		if face := simDie.Roll(); face != want {
+			t.Fatalf("Roll() = %d, want scripted face %d", face, want)
+		}
+		if evt := nextRollEvent(t, events); evt.Type != pixel.EventRollStarted {
+			t.Fatalf("first event = %s, want roll_started", evt.Type)
+		}
+		if evt := nextRollEvent(t, events); evt.Type != pixel.EventRolled || int(evt.CurrentFaceIndex) != want || evt.CurrentFaceValue != want+1 {
+			t.Fatalf("second event = %s on face %d, want rolled on %d", evt.Type, evt.CurrentFaceIndex, want)
+		}
+	}
+	// past the end of the script the rolls are random, but still on the die
+	for i := 0; i < 20; i++ {
+		if face := simDie.Roll(); face < 0 || face >= 6 {
+			t.Fatalf("Roll() = %d on a d6", face)
+		}
+	}
+}
+
+func TestSameSeedSameRolls(t *testing.T) {
+	first := sim.New(sim.Options{Seed: 42})
+	second := sim.New(sim.Options{Seed: 42})
+	if first.PixelId() != second.PixelId() || first.PixelId() == 0 {
+		t.Errorf("PixelIds %d and %d, want the same nonzero id for a seed", first.PixelId(), second.PixelId())
+	}
+	if first.FaceCount() != 20 {
+		t.Errorf("default die has %d faces, want a d20", first.FaceCount())
+	}
+	for i := 0; i < 50; i++ {
+		if a, b := first.Roll(), second.Roll(); a != b {
+			t.Fatalf("roll %d: %d and %d from the same seed", i, a, b)
+		}
+	}
+}
+
+func TestRollValue(t *testing.T) {
+	simDie := sim.New(sim.Options{DieType: pixel.DieTypeD00})
+	die := connect(t, simDie)
+	if !simDie.RollValue(70) {
+		t.Fatal("RollValue(70) found no face on a d00")
+	}
+	if state := die.Snapshot(); state.CurrentFaceValue != 70 || state.RollState != pixel.RollStateOnFace {
+		t.Errorf("die shows %d in state %s, want 70 on face", state.CurrentFaceValue, pixel.RollStateName(state.RollState))
+	}
+	if simDie.RollValue(7) {
+		t.Error("RollValue(7) found a face on a d00")
+	}
+
+	simDie.RollCrooked()
+	if state := die.Snapshot(); state.RollState != pixel.RollStateCrooked {
+		t.Errorf("state after a crooked roll = %s", pixel.RollStateName(state.RollState))
+	}
+}
+
+func TestRequests(t *testing.T) {
+	simDie := sim.New(sim.Options{PixelId: 9, DieType: pixel.DieTypeD12, BatteryLevel: 5, Rssi: -48, Temperature: 23.5})
+	die := connect(t, simDie)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if msg, err := die.Identify(ctx); err != nil || msg.PixelId != 9 || msg.DieType != uint8(pixel.DieTypeD12) || msg.LedCount != 12 {
+		t.Errorf("Identify = %+v, %v", msg, err)
+	}
+	if msg, err := die.QueryBatteryLevel(ctx); err != nil || msg.BatteryLevel != 5 || msg.BatteryState != pixel.BattStateLow {
+		t.Errorf("QueryBatteryLevel = %+v, %v", msg, err)
+	}
+	if msg, err := die.QueryRssi(ctx); err != nil || msg.Value != -48 {
+		t.Errorf("QueryRssi = %+v, %v", msg, err)
+	}
+	if msg, err := die.QueryTemperature(ctx); err != nil || msg.McuTemperatureTimes100 != 2350 {
+		t.Errorf("QueryTemperature = %+v, %v", msg, err)
+	}
+	if err := die.SetNameAndWait(ctx, "Lucky"); err != nil || simDie.Name() != "Lucky" {
+		t.Errorf("SetNameAndWait: %v, simulator name %q", err, simDie.Name())
+	}
+}
+
+func TestDropAndDial(t *testing.T) {
+	simDie := sim.New(sim.Options{PixelId: 3})
+	connect(t, simDie)
+	dropped := simDie.Disconnected()
+
+	simDie.Drop()
+	select {
+	case <-dropped:
+	default:
+		t.Fatal("Drop did not signal the disconnect")
+	}
+	if err := simDie.Write(pixel.MessageWhoAreYou{}.ToBuffer()); !errors.Is(err, pixel.ErrTransportClosed) {
+		t.Errorf("Write after Drop = %v, want ErrTransportClosed", err)
+	}
+
+	transport, err := simDie.Dial(context.Background())
+	if err != nil {
+		t.Fatalf("Dial after Drop: %v", err)
+	}
+	select {
+	case <-transport.(pixel.DisconnectNotifier).Disconnected():
+		t.Fatal("the redialed transport is already disconnected")
+	default:
+	}
+	if err := transport.Write(pixel.MessageWhoAreYou{}.ToBuffer()); err != nil {
+		t.Errorf("Write after Dial: %v", err)
+	}
+}
+
+func TestSleepAndWake(t *testing.T) {
+	simDie := sim.New(sim.Options{PixelId: 4})
+	die := connect(t, simDie)
+	dropped := simDie.Disconnected()
+
+	if err := die.SendMsg(pixel.MessageSleep{}); err != nil {
+		t.Fatalf("sleep: %v", err)
+	}
+	select {
+	case <-dropped:
+	default:
+		t.Fatal("a sleeping die stayed connected")
+	}
+	if _, err := simDie.Dial(context.Background()); !errors.Is(err, sim.ErrAsleep) {
+		t.Errorf("Dial while asleep = %v, want ErrAsleep", err)
+	}
+
+	simDie.Wake()
+	if _, err := simDie.Dial(context.Background()); err != nil {
+		t.Errorf("Dial after Wake: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := simDie.Dial(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Dial with a cancelled context = %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package pixel
+
+import "time"
+
+// DieState is an immutable snapshot of a die's last reported state
+type DieState struct {
+	PixelId          uint32
+	Name             string
+	LedCount         uint8
+	DieType          DieType
+	DesignAndColor   uint8
+	BuildTimestamp   uint32
+	RollState        uint8
+	CurrentFaceIndex uint8
+	CurrentFaceValue int
+	BatteryLevel     uint8
+	BatteryCharging  bool
+	Rssi             int8
+	// Temperatures are in hundredths of a degree Celsius
+	McuTemperatureTimes100     int16
+	BatteryTemperatureTimes100 int16
+	LastRolled                 time.Time
+	// LastSeen is when a passively tracked die last advertised
+	LastSeen time.Time
+}
+
+// Snapshot returns a consistent copy of the die's current state
+func (die *Die) Snapshot() DieState {
+	die.mu.RLock()
+	defer die.mu.RUnlock()
+	return die.snapshot()
+}
+
+// PixelId returns the die's unique Pixel ID, or 0 if it has not identified itself yet
+func (die *Die) PixelId() uint32 {
+	die.mu.RLock()
+	defer die.mu.RUnlock()
+	return die.pixelId
+}
+
+// snapshot must be called with die.mu held
+func (die *Die) snapshot() DieState {
+	return DieState{
+		PixelId:                    die.pixelId,
+		Name:                       die.name,
+		LedCount:                   die.ledCount,
+		DieType:                    die.dieType,
+		DesignAndColor:             die.designAndColor,
+		BuildTimestamp:             die.buildTimestamp,
+		RollState:                  die.rollState,
+		CurrentFaceIndex:           die.currentFaceIndex,
+		CurrentFaceValue:           die.currentFaceValue,
+		BatteryLevel:               die.batteryLevel,
+		BatteryCharging:            die.batteryCharging,
+		Rssi:                       die.rssi,
+		McuTemperatureTimes100:     die.mcuTemperatureTimes100,
+		BatteryTemperatureTimes100: die.batteryTemperatureTimes100,
+		LastRolled:                 die.lastRolled,
+		LastSeen:                   die.lastSeen,
+	}
+}
+
+// Name returns the die's advertised name, if known
+func (die *Die) Name() string {
+	die.mu.RLock()
+	defer die.mu.RUnlock()
+	return die.name
+}
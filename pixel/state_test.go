@@ -0,0 +1,62 @@
+package pixel_test
+
+import (
+	"context"
+	"godice/pixel"
+	"godice/pixel/sim"
+	"testing"
+	"time"
+)
+
+// connectSim connects a pixel.Die to a new simulated die
+func connectSim(t *testing.T, opts sim.Options) (*sim.Die, *pixel.Die) {
+	t.Helper()
+	simDie := sim.New(opts)
+	die, err := simDie.Connect()
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { _ = die.Disconnect() })
+	return simDie, die
+}
+
+// nextEvent waits for the first event of the given type
+func nextEvent(t *testing.T, events <-chan pixel.Event, eventType pixel.EventType) pixel.Event {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case evt := <-events:
+			if evt.Type == eventType {
+				return evt
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", eventType)
+		}
+	}
+}
+
+func TestSnapshotName(t *testing.T) {
+	simDie, die := connectSim(t, sim.Options{PixelId: 0x1234, DieType: pixel.DieTypeD20})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := die.SetNameAndWait(ctx, "Crit"); err != nil {
+		t.Fatalf("set name: %v", err)
+	}
+	if got := die.Snapshot().Name; got != "Crit" {
+		t.Errorf("Snapshot().Name = %q, want Crit", got)
+	}
+
+	events, stop := die.Subscribe(16)
+	defer stop()
+	simDie.RollValue(20)
+
+	evt := nextEvent(t, events, pixel.EventRolled)
+	if evt.Name != "Crit" {
+		t.Errorf("rolled event Name = %q, want Crit", evt.Name)
+	}
+	if evt.CurrentFaceValue != 20 {
+		t.Errorf("rolled event face = %d, want 20", evt.CurrentFaceValue)
+	}
+}
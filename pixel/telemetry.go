@@ -0,0 +1,118 @@
+package pixel
+
+import "encoding/binary"
+
+type MessageTelemetry struct {
+	Id                         uint8
+	AccXTimes1000              int16
+	AccYTimes1000              int16
+	AccZTimes1000              int16
+	FaceConfidenceTimes1000    int32
+	Time                       uint32
+	RollState                  uint8
+	CurrentFaceIndex           uint8
+	BatteryLevel               uint8
+	BatteryState               uint8
+	BatteryControllerState     uint8
+	VoltageTimes50             uint8
+	VCoilTimes50               uint8
+	Rssi                       int8
+	ChannelIndex               uint8
+	McuTemperatureTimes100     int16
+	BatteryTemperatureTimes100 int16
+	InternalChargeState        bool
+	ForceDisableChargingState  bool
+	LedCurrent                 uint8
+}
+
+func parseTelemetryMessage(buf []byte) (MessageTelemetry, error) {
+	if err := checkMessage(buf, 31, MsgTypeTelemetry); err != nil {
+		return MessageTelemetry{}, err
+	}
+	msg := MessageTelemetry{
+		Id:                         buf[0],
+		AccXTimes1000:              int16(binary.LittleEndian.Uint16(buf[1:])),
+		AccYTimes1000:              int16(binary.LittleEndian.Uint16(buf[3:])),
+		AccZTimes1000:              int16(binary.LittleEndian.Uint16(buf[5:])),
+		FaceConfidenceTimes1000:    int32(binary.LittleEndian.Uint32(buf[7:])),
+		Time:                       binary.LittleEndian.Uint32(buf[11:]),
+		RollState:                  buf[15],
+		CurrentFaceIndex:           buf[16],
+		BatteryLevel:               buf[17],
+		BatteryState:               buf[18],
+		BatteryControllerState:     buf[19],
+		VoltageTimes50:             buf[20],
+		VCoilTimes50:               buf[21],
+		Rssi:                       int8(buf[22]),
+		ChannelIndex:               buf[23],
+		McuTemperatureTimes100:     int16(binary.LittleEndian.Uint16(buf[24:])),
+		BatteryTemperatureTimes100: int16(binary.LittleEndian.Uint16(buf[26:])),
+		InternalChargeState:        buf[28] != 0,
+		ForceDisableChargingState:  buf[29] != 0,
+		LedCurrent:                 buf[30],
+	}
+	return msg, nil
+}
+
+func (die *Die) readTelemetryMsg(msg MessageTelemetry) {
+	die.rssi = msg.Rssi
+	die.mcuTemperatureTimes100 = msg.McuTemperatureTimes100
+	die.batteryTemperatureTimes100 = msg.BatteryTemperatureTimes100
+	die.updateBattery(msg.BatteryLevel, msg.BatteryState)
+}
+
+type MessageRssi struct {
+	Id    uint8
+	Value int8
+}
+
+func parseRssiMessage(buf []byte) (MessageRssi, error) {
+	if err := checkMessage(buf, 2, MsgTypeRssi); err != nil {
+		return MessageRssi{}, err
+	}
+	msg := MessageRssi{
+		Id:    buf[0],
+		Value: int8(buf[1]),
+	}
+	return msg, nil
+}
+
+func (msg MessageRssi) ToBuffer() []byte {
+	return []byte{MsgTypeRssi, uint8(msg.Value)}
+}
+
+func (die *Die) readRssiMsg(msg MessageRssi) {
+	die.rssi = msg.Value
+}
+
+type MessageTemperature struct {
+	Id                         uint8
+	McuTemperatureTimes100     int16
+	BatteryTemperatureTimes100 int16
+}
+
+func parseTemperatureMessage(buf []byte) (MessageTemperature, error) {
+	if err := checkMessage(buf, 5, MsgTypeTemperature); err != nil {
+		return MessageTemperature{}, err
+	}
+	msg := MessageTemperature{
+		Id:                         buf[0],
+		McuTemperatureTimes100:     int16(binary.LittleEndian.Uint16(buf[1:])),
+		BatteryTemperatureTimes100: int16(binary.LittleEndian.Uint16(buf[3:])),
+	}
+	return msg, nil
+}
+
+func (msg MessageTemperature) ToBuffer() (buf []byte) {
+	buf = make([]byte, 5)
+	buf[0] = MsgTypeTemperature
+	binary.LittleEndian.PutUint16(buf[1:], uint16(msg.McuTemperatureTimes100))
+	binary.LittleEndian.PutUint16(buf[3:], uint16(msg.BatteryTemperatureTimes100))
+
+	return buf
+}
+
+func (die *Die) readTemperatureMsg(msg MessageTemperature) {
+	die.mcuTemperatureTimes100 = msg.McuTemperatureTimes100
+	die.batteryTemperatureTimes100 = msg.BatteryTemperatureTimes100
+}
@@ -0,0 +1,280 @@
+package pixel
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ThrowDie is one die's part in a throw
+type ThrowDie struct {
+	Die       *Die
+	PixelId   uint32
+	Name      string
+	DieType   DieType
+	FaceIndex uint8
+	Value     int
+	// RollState is the RollState* value the die reported last
+	RollState uint8
+	Settled   bool
+	Crooked   bool
+	StartedAt time.Time
+	SettledAt time.Time
+}
+
+// Throw is a group of dice that started rolling together
+type Throw struct {
+	Dice     []ThrowDie
+	Started  time.Time
+	Settled  time.Time
+	TimedOut bool
+}
+
+// Total sums the values of the dice that settled on a face, ignoring crooked ones
+func (t Throw) Total() (total int) {
+	for _, die := range t.Dice {
+		if die.Settled && !die.Crooked {
+			total += die.Value
+		}
+	}
+	return total
+}
+
+// Crooked reports whether any die in the throw landed crooked
+func (t Throw) Crooked() bool {
+	for _, die := range t.Dice {
+		if die.Crooked {
+			return true
+		}
+	}
+	return false
+}
+
+// Counted returns the dice that contribute to Total
+func (t Throw) Counted() []ThrowDie {
+	var counted []ThrowDie
+	for _, die := range t.Dice {
+		if die.Settled && !die.Crooked {
+			counted = append(counted, die)
+		}
+	}
+	return counted
+}
+
+// SingleThrow makes a throw of one die from its rolled or crooked event
+func SingleThrow(evt Event) Throw {
+	p := newPendingThrow(evt.Time, AggregatorOptions{})
+	p.apply(evt)
+	return p.throw(evt.Time)
+}
+
+// AggregatorOptions controls how roll events are grouped into throws
+type AggregatorOptions struct {
+	// Window is how long after the first die starts rolling other dice can join the throw
+	Window time.Duration
+	// Timeout ends a throw whose dice have not all settled
+	Timeout time.Duration
+}
+
+// Aggregator groups the roll events of several dice into Throws
+type Aggregator struct {
+	opts   AggregatorOptions
+	events chan Event
+	throws subscribers[Throw]
+	// done is closed when Run returns, releasing the watched dice's forwarders
+	done     chan struct{}
+	doneOnce sync.Once
+	mu       sync.Mutex
+	watched  map[*Die]func()
+}
+
+// NewAggregator creates a roll aggregator, defaulting to a one second window and a ten second timeout
+func NewAggregator(opts AggregatorOptions) *Aggregator {
+	if opts.Window == 0 {
+		opts.Window = time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return &Aggregator{
+		opts:    opts,
+		events:  make(chan Event, 64),
+		done:    make(chan struct{}),
+		watched: make(map[*Die]func()),
+	}
+}
+
+// Watch includes the die's rolls in throws. Its events wait for Run to take them
+// until the die is unwatched or Run returns.
+func (a *Aggregator) Watch(die *Die) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.watched[die]; ok {
+		return
+	}
+
+	events, stop := die.Subscribe(16)
+	unwatched := make(chan struct{})
+	a.watched[die] = func() {
+		close(unwatched)
+		stop()
+	}
+	go func() {
+		for evt := range events {
+			switch evt.Type {
+			case EventRollStarted, EventRolled, EventCrooked:
+				select {
+				case a.events <- evt:
+				case <-unwatched:
+					return
+				case <-a.done:
+					stop()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Unwatch stops following the die
+func (a *Aggregator) Unwatch(die *Die) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if stop, ok := a.watched[die]; ok {
+		stop()
+		delete(a.watched, die)
+	}
+}
+
+// Throws returns a channel receiving every completed throw and a function to cancel the subscription
+func (a *Aggregator) Throws(buffer int) (<-chan Throw, func()) {
+	return a.throws.subscribe(buffer)
+}
+
+// Run groups events into throws until ctx is done. An Aggregator runs once.
+func (a *Aggregator) Run(ctx context.Context) error {
+	defer a.doneOnce.Do(func() { close(a.done) })
+	var current, next *pendingThrow
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		if current == nil && next != nil {
+			current, next = next, nil
+		}
+		wake := time.Hour
+		if current != nil {
+			wake = time.Until(current.wakeAt())
+		}
+		timer.Reset(wake)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case evt := <-a.events:
+			switch {
+			case current == nil:
+				current = newPendingThrow(evt.Time, a.opts)
+				current.apply(evt)
+			case current.has(evt.Die) || evt.Time.Before(current.windowEnd):
+				current.apply(evt)
+			default:
+				if next == nil {
+					next = newPendingThrow(evt.Time, a.opts)
+				}
+				next.apply(evt)
+			}
+		case <-timer.C:
+		}
+
+		if current != nil && current.done(time.Now()) {
+			a.throws.publish(current.throw(time.Now()))
+			current = nil
+		}
+	}
+}
+
+type pendingThrow struct {
+	started   time.Time
+	windowEnd time.Time
+	deadline  time.Time
+	dice      map[*Die]*ThrowDie
+}
+
+func newPendingThrow(started time.Time, opts AggregatorOptions) *pendingThrow {
+	return &pendingThrow{
+		started:   started,
+		windowEnd: started.Add(opts.Window),
+		deadline:  started.Add(opts.Timeout),
+		dice:      make(map[*Die]*ThrowDie),
+	}
+}
+
+func (p *pendingThrow) has(die *Die) bool {
+	_, ok := p.dice[die]
+	return ok
+}
+
+func (p *pendingThrow) apply(evt Event) {
+	die, ok := p.dice[evt.Die]
+	if !ok {
+		die = &ThrowDie{
+			Die:       evt.Die,
+			PixelId:   evt.PixelId,
+			Name:      evt.Name,
+			DieType:   evt.DieType,
+			StartedAt: evt.Time,
+		}
+		p.dice[evt.Die] = die
+	}
+
+	die.RollState = evt.RollState
+	switch evt.Type {
+	case EventRollStarted:
+		die.Settled = false
+		die.Crooked = false
+	case EventRolled, EventCrooked:
+		die.Settled = true
+		die.Crooked = evt.Type == EventCrooked
+		die.FaceIndex = evt.CurrentFaceIndex
+		die.Value = evt.CurrentFaceValue
+		die.SettledAt = evt.Time
+	}
+}
+
+func (p *pendingThrow) settled() bool {
+	for _, die := range p.dice {
+		if !die.Settled {
+			return false
+		}
+	}
+	return true
+}
+
+// wakeAt returns when the throw should next be checked for completion
+func (p *pendingThrow) wakeAt() time.Time {
+	if p.settled() && p.windowEnd.Before(p.deadline) {
+		return p.windowEnd
+	}
+	return p.deadline
+}
+
+func (p *pendingThrow) done(now time.Time) bool {
+	return !now.Before(p.deadline) || (p.settled() && !now.Before(p.windowEnd))
+}
+
+func (p *pendingThrow) throw(now time.Time) Throw {
+	throw := Throw{Started: p.started, TimedOut: !p.settled()}
+	for _, die := range p.dice {
+		throw.Dice = append(throw.Dice, *die)
+		if die.SettledAt.After(throw.Settled) {
+			throw.Settled = die.SettledAt
+		}
+	}
+	if throw.TimedOut {
+		throw.Settled = now
+	}
+	sort.Slice(throw.Dice, func(i, j int) bool { return throw.Dice[i].PixelId < throw.Dice[j].PixelId })
+	return throw
+}
@@ -0,0 +1,222 @@
+package pixel_test
+
+import (
+	"context"
+	"godice/pixel"
+	"godice/pixel/sim"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// runAggregator runs an aggregator over the dice until the test ends and returns its throws
+func runAggregator(t *testing.T, opts pixel.AggregatorOptions, dice ...*pixel.Die) <-chan pixel.Throw {
+	t.Helper()
+	aggregator := pixel.NewAggregator(opts)
+	throws, stop := aggregator.Throws(8)
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	go func() {
+		_ = aggregator.Run(ctx)
+		close(running)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-running
+		stop()
+	})
+	for _, die := range dice {
+		aggregator.Watch(die)
+	}
+	return throws
+}
+
+func nextThrow(t *testing.T, throws <-chan pixel.Throw) pixel.Throw {
+	t.Helper()
+	select {
+	case throw := <-throws:
+		return throw
+	case <-time.After(2 * time.Second):
+		t.Fatal("no throw")
+	}
+	return pixel.Throw{}
+}
+
+func noThrow(t *testing.T, throws <-chan pixel.Throw) {
+	t.Helper()
+	select {
+	case throw := <-throws:
+		t.Fatalf("unexpected throw %+v", throw)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// throwIds lists the ids of the dice in a throw
+func throwIds(throw pixel.Throw) []uint32 {
+	var ids []uint32
+	for _, die := range throw.Dice {
+		ids = append(ids, die.PixelId)
+	}
+	return ids
+}
+
+func TestAggregatorGroupsWithinWindow(t *testing.T) {
+	sim1, die1 := connectSim(t, sim.Options{PixelId: 1, DieType: pixel.DieTypeD6})
+	sim2, die2 := connectSim(t, sim.Options{PixelId: 2, DieType: pixel.DieTypeD8})
+	throws := runAggregator(t, pixel.AggregatorOptions{Window: 200 * time.Millisecond, Timeout: 2 * time.Second}, die1, die2)
+
+	sim1.RollValue(3)
+	sim2.RollValue(5)
+	throw := nextThrow(t, throws)
+	if ids := throwIds(throw); len(ids) != 2 || throw.Total() != 8 || throw.TimedOut || throw.Crooked() {
+		t.Errorf("throw of %v = %d, timed out %v, crooked %v; want dice 1 and 2 totalling 8", ids, throw.Total(), throw.TimedOut, throw.Crooked())
+	}
+	if throw.Settled.Before(throw.Started) {
+		t.Errorf("throw settled at %s before it started at %s", throw.Settled, throw.Started)
+	}
+	noThrow(t, throws)
+}
+
+func TestAggregatorSeparatesThrowsAfterWindow(t *testing.T) {
+	sim1, die1 := connectSim(t, sim.Options{PixelId: 1, DieType: pixel.DieTypeD6})
+	sim2, die2 := connectSim(t, sim.Options{PixelId: 2, DieType: pixel.DieTypeD6})
+	throws := runAggregator(t, pixel.AggregatorOptions{Window: 50 * time.Millisecond, Timeout: 2 * time.Second}, die1, die2)
+
+	sim1.RollValue(2)
+	time.Sleep(150 * time.Millisecond)
+	sim2.RollValue(4)
+	if throw := nextThrow(t, throws); len(throw.Dice) != 1 || throw.Dice[0].PixelId != 1 || throw.Total() != 2 {
+		t.Errorf("first throw = %v totalling %d, want die 1 on 2", throwIds(throw), throw.Total())
+	}
+	if throw := nextThrow(t, throws); len(throw.Dice) != 1 || throw.Dice[0].PixelId != 2 || throw.Total() != 4 {
+		t.Errorf("second throw = %v totalling %d, want die 2 on 4", throwIds(throw), throw.Total())
+	}
+}
+
+func TestAggregatorTimeout(t *testing.T) {
+	sim1, die1 := connectSim(t, sim.Options{PixelId: 1, DieType: pixel.DieTypeD6})
+	// die 2 keeps rolling well past the timeout
+	sim2, die2 := connectSim(t, sim.Options{PixelId: 2, DieType: pixel.DieTypeD6, RollDelay: 500 * time.Millisecond})
+	throws := runAggregator(t, pixel.AggregatorOptions{Window: 50 * time.Millisecond, Timeout: 150 * time.Millisecond}, die1, die2)
+
+	rolling := make(chan struct{})
+	go func() {
+		defer close(rolling)
+		sim2.RollValue(6)
+	}()
+	sim1.RollValue(5)
+
+	throw := nextThrow(t, throws)
+	if !throw.TimedOut || len(throw.Dice) != 2 || throw.Total() != 5 {
+		t.Errorf("throw of %v = %d, timed out %v; want a timed out throw of both dice totalling 5", throwIds(throw), throw.Total(), throw.TimedOut)
+	}
+	for _, die := range throw.Dice {
+		if die.Settled != (die.PixelId == 1) {
+			t.Errorf("die %d settled = %v", die.PixelId, die.Settled)
+		}
+	}
+	if elapsed := throw.Settled.Sub(throw.Started); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("throw ended %s after it started, want about the 150ms timeout", elapsed)
+	}
+	<-rolling
+}
+
+func TestAggregatorCrooked(t *testing.T) {
+	sim1, die1 := connectSim(t, sim.Options{PixelId: 1, DieType: pixel.DieTypeD6})
+	sim2, die2 := connectSim(t, sim.Options{PixelId: 2, DieType: pixel.DieTypeD6})
+	throws := runAggregator(t, pixel.AggregatorOptions{Window: 100 * time.Millisecond, Timeout: 2 * time.Second}, die1, die2)
+
+	sim1.RollCrooked()
+	sim2.RollValue(4)
+	throw := nextThrow(t, throws)
+	if !throw.Crooked() || throw.Total() != 4 || len(throw.Counted()) != 1 || throw.Counted()[0].PixelId != 2 {
+		t.Errorf("throw crooked %v totalling %d counting %+v, want die 1 flagged and only die 2 counted", throw.Crooked(), throw.Total(), throw.Counted())
+	}
+	if throw.Dice[0].RollState != pixel.RollStateCrooked || !throw.Dice[0].Settled {
+		t.Errorf("crooked die = %+v", throw.Dice[0])
+	}
+}
+
+func TestAggregatorRollDuringThrow(t *testing.T) {
+	// die 1 takes 200ms to settle, die 2 starts after the 50ms window while die 1 is still rolling
+	sim1, die1 := connectSim(t, sim.Options{PixelId: 1, DieType: pixel.DieTypeD6, RollDelay: 100 * time.Millisecond})
+	sim2, die2 := connectSim(t, sim.Options{PixelId: 2, DieType: pixel.DieTypeD6})
+	throws := runAggregator(t, pixel.AggregatorOptions{Window: 50 * time.Millisecond, Timeout: 2 * time.Second}, die1, die2)
+
+	rolling := make(chan struct{})
+	go func() {
+		defer close(rolling)
+		sim1.RollValue(1)
+	}()
+	time.Sleep(80 * time.Millisecond)
+	sim2.RollValue(6)
+	<-rolling
+
+	// the late die waits for its own throw instead of joining or ending the first
+	if throw := nextThrow(t, throws); len(throw.Dice) != 1 || throw.Dice[0].PixelId != 1 || throw.Total() != 1 {
+		t.Errorf("first throw = %v totalling %d, want die 1 on 1", throwIds(throw), throw.Total())
+	}
+	if throw := nextThrow(t, throws); len(throw.Dice) != 1 || throw.Dice[0].PixelId != 2 || throw.Total() != 6 {
+		t.Errorf("second throw = %v totalling %d, want die 2 on 6", throwIds(throw), throw.Total())
+	}
+}
+
+func TestAggregatorRerollJoinsThrow(t *testing.T) {
+	sim1, die1 := connectSim(t, sim.Options{PixelId: 1, DieType: pixel.DieTypeD6})
+	throws := runAggregator(t, pixel.AggregatorOptions{Window: 150 * time.Millisecond, Timeout: 2 * time.Second}, die1)
+
+	// a die picked up again before the throw ends counts with its last face
+	sim1.RollValue(2)
+	sim1.RollValue(5)
+	if throw := nextThrow(t, throws); len(throw.Dice) != 1 || throw.Total() != 5 {
+		t.Errorf("throw = %v totalling %d, want die 1 on 5", throwIds(throw), throw.Total())
+	}
+	noThrow(t, throws)
+}
+
+// waitGoroutines waits for the number of goroutines to drop to at most n
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines are still running, want at most %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAggregatorReleasesDiceAfterRun(t *testing.T) {
+	simDie, die := connectSim(t, sim.Options{PixelId: 1, DieType: pixel.DieTypeD6})
+	baseline := runtime.NumGoroutine()
+
+	aggregator := pixel.NewAggregator(pixel.AggregatorOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	go func() {
+		_ = aggregator.Run(ctx)
+		close(running)
+	}()
+	aggregator.Watch(die)
+	cancel()
+	<-running
+
+	// more events than the aggregator buffers, with nothing left to take them
+	for i := 0; i < 50; i++ {
+		simDie.RollValue(i%6 + 1)
+	}
+	waitGoroutines(t, baseline)
+}
+
+func TestAggregatorUnwatchWithoutRun(t *testing.T) {
+	simDie, die := connectSim(t, sim.Options{PixelId: 1, DieType: pixel.DieTypeD6})
+	baseline := runtime.NumGoroutine()
+
+	aggregator := pixel.NewAggregator(pixel.AggregatorOptions{})
+	aggregator.Watch(die)
+	for i := 0; i < 50; i++ {
+		simDie.RollValue(i%6 + 1)
+	}
+	aggregator.Unwatch(die)
+	waitGoroutines(t, baseline)
+}
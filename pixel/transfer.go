@@ -0,0 +1,56 @@
+package pixel
+
+import "encoding/binary"
+
+// Transfer Ack Results
+const (
+	TransferAckNo = iota
+	TransferAckYes
+	TransferAckUpToDate
+)
+
+// MessageTransferAck is the die's answer to a transfer request, used for the
+// animation set, settings, test animation set and instant animation set transfers
+type MessageTransferAck struct {
+	Id     uint8
+	Result uint8
+}
+
+func parseTransferAckMessage(buf []byte) (MessageTransferAck, error) {
+	if err := checkMessage(buf, 2, MsgTypeTransferAnimationSetAck, MsgTypeTransferSettingsAck,
+		MsgTypeTransferTestAnimationSetAck, MsgTypeTransferInstantAnimationSetAck); err != nil {
+		return MessageTransferAck{}, err
+	}
+	msg := MessageTransferAck{
+		Id:     buf[0],
+		Result: buf[1],
+	}
+	return msg, nil
+}
+
+type MessageBulkSetupAck struct {
+	Id uint8
+}
+
+func parseBulkSetupAckMessage(buf []byte) (MessageBulkSetupAck, error) {
+	if err := checkMessage(buf, 1, MsgTypeBulkSetupAck); err != nil {
+		return MessageBulkSetupAck{}, err
+	}
+	return MessageBulkSetupAck{Id: buf[0]}, nil
+}
+
+type MessageBulkDataAck struct {
+	Id     uint8
+	Offset uint16
+}
+
+func parseBulkDataAckMessage(buf []byte) (MessageBulkDataAck, error) {
+	if err := checkMessage(buf, 3, MsgTypeBulkDataAck); err != nil {
+		return MessageBulkDataAck{}, err
+	}
+	msg := MessageBulkDataAck{
+		Id:     buf[0],
+		Offset: binary.LittleEndian.Uint16(buf[1:]),
+	}
+	return msg, nil
+}
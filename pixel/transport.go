@@ -0,0 +1,184 @@
+package pixel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"tinygo.org/x/bluetooth"
+)
+
+// ErrTransportClosed is returned when writing to a transport that has been disconnected
+var ErrTransportClosed = errors.New("transport closed")
+
+// Transport carries Pixel protocol messages between a Die and the physical (or simulated) die
+type Transport interface {
+	// Write sends a message buffer to the die
+	Write(buf []byte) error
+	// Subscribe registers the handler for notifications sent by the die
+	Subscribe(handler func(buf []byte)) error
+	// Disconnect closes the underlying connection
+	Disconnect() error
+}
+
+// DisconnectNotifier is implemented by transports that can tell when the link to the die drops
+type DisconnectNotifier interface {
+	// Disconnected returns a channel that is closed once the transport is no longer connected
+	Disconnected() <-chan struct{}
+}
+
+// DialFunc establishes a new transport to a die
+type DialFunc func(ctx context.Context) (Transport, error)
+
+// BLETransport is a Transport backed by a tinygo bluetooth GATT connection
+type BLETransport struct {
+	device     bluetooth.Device
+	writeChar  bluetooth.DeviceCharacteristic
+	notifyChar bluetooth.DeviceCharacteristic
+	done       chan struct{}
+	doneOnce   sync.Once
+}
+
+// NewBLETransport discovers the Pixel service and characteristics on a connected device
+func NewBLETransport(device bluetooth.Device) (*BLETransport, error) {
+	t := &BLETransport{device: device, done: make(chan struct{})}
+
+	services, err := device.DiscoverServices([]bluetooth.UUID{pixelServiceUuid})
+	if err != nil {
+		return nil, fmt.Errorf("service discovery failed: %v", err)
+	}
+
+	var foundWrite, foundNotify bool
+	for _, service := range services {
+		if service.UUID().String() != PixelsService {
+			continue
+		}
+
+		chars, err := service.DiscoverCharacteristics([]bluetooth.UUID{notifyCharacterUuid, writeCharacteristicUUid})
+		if err != nil {
+			return nil, fmt.Errorf("characteristic discovery failed: %v", err)
+		}
+		for _, char := range chars {
+			if char.UUID().String() == PixelNotifyCharacteristic {
+				t.notifyChar = char
+				foundNotify = true
+			} else if char.UUID().String() == PixelWriteCharacteristic {
+				t.writeChar = char
+				foundWrite = true
+			}
+		}
+	}
+
+	if !foundWrite || !foundNotify {
+		return nil, fmt.Errorf("pixel characteristics not found on %s", device.Address)
+	}
+	return t, nil
+}
+
+// Address returns the BLE address of the connected device
+func (t *BLETransport) Address() bluetooth.Address {
+	return t.device.Address
+}
+
+func (t *BLETransport) Write(buf []byte) error {
+	_, err := t.writeChar.WriteWithoutResponse(buf)
+	return err
+}
+
+func (t *BLETransport) Subscribe(handler func(buf []byte)) error {
+	if err := t.notifyChar.EnableNotifications(handler); err != nil {
+		return fmt.Errorf("notification failed: %v", err)
+	}
+	return nil
+}
+
+func (t *BLETransport) Disconnect() error {
+	t.markDisconnected()
+	return t.device.Disconnect()
+}
+
+func (t *BLETransport) Disconnected() <-chan struct{} {
+	return t.done
+}
+
+func (t *BLETransport) markDisconnected() {
+	t.doneOnce.Do(func() { close(t.done) })
+}
+
+// BLEDialer returns a DialFunc that connects to the die at address through adapter
+func BLEDialer(adapter *bluetooth.Adapter, address bluetooth.Address) DialFunc {
+	return func(ctx context.Context) (Transport, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		device, err := adapter.Connect(address, bluetooth.ConnectionParams{})
+		if err != nil {
+			return nil, fmt.Errorf("connection failed: %v", err)
+		}
+
+		t, err := NewBLETransport(device)
+		if err != nil {
+			_ = device.Disconnect()
+			return nil, err
+		}
+		watchAdapter(adapter).track(t)
+		return t, nil
+	}
+}
+
+// adapterWatcher owns an adapter's connect handler, marking tracked transports
+// as disconnected and forwarding every change to the registered handlers
+type adapterWatcher struct {
+	mu         sync.Mutex
+	transports map[string]*BLETransport
+	handlers   []func(device bluetooth.Device, connected bool)
+}
+
+var (
+	adapterWatchersMu sync.Mutex
+	adapterWatchers   = make(map[*bluetooth.Adapter]*adapterWatcher)
+)
+
+func watchAdapter(adapter *bluetooth.Adapter) *adapterWatcher {
+	adapterWatchersMu.Lock()
+	defer adapterWatchersMu.Unlock()
+
+	if w, ok := adapterWatchers[adapter]; ok {
+		return w
+	}
+	w := &adapterWatcher{transports: make(map[string]*BLETransport)}
+	adapter.SetConnectHandler(w.handle)
+	adapterWatchers[adapter] = w
+	return w
+}
+
+func (w *adapterWatcher) track(t *BLETransport) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.transports[t.device.Address.String()] = t
+}
+
+func (w *adapterWatcher) onConnect(handler func(device bluetooth.Device, connected bool)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers = append(w.handlers, handler)
+}
+
+func (w *adapterWatcher) handle(device bluetooth.Device, connected bool) {
+	w.mu.Lock()
+	address := device.Address.String()
+	t, tracked := w.transports[address]
+	if tracked && !connected {
+		delete(w.transports, address)
+	}
+	handlers := append([]func(bluetooth.Device, bool){}, w.handlers...)
+	w.mu.Unlock()
+
+	if tracked && !connected {
+		t.markDisconnected()
+	}
+	for _, handler := range handlers {
+		handler(device, connected)
+	}
+}
@@ -0,0 +1,83 @@
+package pixel
+
+import "sync"
+
+// MemoryTransport is an in-memory Transport for running a Die without BLE hardware
+type MemoryTransport struct {
+	mu      sync.Mutex
+	handler func(buf []byte)
+	written [][]byte
+	closed  bool
+	done    chan struct{}
+
+	// OnWrite, if set, is called with every buffer the die writes
+	OnWrite func(buf []byte)
+}
+
+// NewMemoryTransport creates a new in-memory transport
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{done: make(chan struct{})}
+}
+
+func (t *MemoryTransport) Write(buf []byte) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return ErrTransportClosed
+	}
+	t.written = append(t.written, append([]byte(nil), buf...))
+	onWrite := t.OnWrite
+	t.mu.Unlock()
+
+	if onWrite != nil {
+		onWrite(buf)
+	}
+	return nil
+}
+
+func (t *MemoryTransport) Subscribe(handler func(buf []byte)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	t.handler = handler
+	return nil
+}
+
+func (t *MemoryTransport) Disconnect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed && t.done != nil {
+		close(t.done)
+	}
+	t.closed = true
+	t.handler = nil
+	return nil
+}
+
+func (t *MemoryTransport) Disconnected() <-chan struct{} {
+	return t.done
+}
+
+// Notify delivers a buffer to the subscribed handler as if the die had sent it
+func (t *MemoryTransport) Notify(buf []byte) {
+	t.mu.Lock()
+	handler := t.handler
+	t.mu.Unlock()
+
+	if handler != nil {
+		handler(buf)
+	}
+}
+
+// Written returns a copy of every buffer written to the transport
+func (t *MemoryTransport) Written() [][]byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	written := make([][]byte, len(t.written))
+	for i, buf := range t.written {
+		written[i] = append([]byte(nil), buf...)
+	}
+	return written
+}
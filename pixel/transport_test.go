@@ -0,0 +1,115 @@
+package pixel_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"godice/pixel"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// answeringTransport is a MemoryTransport that identifies itself as the given die when asked
+func answeringTransport(id pixel.MessageIAmADie) *pixel.MemoryTransport {
+	transport := pixel.NewMemoryTransport()
+	transport.OnWrite = func(buf []byte) {
+		if buf[0] == pixel.MsgTypeWhoAreYou {
+			transport.Notify(id.ToBuffer())
+		}
+	}
+	return transport
+}
+
+func TestMemoryTransport(t *testing.T) {
+	transport := pixel.NewMemoryTransport()
+	var writes atomic.Int32
+	transport.OnWrite = func(buf []byte) { writes.Add(1) }
+	die, err := pixel.NewDie(transport)
+	if err != nil {
+		t.Fatalf("NewDie: %v", err)
+	}
+
+	// Notify delivers to the die as if the die had sent it
+	transport.Notify(pixel.MessageIAmADie{LedCount: 8, DieType: uint8(pixel.DieTypeD8), PixelId: 0x88, RollState: pixel.RollStateOnFace, CurrentFaceIndex: 2}.ToBuffer())
+	if state := die.Snapshot(); state.PixelId != 0x88 || state.DieType != pixel.DieTypeD8 || state.CurrentFaceValue != 3 {
+		t.Errorf("snapshot after Notify = %+v", state)
+	}
+
+	// Written records what the die sent, in order
+	_ = die.RequestRollState()
+	_ = die.RequestBatteryLevel()
+	written := transport.Written()
+	if len(written) != 2 || !bytes.Equal(written[0], []byte{pixel.MsgTypeRequestRollState}) || !bytes.Equal(written[1], []byte{pixel.MsgTypeRequestBatteryLevel}) {
+		t.Errorf("Written() = % x", written)
+	}
+	if writes.Load() != 2 {
+		t.Errorf("OnWrite saw %d writes, want 2", writes.Load())
+	}
+	written[0][0] = 0xFF
+	if transport.Written()[0][0] != pixel.MsgTypeRequestRollState {
+		t.Error("Written() shares its buffers with the transport")
+	}
+
+	// Disconnect closes the DisconnectNotifier channel and the transport
+	var notifier pixel.DisconnectNotifier = transport
+	select {
+	case <-notifier.Disconnected():
+		t.Fatal("disconnected before Disconnect")
+	default:
+	}
+	if err := transport.Disconnect(); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+	if err := transport.Disconnect(); err != nil {
+		t.Fatalf("second Disconnect: %v", err)
+	}
+	select {
+	case <-notifier.Disconnected():
+	default:
+		t.Fatal("Disconnected() is still open after Disconnect")
+	}
+	if err := transport.Write([]byte{pixel.MsgTypeWhoAreYou}); !errors.Is(err, pixel.ErrTransportClosed) {
+		t.Errorf("Write after Disconnect = %v", err)
+	}
+	if err := transport.Subscribe(func([]byte) {}); !errors.Is(err, pixel.ErrTransportClosed) {
+		t.Errorf("Subscribe after Disconnect = %v", err)
+	}
+	transport.Notify(pixel.MessageRollState{RollState: pixel.RollStateRolling}.ToBuffer())
+	if state := die.Snapshot(); state.RollState != pixel.RollStateOnFace {
+		t.Errorf("Notify after Disconnect reached the die: %+v", state)
+	}
+}
+
+func TestConnectionRedialsMemoryTransport(t *testing.T) {
+	id := pixel.MessageIAmADie{LedCount: 6, DieType: uint8(pixel.DieTypeD6), PixelId: 0x66}
+	transports := make(chan *pixel.MemoryTransport, 4)
+	die := &pixel.Die{}
+	conn := pixel.NewConnection(die, func(ctx context.Context) (pixel.Transport, error) {
+		transport := answeringTransport(id)
+		transports <- transport
+		return transport, nil
+	})
+	conn.Backoff = pixel.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- conn.Run(ctx) }()
+
+	first := <-transports
+	// the link dropping from the transport side is redialed
+	_ = first.Disconnect()
+	second := <-transports
+	if die.PixelId() != 0x66 {
+		t.Errorf("die identified as %d", die.PixelId())
+	}
+
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v", err)
+	}
+	select {
+	case <-second.Disconnected():
+	default:
+		t.Error("Run left the transport connected")
+	}
+}
@@ -1,6 +1,10 @@
 package pixel
 
-import "image/color"
+import (
+	"image/color"
+	"math"
+	"time"
+)
 
 func rgbaToUint32(c color.RGBA) uint32 {
 	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
@@ -11,3 +15,25 @@ func must(action string, err error) {
 		panic("failed to " + action + ": " + err.Error())
 	}
 }
+
+func parseCString(buf []byte) string {
+	for i, b := range buf {
+		if b == 0 {
+			return string(buf[:i])
+		}
+	}
+	return string(buf)
+}
+
+// milliseconds16 converts a duration to the 16-bit millisecond count the firmware takes,
+// clamping it to 0 and about 65 seconds instead of letting it wrap
+func milliseconds16(d time.Duration) uint16 {
+	ms := d.Milliseconds()
+	if ms < 0 {
+		return 0
+	}
+	if ms > math.MaxUint16 {
+		return math.MaxUint16
+	}
+	return uint16(ms)
+}
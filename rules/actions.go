@@ -0,0 +1,228 @@
+package rules
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	ha "godice/homeassistiant"
+	"godice/pixel"
+	cn "golang.org/x/image/colornames"
+	"image/color"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action Types
+const (
+	ActionLightColor       = "light_color"
+	ActionLightTemperature = "light_temperature"
+	ActionLightCycle       = "light_cycle"
+	ActionLightOff         = "light_off"
+	ActionLightPulse       = "light_pulse"
+	ActionService          = "service"
+	ActionBlink            = "blink"
+	ActionWait             = "wait"
+)
+
+// Action is one step run when a rule matches
+type Action struct {
+	Type string `yaml:"type"`
+	// Entities are the lights to control, defaulting to the configured light entities
+	Entities []string `yaml:"entities"`
+	// Color is a CSS color name or #rrggbb, used by light_color, light_pulse and blink
+	Color string `yaml:"color"`
+	// Colors are cycled through by light_cycle
+	Colors []string `yaml:"colors"`
+	// Temperature is the color temperature in Kelvin for light_temperature
+	Temperature int `yaml:"temperature"`
+	// Interval is the time between colors for light_cycle, between blinks for blink and the length of a light_pulse
+	Interval time.Duration `yaml:"interval"`
+	// Transition fades light_color, light_temperature and light_off into their new state
+	Transition time.Duration `yaml:"transition"`
+	// Blink turns the light off between colors for light_cycle
+	Blink bool `yaml:"blink"`
+	// Count is the number of blinks on the dice for blink and the number of pulses for light_pulse
+	Count uint8 `yaml:"count"`
+	// Duration is how long a wait action pauses
+	Duration time.Duration `yaml:"duration"`
+	// Domain, Service and Data describe an arbitrary Home Assistant service call
+	Domain  string                 `yaml:"domain"`
+	Service string                 `yaml:"service"`
+	Data    map[string]interface{} `yaml:"data"`
+}
+
+// Validate checks that the action has the fields its type needs
+func (a Action) Validate() error {
+	switch a.Type {
+	case ActionLightColor, ActionLightPulse, ActionBlink:
+		_, err := ParseColor(a.Color)
+		return err
+	case ActionLightCycle:
+		if len(a.Colors) == 0 {
+			return fmt.Errorf("%s needs colors", a.Type)
+		}
+		for _, c := range a.Colors {
+			if _, err := ParseColor(c); err != nil {
+				return err
+			}
+		}
+	case ActionLightTemperature:
+		if a.Temperature <= 0 {
+			return fmt.Errorf("%s needs a temperature", a.Type)
+		}
+	case ActionService:
+		if a.Domain == "" || a.Service == "" {
+			return fmt.Errorf("%s needs a domain and service", a.Type)
+		}
+	case ActionLightOff, ActionWait:
+	default:
+		return fmt.Errorf("unknown action type %q", a.Type)
+	}
+	return nil
+}
+
+// ParseColor parses a CSS color name such as "royalblue" or a #rrggbb hex color
+func ParseColor(s string) (color.RGBA, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if c, ok := cn.Map[name]; ok {
+		return c, nil
+	}
+
+	if strings.HasPrefix(name, "#") && len(name) == 7 {
+		b, err := hex.DecodeString(name[1:])
+		if err == nil {
+			return color.RGBA{R: b[0], G: b[1], B: b[2], A: 0xFF}, nil
+		}
+	}
+	return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+}
+
+// Executor runs actions against Home Assistant and the dice in a throw
+type Executor struct {
+	HA *ha.HAClient
+	// Entities are the default light entities for light actions
+	Entities []string
+	// Effects plays the actions in the background, a new throw interrupting the previous one's actions.
+	// It defaults to a runner on HA.
+	Effects *ha.EffectRunner
+	// Restore puts the lights back into the state they had before the actions,
+	// RestoreAfter after the last action
+	Restore      bool
+	RestoreAfter time.Duration
+
+	once sync.Once
+}
+
+// Execute runs the actions in order and waits for them, stopping at the first error
+func (x *Executor) Execute(ctx context.Context, throw pixel.Throw, actions []Action) error {
+	return x.Start(ctx, throw, actions).Wait()
+}
+
+// Start runs the actions in order in the background, cancelling the actions still running on the same lights
+func (x *Executor) Start(ctx context.Context, throw pixel.Throw, actions []Action) *ha.Playback {
+	x.once.Do(func() {
+		if x.Effects == nil {
+			x.Effects = ha.NewEffectRunner(x.HA, ha.EffectOptions{})
+		}
+	})
+
+	entities := append([]string(nil), x.Entities...)
+	for _, action := range actions {
+		for _, entity := range action.Entities {
+			if !slices.Contains(entities, entity) {
+				entities = append(entities, entity)
+			}
+		}
+	}
+
+	return x.Effects.Play(ctx, entities, func(ctx context.Context, lights *ha.Lights) error {
+		for _, action := range actions {
+			if err := x.execute(ctx, lights, throw, action); err != nil {
+				return fmt.Errorf("%s: %w", action.Type, err)
+			}
+		}
+		if x.Restore {
+			return ha.Sleep(ctx, x.RestoreAfter)
+		}
+		return nil
+	}, x.Restore)
+}
+
+func (x *Executor) execute(ctx context.Context, lights *ha.Lights, throw pixel.Throw, action Action) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entities := action.Entities
+	if len(entities) == 0 {
+		entities = x.Entities
+	}
+	lights = lights.Only(entities...)
+
+	switch action.Type {
+	case ActionLightColor:
+		c, _ := ParseColor(action.Color)
+		return lights.TurnOn(ctx, ha.LightTurnOn{RGB: &c, Transition: action.Transition})
+	case ActionLightTemperature:
+		return lights.TurnOn(ctx, ha.LightTurnOn{Kelvin: action.Temperature, Transition: action.Transition})
+	case ActionLightOff:
+		return lights.TurnOff(ctx, action.Transition)
+	case ActionLightPulse:
+		c, _ := ParseColor(action.Color)
+		count := int(action.Count)
+		if count == 0 {
+			count = 3
+		}
+		period := action.Interval
+		if period == 0 {
+			period = 2 * time.Second
+		}
+		return ha.Pulse(c, count, period)(ctx, lights)
+	case ActionLightCycle:
+		colors := make([]color.RGBA, 0, len(action.Colors))
+		for _, name := range action.Colors {
+			c, _ := ParseColor(name)
+			colors = append(colors, c)
+		}
+		interval := action.Interval
+		if interval == 0 {
+			interval = 500 * time.Millisecond
+		}
+		return ha.Cycle(colors, interval, action.Blink)(ctx, lights)
+	case ActionService:
+		data := make(map[string]interface{}, len(action.Data)+1)
+		for k, v := range action.Data {
+			data[k] = v
+		}
+		if _, ok := data["entity_id"]; !ok && len(action.Entities) > 0 {
+			data["entity_id"] = action.Entities
+		}
+		_, err := x.HA.CallService(ctx, action.Domain, action.Service, data, false)
+		return err
+	case ActionBlink:
+		c, _ := ParseColor(action.Color)
+		count := action.Count
+		if count == 0 {
+			count = 3
+		}
+		interval := action.Interval
+		if interval == 0 {
+			interval = 500 * time.Millisecond
+		}
+		for _, die := range throw.Dice {
+			if die.Die == nil {
+				continue
+			}
+			// passively tracked dice and dice that dropped since the throw have no link to blink over
+			err := die.Die.Blink(c, count, interval*time.Duration(count), 0xFFFFFFFF)
+			if err != nil && !errors.Is(err, pixel.ErrNotConnected) {
+				return err
+			}
+		}
+	case ActionWait:
+		return ha.Sleep(ctx, action.Duration)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package rules
+
+import "time"
+
+// DefaultRules reproduce the original lamp reactions: a rainbow on 20, blue,
+// green, orange and red for descending totals and blinking red on a 1.
+// The lights are restored to their previous state by the Executor afterwards.
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			Name: "natural 20",
+			When: Condition{Total: intPtr(20)},
+			Actions: []Action{{
+				Type:     ActionLightCycle,
+				Colors:   []string{"red", "orange", "yellow", "green", "blue", "indigo", "purple"},
+				Interval: 500 * time.Millisecond,
+				Blink:    true,
+			}},
+		},
+		{Name: "high", When: Condition{TotalMin: intPtr(15)}, Actions: []Action{{Type: ActionLightColor, Color: "royalblue"}}},
+		{Name: "good", When: Condition{TotalMin: intPtr(10)}, Actions: []Action{{Type: ActionLightColor, Color: "green"}}},
+		{Name: "fair", When: Condition{TotalMin: intPtr(5)}, Actions: []Action{{Type: ActionLightColor, Color: "orange"}}},
+		{Name: "poor", When: Condition{TotalMin: intPtr(2)}, Actions: []Action{{Type: ActionLightColor, Color: "red"}}},
+		{
+			Name: "natural 1",
+			// a throw where every die landed crooked totals 0 and is left to the crooked rule
+			When: Condition{TotalMax: intPtr(1), Crooked: boolPtr(false)},
+			Actions: []Action{{
+				Type:     ActionLightCycle,
+				Colors:   []string{"red", "red", "red"},
+				Interval: 500 * time.Millisecond,
+				Blink:    true,
+			}},
+		},
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
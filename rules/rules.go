@@ -0,0 +1,156 @@
+// Package rules maps dice throws to Home Assistant and die actions using declarative rules from the config file.
+package rules
+
+import (
+	"fmt"
+	"godice/pixel"
+	"strings"
+)
+
+// Rule runs its actions when a throw matches its condition
+type Rule struct {
+	Name    string    `yaml:"name"`
+	When    Condition `yaml:"when"`
+	Actions []Action  `yaml:"actions"`
+	// Always rules are evaluated even after an earlier rule matched
+	Always bool `yaml:"always"`
+}
+
+// Condition matches a throw. Every field that is set must match; an empty condition matches any throw.
+type Condition struct {
+	// Total, TotalMin and TotalMax compare the sum of the counted dice
+	Total    *int `yaml:"total"`
+	TotalMin *int `yaml:"total_min"`
+	TotalMax *int `yaml:"total_max"`
+	// Face matches when any counted die shows this value
+	Face *int `yaml:"face"`
+	// Dice matches the number of counted dice
+	Dice *int `yaml:"dice"`
+	// DieIds and DieTypes match when any die in the throw has one of the IDs or types (e.g. "d20")
+	DieIds   []uint32 `yaml:"die_ids"`
+	DieTypes []string `yaml:"die_types"`
+	// Crit and Fumble match when any counted die shows its highest or lowest face
+	Crit   *bool `yaml:"crit"`
+	Fumble *bool `yaml:"fumble"`
+	// Crooked matches when any die landed crooked
+	Crooked *bool `yaml:"crooked"`
+}
+
+// Matches reports whether the throw satisfies the condition
+func (c Condition) Matches(throw pixel.Throw) bool {
+	total := throw.Total()
+	counted := throw.Counted()
+
+	if c.Total != nil && total != *c.Total {
+		return false
+	}
+	if c.TotalMin != nil && total < *c.TotalMin {
+		return false
+	}
+	if c.TotalMax != nil && total > *c.TotalMax {
+		return false
+	}
+	if c.Dice != nil && len(counted) != *c.Dice {
+		return false
+	}
+	if c.Face != nil && !anyDie(counted, func(die pixel.ThrowDie) bool { return die.Value == *c.Face }) {
+		return false
+	}
+	if len(c.DieIds) > 0 && !anyDie(throw.Dice, func(die pixel.ThrowDie) bool { return containsId(c.DieIds, die.PixelId) }) {
+		return false
+	}
+	if len(c.DieTypes) > 0 && !anyDie(throw.Dice, func(die pixel.ThrowDie) bool { return containsType(c.DieTypes, die.DieType) }) {
+		return false
+	}
+	if c.Crit != nil && *c.Crit != anyDie(counted, isCrit) {
+		return false
+	}
+	if c.Fumble != nil && *c.Fumble != anyDie(counted, isFumble) {
+		return false
+	}
+	if c.Crooked != nil && *c.Crooked != throw.Crooked() {
+		return false
+	}
+	return true
+}
+
+// Engine evaluates rules in order against throws
+type Engine struct {
+	rules []Rule
+}
+
+// NewEngine validates the rules and creates an engine for them
+func NewEngine(rules []Rule) (*Engine, error) {
+	for i, rule := range rules {
+		for j, action := range rule.Actions {
+			if err := action.Validate(); err != nil {
+				return nil, fmt.Errorf("rule %d (%s) action %d: %w", i, rule.Name, j, err)
+			}
+		}
+	}
+	return &Engine{rules: rules}, nil
+}
+
+// Match returns the rules that apply to the throw: the first matching rule
+// plus every matching Always rule, in order
+func (e *Engine) Match(throw pixel.Throw) []Rule {
+	var matched []Rule
+	found := false
+	for _, rule := range e.rules {
+		if found && !rule.Always {
+			continue
+		}
+		if !rule.When.Matches(throw) {
+			continue
+		}
+		matched = append(matched, rule)
+		if !rule.Always {
+			found = true
+		}
+	}
+	return matched
+}
+
+// Actions returns the actions of every rule that applies to the throw
+func (e *Engine) Actions(throw pixel.Throw) []Action {
+	var actions []Action
+	for _, rule := range e.Match(throw) {
+		actions = append(actions, rule.Actions...)
+	}
+	return actions
+}
+
+func anyDie(dice []pixel.ThrowDie, pred func(die pixel.ThrowDie) bool) bool {
+	for _, die := range dice {
+		if pred(die) {
+			return true
+		}
+	}
+	return false
+}
+
+func isCrit(die pixel.ThrowDie) bool {
+	return die.DieType != pixel.DieTypeUnknown && die.Value == die.DieType.MaxValue()
+}
+
+func isFumble(die pixel.ThrowDie) bool {
+	return die.DieType != pixel.DieTypeUnknown && die.Value == die.DieType.MinValue()
+}
+
+func containsId(ids []uint32, id uint32) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsType(types []string, dieType pixel.DieType) bool {
+	for _, candidate := range types {
+		if strings.EqualFold(candidate, dieType.String()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package rules
+
+import (
+	"context"
+	ha "godice/homeassistiant"
+	"godice/pixel"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// die is a counted die in a throw
+func die(dieType pixel.DieType, value int) pixel.ThrowDie {
+	return pixel.ThrowDie{PixelId: uint32(dieType), DieType: dieType, Value: value, Settled: true}
+}
+
+func crooked(dieType pixel.DieType) pixel.ThrowDie {
+	return pixel.ThrowDie{PixelId: uint32(dieType), DieType: dieType, Crooked: true}
+}
+
+func throw(dice ...pixel.ThrowDie) pixel.Throw {
+	return pixel.Throw{Dice: dice}
+}
+
+func TestConditionMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		when  Condition
+		throw pixel.Throw
+		want  bool
+	}{
+		{"empty matches anything", Condition{}, throw(crooked(pixel.DieTypeD20)), true},
+		{"total", Condition{Total: intPtr(7)}, throw(die(pixel.DieTypeD6, 3), die(pixel.DieTypeD8, 4)), true},
+		{"total differs", Condition{Total: intPtr(7)}, throw(die(pixel.DieTypeD6, 3)), false},
+		{"total min", Condition{TotalMin: intPtr(10)}, throw(die(pixel.DieTypeD20, 10)), true},
+		{"below total min", Condition{TotalMin: intPtr(10)}, throw(die(pixel.DieTypeD20, 9)), false},
+		{"total max", Condition{TotalMax: intPtr(1)}, throw(die(pixel.DieTypeD20, 1)), true},
+		{"above total max", Condition{TotalMax: intPtr(1)}, throw(die(pixel.DieTypeD20, 2)), false},
+		{"crooked dice are not counted", Condition{Total: intPtr(4)}, throw(die(pixel.DieTypeD6, 4), crooked(pixel.DieTypeD20)), true},
+		{"face", Condition{Face: intPtr(6)}, throw(die(pixel.DieTypeD6, 2), die(pixel.DieTypeD8, 6)), true},
+		{"face of a crooked die", Condition{Face: intPtr(0)}, throw(crooked(pixel.DieTypeD6)), false},
+		{"dice", Condition{Dice: intPtr(2)}, throw(die(pixel.DieTypeD6, 2), die(pixel.DieTypeD6, 5)), true},
+		{"dice ignores crooked", Condition{Dice: intPtr(2)}, throw(die(pixel.DieTypeD6, 2), crooked(pixel.DieTypeD6)), false},
+		{"die id", Condition{DieIds: []uint32{uint32(pixel.DieTypeD8)}}, throw(die(pixel.DieTypeD6, 1), die(pixel.DieTypeD8, 1)), true},
+		{"other die id", Condition{DieIds: []uint32{99}}, throw(die(pixel.DieTypeD6, 1)), false},
+		{"die type", Condition{DieTypes: []string{"D20"}}, throw(die(pixel.DieTypeD20, 5)), true},
+		{"other die type", Condition{DieTypes: []string{"d12"}}, throw(die(pixel.DieTypeD20, 5)), false},
+		{"crit", Condition{Crit: boolPtr(true)}, throw(die(pixel.DieTypeD20, 20)), true},
+		{"d10 crit", Condition{Crit: boolPtr(true)}, throw(die(pixel.DieTypeD10, 10)), true},
+		{"no crit", Condition{Crit: boolPtr(true)}, throw(die(pixel.DieTypeD20, 19)), false},
+		{"not crit", Condition{Crit: boolPtr(false)}, throw(die(pixel.DieTypeD20, 20)), false},
+		{"fumble", Condition{Fumble: boolPtr(true)}, throw(die(pixel.DieTypeD20, 1)), true},
+		{"d10 fumble", Condition{Fumble: boolPtr(true)}, throw(die(pixel.DieTypeD10, 1)), true},
+		{"unknown die is never a fumble", Condition{Fumble: boolPtr(true)}, throw(die(pixel.DieTypeUnknown, 1)), false},
+		{"crooked", Condition{Crooked: boolPtr(true)}, throw(die(pixel.DieTypeD6, 2), crooked(pixel.DieTypeD6)), true},
+		{"not crooked", Condition{Crooked: boolPtr(false)}, throw(die(pixel.DieTypeD6, 2), crooked(pixel.DieTypeD6)), false},
+		{"every field must match", Condition{Total: intPtr(20), DieTypes: []string{"d12"}}, throw(die(pixel.DieTypeD20, 20)), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.when.Matches(test.throw); got != test.want {
+				t.Errorf("Matches = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewEngineValidates(t *testing.T) {
+	tests := []struct {
+		action Action
+		err    string
+	}{
+		{Action{Type: ActionLightColor, Color: "nocolor"}, `invalid color "nocolor"`},
+		{Action{Type: ActionLightCycle}, "light_cycle needs colors"},
+		{Action{Type: ActionLightCycle, Colors: []string{"red", "#12345"}}, `invalid color "#12345"`},
+		{Action{Type: ActionLightTemperature}, "light_temperature needs a temperature"},
+		{Action{Type: ActionService, Domain: "light"}, "service needs a domain and service"},
+		{Action{Type: "explode"}, `unknown action type "explode"`},
+	}
+
+	for _, test := range tests {
+		_, err := NewEngine([]Rule{{Name: "ok", Actions: []Action{{Type: ActionWait}}}, {Name: "bad", Actions: []Action{test.action}}})
+		if err == nil || !strings.Contains(err.Error(), "rule 1 (bad) action 0: "+test.err) {
+			t.Errorf("NewEngine(%+v) = %v, want %q", test.action, err, test.err)
+		}
+	}
+
+	if _, err := NewEngine(DefaultRules()); err != nil {
+		t.Errorf("default rules: %v", err)
+	}
+}
+
+func TestEngineMatch(t *testing.T) {
+	engine, err := NewEngine([]Rule{
+		{Name: "log", Always: true, Actions: []Action{{Type: ActionWait}}},
+		{Name: "high", When: Condition{TotalMin: intPtr(10)}, Actions: []Action{{Type: ActionLightColor, Color: "blue"}}},
+		{Name: "any", Actions: []Action{{Type: ActionLightColor, Color: "red"}}},
+		{Name: "crit", Always: true, When: Condition{Crit: boolPtr(true)}, Actions: []Action{{Type: ActionBlink, Color: "gold"}}},
+		{Name: "unreachable", Actions: []Action{{Type: ActionLightOff}}},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+
+	tests := []struct {
+		throw pixel.Throw
+		want  []string
+	}{
+		{throw(die(pixel.DieTypeD20, 20)), []string{"log", "high", "crit"}},
+		{throw(die(pixel.DieTypeD20, 12)), []string{"log", "high"}},
+		{throw(die(pixel.DieTypeD6, 6)), []string{"log", "any", "crit"}},
+		{throw(die(pixel.DieTypeD20, 3)), []string{"log", "any"}},
+	}
+	for _, test := range tests {
+		if got := ruleNames(engine.Match(test.throw)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Match(%d) = %v, want %v", test.throw.Total(), got, test.want)
+		}
+	}
+
+	actions := engine.Actions(throw(die(pixel.DieTypeD20, 20)))
+	types := make([]string, len(actions))
+	for i, action := range actions {
+		types[i] = action.Type
+	}
+	if want := []string{ActionWait, ActionLightColor, ActionBlink}; !reflect.DeepEqual(types, want) {
+		t.Errorf("Actions = %v, want %v", types, want)
+	}
+}
+
+func TestDefaultRules(t *testing.T) {
+	engine, err := NewEngine(DefaultRules())
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		throw pixel.Throw
+		want  []string
+	}{
+		{"20", throw(die(pixel.DieTypeD20, 20)), []string{"natural 20"}},
+		{"16", throw(die(pixel.DieTypeD20, 16)), []string{"high"}},
+		{"15", throw(die(pixel.DieTypeD20, 15)), []string{"high"}},
+		{"12", throw(die(pixel.DieTypeD20, 12)), []string{"good"}},
+		{"7", throw(die(pixel.DieTypeD20, 7)), []string{"fair"}},
+		{"2", throw(die(pixel.DieTypeD20, 2)), []string{"poor"}},
+		{"1", throw(die(pixel.DieTypeD20, 1)), []string{"natural 1"}},
+		{"d10 on 1", throw(die(pixel.DieTypeD10, 1)), []string{"natural 1"}},
+		{"two dice", throw(die(pixel.DieTypeD6, 1), die(pixel.DieTypeD6, 1)), []string{"poor"}},
+		{"crooked only", throw(crooked(pixel.DieTypeD20)), nil},
+		{"1 and a crooked die", throw(die(pixel.DieTypeD20, 1), crooked(pixel.DieTypeD6)), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ruleNames(engine.Match(test.throw)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Match = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestTemplateRules checks that the rules in the config template are the defaults plus the crooked rule
+func TestTemplateRules(t *testing.T) {
+	data, err := os.ReadFile("../config.yaml.template")
+	if err != nil {
+		t.Fatalf("read template: %v", err)
+	}
+	var template struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &template); err != nil {
+		t.Fatalf("parse template: %v", err)
+	}
+
+	defaults := DefaultRules()
+	if len(template.Rules) != len(defaults)+1 {
+		t.Fatalf("template has %d rules, want the %d defaults and crooked", len(template.Rules), len(defaults))
+	}
+	for i, rule := range defaults {
+		if !reflect.DeepEqual(template.Rules[i], rule) {
+			t.Errorf("template rule %d = %+v, want %+v", i, template.Rules[i], rule)
+		}
+	}
+	if crooked := template.Rules[len(defaults)]; crooked.Name != "crooked" || !crooked.Always {
+		t.Errorf("last template rule = %+v, want the always crooked rule", crooked)
+	}
+}
+
+func ruleNames(rules []Rule) []string {
+	var names []string
+	for _, rule := range rules {
+		names = append(names, rule.Name)
+	}
+	return names
+}
+
+func TestBlinkSkipsDiceWithoutConnection(t *testing.T) {
+	transport := pixel.NewMemoryTransport()
+	connected, err := pixel.NewDie(transport)
+	if err != nil {
+		t.Fatalf("NewDie: %v", err)
+	}
+	passive := pixel.NewPassiveDie(pixel.Advertisement{PixelId: 2, LedCount: 20})
+
+	x := &Executor{HA: ha.NewClient("http://127.0.0.1:0", "token")}
+	tr := throw(
+		pixel.ThrowDie{Die: passive, PixelId: 2, DieType: pixel.DieTypeD20, Value: 20, Settled: true},
+		pixel.ThrowDie{Die: connected, PixelId: 1, DieType: pixel.DieTypeD20, Value: 20, Settled: true},
+	)
+	if err := x.Execute(context.Background(), tr, []Action{{Type: ActionBlink, Color: "gold"}}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	written := transport.Written()
+	if len(written) != 1 || written[0][0] != pixel.MsgTypeBlink {
+		t.Errorf("written = %x, want one blink to the connected die", written)
+	}
+}